@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/infrastructure"
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/interfaces/handlers"
+)
+
+var shutdownSequence = infrastructure.NewShutdownSequence()
+
+func main() {
+	var conf infrastructure.MockConfig
+	shutdownSequence.Listen()
+	infrastructure.LoadFromEnv(&conf)
+	if jconf, err := json.MarshalIndent(conf, "", "    "); err == nil {
+		fmt.Printf("Config: \n%s\n", jconf)
+	}
+
+	fmt.Printf("Setting up Prometheus\n")
+	prometheus := infrastructure.MakePrometheusExporter(
+		conf.PrometheusConf.Port,
+		conf.PrometheusConf.Enabled,
+	)
+
+	fmt.Printf("Setting up logger\n")
+	logger, err := infrastructure.MakeYapoLogger(&conf.LoggerConf,
+		prometheus.NewEventsCollector(
+			"trans-mock_service_events_total",
+			"events tracker counter for trans-mock service",
+		),
+	)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	logger.Info("Starting trans mock on %s", conf.Mock.Address)
+	mock, err := infrastructure.NewTransMock(conf.Mock.Address, logger)
+	if err != nil {
+		logger.Error("Error starting trans mock: %s", err)
+		os.Exit(2)
+	}
+	shutdownSequence.Push(mock)
+	if conf.Mock.ExpectationsFile != "" {
+		if err = mock.ExpectFile(conf.Mock.ExpectationsFile); err != nil {
+			logger.Error("Error loading trans mock expectations: %s", err)
+			os.Exit(2)
+		}
+	}
+
+	var healthHandler handlers.HealthHandler
+	expectHandler := handlers.TransMockExpectHandler{Mock: mock}
+	faultsHandler := handlers.TransMockFaultsHandler{Mock: mock}
+	callsHandler := handlers.TransMockCallsHandler{Mock: mock}
+	resetHandler := handlers.TransMockResetHandler{Mock: mock}
+	// Setting up router
+	maker := infrastructure.RouterMaker{
+		Logger: logger,
+		Cors:   conf.CorsConf,
+		WrapperFuncs: []infrastructure.WrapperFunc{
+			prometheus.TrackHandlerFunc,
+		},
+		WithProfiling: conf.Runtime.Profiling,
+		Routes: infrastructure.Routes{
+			{
+				// This is the base path, all routes will start with this prefix
+				Prefix: "/api/v{version:[1-9][0-9]*}",
+				Groups: []infrastructure.Route{
+					{
+						Name:    "Check service health",
+						Method:  "GET",
+						Pattern: "/healthcheck",
+						Handler: &healthHandler,
+					},
+					{
+						Name:    "Add trans mock expectations",
+						Method:  "POST",
+						Pattern: "/expectations",
+						Handler: &expectHandler,
+					},
+					{
+						Name:    "Set the trans mock faults",
+						Method:  "PUT",
+						Pattern: "/faults",
+						Handler: &faultsHandler,
+					},
+					{
+						Name:    "Read the commands received by the trans mock",
+						Method:  "GET",
+						Pattern: "/calls",
+						Handler: &callsHandler,
+					},
+					{
+						Name:    "Reset the trans mock",
+						Method:  "POST",
+						Pattern: "/reset",
+						Handler: &resetHandler,
+					},
+				},
+			},
+		},
+	}
+	server := infrastructure.NewHTTPServer(
+		conf.Runtime.Address(),
+		maker.NewRouter(),
+		logger,
+	)
+	shutdownSequence.Push(server)
+	logger.Info("Starting control API on %s", conf.Runtime.Address())
+	go server.ListenAndServe()
+	shutdownSequence.Wait()
+	logger.Info("Server exited normally")
+}
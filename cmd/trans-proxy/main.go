@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 
 	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/infrastructure"
 	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/interfaces/handlers"
@@ -50,18 +51,101 @@ func main() { // nolint funlen
 	var healthHandler handlers.HealthHandler
 
 	// transHandler
-	transFactory := infrastructure.NewTextProtocolTransFactory(conf.Trans, logger)
-	transRepository := services.NewTransRepo(transFactory)
+	if err = conf.Trans.LoadCommands(); err != nil {
+		logger.Error("Error loading trans commands: %s", err)
+		os.Exit(2)
+	}
+	if err = conf.Trans.CheckCharsets(); err != nil {
+		logger.Error("Error in trans charsets: %s", err)
+		os.Exit(2)
+	}
+	if _, err = conf.Trans.TLS.Config(); err != nil {
+		logger.Error("Error in trans TLS: %s", err)
+		os.Exit(2)
+	}
+	schemas, err := conf.Trans.Schemas()
+	if err != nil {
+		logger.Error("Error in trans param schemas: %s", err)
+		os.Exit(2)
+	}
+	aliases, err := conf.Trans.Aliases()
+	if err != nil {
+		logger.Error("Error in trans command aliases: %s", err)
+		os.Exit(2)
+	}
+	var transFactory infrastructure.TransFactory
+	if conf.Trans.Mode == infrastructure.TransModeFake {
+		logger.Info("Answering trans commands from the fake fixture %s", conf.Trans.FakeFixture)
+		if transFactory, err = infrastructure.NewFakeTransFactory(conf.Trans, logger); err != nil {
+			logger.Error("Error in trans fake: %s", err)
+			os.Exit(2)
+		}
+	} else {
+		transFactory = infrastructure.NewTextProtocolTransFactory(conf.Trans, logger)
+	}
+	shutdownSequence.Push(transFactory)
+	prometheus.TrackTransBackends(transFactory.Stats)
+	transRepository := services.NewTransRepo(transFactory, services.ParamsEncoding{
+		Bools: conf.Trans.Params.Bools,
+		Nulls: conf.Trans.Params.Nulls,
+	})
 	transLogger := loggers.MakeTransInteractorLogger(logger)
 	transInteractor := usecases.TransInteractor{
 		Repository: transRepository,
 		Logger:     transLogger,
+		Schemas:    schemas,
+		Aliases:    aliases,
+		DryRun: usecases.DryRunPolicy{
+			Commands: make(map[string]bool),
+			Clients:  make(map[string]bool),
+		},
+	}
+	for _, client := range strings.Split(conf.Trans.DryRunClients, ",") {
+		if client = strings.TrimSpace(client); client != "" {
+			transInteractor.DryRun.Clients[client] = true
+		}
+	}
+	for name, command := range conf.Trans.Commands {
+		transInteractor.DryRun.Commands[name] = command.DryRun
 	}
 	transHandler := handlers.TransHandler{
 		Interactor: transInteractor,
 		TokenValidationInteractor: &usecases.ValidateToken{
 			SecretToken: conf.Runtime.APIKey,
 		},
+		BusyRetryAfter: conf.Trans.Busy.ClientRetryAfter,
+		Groupings:      make(map[string]handlers.TransGrouping),
+	}
+	for name, command := range conf.Trans.Commands {
+		transHandler.Groupings[name] = command.Group
+	}
+	for name, alias := range aliases {
+		transHandler.Groupings[name] = conf.Trans.Commands[alias.Command].Group
+	}
+	captureAuth := &usecases.ValidateToken{
+		SecretToken: conf.Trans.Capture.Token,
+	}
+	if conf.Trans.Capture.Token != "" {
+		transHandler.CaptureAuth = captureAuth
+	}
+	transCapturesHandler := handlers.TransCapturesHandler{
+		Captures:                  transFactory,
+		TokenValidationInteractor: captureAuth,
+	}
+	transBatchHandler := handlers.TransBatchHandler{
+		Trans: &transHandler,
+		Interactor: usecases.TransBatchInteractor{
+			Interactor:  transInteractor,
+			Parallelism: conf.Trans.Batch.Parallelism,
+		},
+		MaxCommands: conf.Trans.Batch.MaxCommands,
+	}
+	transPipelineHandler := handlers.TransPipelineHandler{
+		Trans: &transHandler,
+		Interactor: usecases.TransPipelineInteractor{
+			Interactor: transInteractor,
+		},
+		MaxSteps: conf.Trans.Pipeline.MaxSteps,
 	}
 	// Setting up router
 	maker := infrastructure.RouterMaker{
@@ -90,10 +174,31 @@ func main() { // nolint funlen
 						Pattern: "/execute/{command}",
 						Handler: &transHandler,
 					},
+					{
+						Name:    "Execute a batch of trans requests",
+						Method:  "POST",
+						Pattern: "/batch",
+						Handler: &transBatchHandler,
+					},
+					{
+						Name:    "Execute a pipeline of trans requests",
+						Method:  "POST",
+						Pattern: "/pipeline",
+						Handler: &transPipelineHandler,
+					},
 				},
 			},
 		},
 	}
+	// the captures can only be read with the capture token
+	if conf.Trans.Capture.Token != "" {
+		maker.Routes[0].Groups = append(maker.Routes[0].Groups, infrastructure.Route{
+			Name:    "Read the captured trans requests",
+			Method:  "GET",
+			Pattern: "/admin/captures",
+			Handler: &transCapturesHandler,
+		})
+	}
 	server := infrastructure.NewHTTPServer(
 		conf.Runtime.Address(),
 		maker.NewRouter(),
@@ -6,12 +6,12 @@ require (
 	github.com/Yapo/goutils v1.2.1-0.20180424210448-721ca4146b6a
 	github.com/Yapo/logger v0.0.0-20170328173756-91855e974718
 	github.com/anevsky/cachego v0.0.0-20170305195447-977d3faf0e5b
-	github.com/eapache/go-resiliency v1.0.1-0.20180101203313-ef9aaa7ea8bd
 	github.com/gorilla/context v1.1.1
 	github.com/prometheus/client_golang v0.9.3-0.20190123153945-d5f63107bfca
 	github.com/stretchr/testify v1.7.1
 	golang.org/x/text v0.3.1-0.20190306152657-5d731a35f486
 	gopkg.in/gorilla/mux.v1 v1.6.2
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 )
 
 require (
@@ -26,5 +26,4 @@ require (
 	github.com/prometheus/procfs v0.0.0-20181204211112-1dc9a6cbc91a // indirect
 	github.com/stretchr/objx v0.1.0 // indirect
 	gopkg.in/stretchr/testify.v1 v1.2.2 // indirect
-	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
 )
@@ -0,0 +1,65 @@
+package domain
+
+import "sort"
+
+// CommandAlias is a public name of a trans command, optionally versioned
+// like get_ad@v2, so the trans command can be renamed or replaced without
+// breaking the clients
+type CommandAlias struct {
+	// Name the public name, with its version if any
+	Name string
+	// Command the trans command the alias is sent as
+	Command string
+	// Params the params renamed, from their public name to the trans one.
+	// The params not found keep their name
+	Params map[string]string
+	// Deprecated the clients should move to another alias
+	Deprecated bool
+}
+
+// Resolve returns the trans command sent for the given one, asked with
+// the alias
+func (a CommandAlias) Resolve(command TransCommand) TransCommand {
+	command.Command = a.Command
+	if len(a.Params) == 0 {
+		return command
+	}
+	params := make([]TransParams, len(command.Params))
+	for i, param := range command.Params {
+		if key, ok := a.Params[param.Key]; ok {
+			param.Key = key
+		}
+		params[i] = param
+	}
+	command.Params = params
+	return command
+}
+
+// PublicErrors returns the schema errors of the resolved command with the
+// params named as the client sent them, asked with the alias
+func (a CommandAlias) PublicErrors(command TransCommand, errs SchemaErrors) SchemaErrors {
+	if len(a.Params) == 0 {
+		return errs
+	}
+	// the missing params are named by the alias, the sent ones as they were
+	names := make(map[string]string, len(a.Params)+len(command.Params))
+	for public, key := range a.Params {
+		names[key] = public
+	}
+	for _, param := range command.Params {
+		key, ok := a.Params[param.Key]
+		if !ok {
+			key = param.Key
+		}
+		names[key] = param.Key
+	}
+	public := make(SchemaErrors, len(errs))
+	for i, e := range errs {
+		if name, ok := names[e.Key]; ok {
+			e.Key = name
+		}
+		public[i] = e
+	}
+	sort.SliceStable(public, func(i, j int) bool { return public[i].Key < public[j].Key })
+	return public
+}
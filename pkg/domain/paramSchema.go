@@ -0,0 +1,237 @@
+package domain
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// ParamString type of the params with any text, the default one
+	ParamString = "string"
+	// ParamInt type of the params with an integer number
+	ParamInt = "int"
+	// ParamFloat type of the params with any number
+	ParamFloat = "float"
+	// ParamBool type of the params with true or false, as 1 or 0 too
+	ParamBool = "bool"
+)
+
+// ParamSchema declares a param a command accepts and the values it may have
+type ParamSchema struct {
+	Name string `json:"name"`
+	// Type ParamString, ParamInt, ParamFloat or ParamBool. Empty is
+	// ParamString
+	Type string `json:"type"`
+	// Required the command can't be sent without the param
+	Required bool `json:"required"`
+	// Pattern regular expression the whole value must match
+	Pattern string `json:"pattern"`
+	// Enum the only values the param may have
+	Enum []string `json:"enum"`
+	// MaxLength max characters of the value, or bytes if it's a blob
+	MaxLength int `json:"max_length"`
+	// Blob the param must be sent as a blob. The type, pattern and enum of
+	// blobs are not checked
+	Blob bool `json:"blob"`
+
+	pattern *regexp.Regexp
+}
+
+// SchemaErrors is returned when the params of a command don't match its
+// schema, so the command is not sent
+type SchemaErrors ParamErrors
+
+// Error returns the description of every error
+func (e SchemaErrors) Error() string {
+	return "params don't match the schema: " + strings.TrimPrefix(ParamErrors(e).Error(), "invalid params: ")
+}
+
+// CommandSchema declares every param a command accepts. The params not
+// declared are refused
+type CommandSchema struct {
+	params map[string]ParamSchema
+	// order the names of the params, as they were declared
+	order []string
+}
+
+// NewCommandSchema builds the schema of a command accepting the given params
+func NewCommandSchema(params []ParamSchema) (CommandSchema, error) {
+	schema := CommandSchema{params: make(map[string]ParamSchema, len(params))}
+	for _, param := range params {
+		if param.Name == "" {
+			return schema, fmt.Errorf("param without name")
+		}
+		if _, ok := schema.params[param.Name]; ok {
+			return schema, fmt.Errorf("param %s: declared twice", param.Name)
+		}
+		switch param.Type {
+		case "":
+			param.Type = ParamString
+		case ParamString, ParamInt, ParamFloat, ParamBool:
+		default:
+			return schema, fmt.Errorf("param %s: unknown type %q", param.Name, param.Type)
+		}
+		if param.Pattern != "" {
+			pattern, err := regexp.Compile("^(?:" + param.Pattern + ")$")
+			if err != nil {
+				return schema, fmt.Errorf("param %s: %s", param.Name, err)
+			}
+			param.pattern = pattern
+		}
+		schema.params[param.Name] = param
+		schema.order = append(schema.order, param.Name)
+	}
+	return schema, nil
+}
+
+// Validate returns every param that doesn't match the schema, the unknown
+// ones and the required ones missing included, sorted by key. Null values
+// count as missing
+func (s CommandSchema) Validate(params []TransParams) SchemaErrors {
+	var errs SchemaErrors
+	present := make(map[string]bool, len(params))
+	for _, param := range params {
+		schema, ok := s.params[param.Key]
+		if !ok {
+			errs = append(errs, ParamError{Key: param.Key, Reason: "unknown param"})
+			continue
+		}
+		if param.Value == nil {
+			continue
+		}
+		present[param.Key] = true
+		if reason := schema.check(param); reason != "" {
+			errs = append(errs, ParamError{Key: param.Key, Reason: reason})
+		}
+	}
+	for _, name := range s.order {
+		if s.params[name].Required && !present[name] {
+			errs = append(errs, ParamError{Key: name, Reason: "required"})
+		}
+	}
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Key < errs[j].Key })
+	return errs
+}
+
+// Normalize returns the params with the booleans sent as strings, like
+// "true" or "1", turned into booleans, so they are written to trans like
+// the JSON ones. The params must match the schema
+func (s CommandSchema) Normalize(params []TransParams) []TransParams {
+	normalized := make([]TransParams, len(params))
+	for i, param := range params {
+		if value, ok := param.Value.(string); ok && !param.Blob && s.params[param.Key].Type == ParamBool {
+			param.Value = value == "true" || value == "1"
+		}
+		normalized[i] = param
+	}
+	return normalized
+}
+
+// check returns why the param doesn't match the schema, empty if it does
+func (p ParamSchema) check(param TransParams) string {
+	if param.Blob != p.Blob {
+		if p.Blob {
+			return "must be a blob"
+		}
+		return "can't be a blob"
+	}
+	if p.Blob {
+		value, ok := param.Value.(string)
+		if !ok {
+			return "must be a base64 string"
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return "must be base64"
+		}
+		if p.MaxLength > 0 && len(decoded) > p.MaxLength {
+			return fmt.Sprintf("longer than %d bytes", p.MaxLength)
+		}
+		return ""
+	}
+	value, ok := p.text(param.Value)
+	if !ok {
+		return "must be " + map[string]string{
+			ParamString: "a string", ParamInt: "an integer", ParamFloat: "a number", ParamBool: "a boolean",
+		}[p.Type]
+	}
+	if p.MaxLength > 0 && utf8.RuneCountInString(value) > p.MaxLength {
+		return fmt.Sprintf("longer than %d characters", p.MaxLength)
+	}
+	if len(p.Enum) > 0 && !contains(p.Enum, value) {
+		return "must be one of " + strings.Join(p.Enum, ", ")
+	}
+	if p.pattern != nil && !p.pattern.MatchString(value) {
+		return fmt.Sprintf("doesn't match %s", p.Pattern)
+	}
+	return ""
+}
+
+// text returns the value as the text the enum, pattern and length are
+// checked on, telling if the value has the type of the param. Numbers and
+// booleans may be sent as strings
+func (p ParamSchema) text(value interface{}) (string, bool) {
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case bool:
+		return strconv.FormatBool(v), p.Type == ParamBool
+	case json.Number:
+		return p.number(string(v))
+	case float32:
+		return p.number(strconv.FormatFloat(float64(v), 'f', -1, 32))
+	case float64:
+		return p.number(strconv.FormatFloat(v, 'f', -1, 64))
+	default:
+		switch v := reflect.ValueOf(value); v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return p.number(strconv.FormatInt(v.Int(), 10))
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return p.number(strconv.FormatUint(v.Uint(), 10))
+		}
+		return "", false
+	}
+	switch p.Type {
+	case ParamInt, ParamFloat:
+		return p.number(s)
+	case ParamBool:
+		return s, contains([]string{"true", "false", "1", "0"}, s)
+	}
+	return s, true
+}
+
+// number tells if the number s has the type of the param
+func (p ParamSchema) number(s string) (string, bool) {
+	switch p.Type {
+	case ParamInt:
+		if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return s, true
+		}
+		// numbers like 1e3 are integers too
+		f, err := strconv.ParseFloat(s, 64)
+		return s, err == nil && f == math.Trunc(f) && !math.IsInf(f, 0)
+	case ParamFloat:
+		f, err := strconv.ParseFloat(s, 64)
+		return s, err == nil && !math.IsInf(f, 0) && !math.IsNaN(f)
+	}
+	return s, false
+}
+
+// contains tells if values has value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
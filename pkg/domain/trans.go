@@ -1,5 +1,90 @@
 package domain
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TransNoCommand status trans answers to the commands it doesn't know
+const TransNoCommand = "TRANS_ERROR_NO_SUCH_COMMAND:Err no such command"
+
+// ErrTransBusy is returned when the trans server is too busy to accept
+// the command, so it may be sent again later
+var ErrTransBusy = errors.New("trans server is busy")
+
+// ErrTransUnavailable is returned when trans can't be reached, like when it
+// can't be connected, so the command may be sent again later
+var ErrTransUnavailable = errors.New("trans is unavailable")
+
+// ErrDryRunNotAllowed is returned when a dry run is asked for a command, or
+// by a client, that can't use it
+var ErrDryRunNotAllowed = errors.New("dry run is not allowed")
+
+// TimeoutError is returned when a phase of the exchange with trans, like
+// connecting or reading the response, takes longer than allowed
+type TimeoutError struct {
+	// Phase the phase that timed out
+	Phase string
+	// Timeout the time the phase was allowed to take
+	Timeout time.Duration
+}
+
+// Error returns the description of the error
+func (e TimeoutError) Error() string {
+	return fmt.Sprintf("trans timeout in the %s phase after %s", e.Phase, e.Timeout)
+}
+
+// ResponseLimitError is returned when a response of trans exceeds one of
+// the limits of its command, like its size or number of lines. The rest of
+// the response is not read
+type ResponseLimitError struct {
+	// Limit the limit that was exceeded
+	Limit string
+	// Max the value of the limit
+	Max int
+}
+
+// Error returns the description of the error
+func (e ResponseLimitError) Error() string {
+	return fmt.Sprintf("trans response exceeds the %s limit of %d", e.Limit, e.Max)
+}
+
+// ParamError tells why a param of a command can't be sent to trans
+type ParamError struct {
+	Key    string `json:"key"`
+	Reason string `json:"reason"`
+}
+
+// Error returns the description of the error
+func (e ParamError) Error() string {
+	return fmt.Sprintf("param %s: %s", e.Key, e.Reason)
+}
+
+// ParamErrors is returned when one or more params of a command are invalid,
+// so the command is not sent
+type ParamErrors []ParamError
+
+// Error returns the description of every error
+func (e ParamErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, paramError := range e {
+		messages = append(messages, paramError.Error())
+	}
+	return "invalid params: " + strings.Join(messages, ", ")
+}
+
+// EncodingErrors is returned when one or more params can't be written in
+// the charset of the trans server, so the command is not sent
+type EncodingErrors ParamErrors
+
+// Error returns the description of every error
+func (e EncodingErrors) Error() string {
+	return "params can't be encoded: " + strings.TrimPrefix(ParamErrors(e).Error(), "invalid params: ")
+}
+
 // TransParams is a struct with Trans format params
 type TransParams struct {
 	Key   string
@@ -13,18 +98,123 @@ type TransCommand struct {
 	Command string
 	// Params the params of the command
 	Params []TransParams
+	// DryRun tells trans to run the command without committing its changes
+	DryRun bool
+	// Client who sent the command, as it identified itself
+	Client string
+	// Capture records the exchange with trans, as it was on the wire, to
+	// debug the command
+	Capture bool
+}
+
+// CheckProtocol tells which parts of the command would change the meaning
+// of the text sent to trans: a newline in a value would start a new line,
+// that may be a param never sent, a commit or even another command. Keys
+// can't have ':' nor newlines nor be one of the lines of the protocol, like
+// cmd or commit, values can't have newlines unless they are blobs, and the
+// command name can only have letters, digits, '_' and '-'
+func (c TransCommand) CheckProtocol() ParamErrors {
+	var errs ParamErrors
+	for _, r := range c.Command {
+		if !isCommandNameRune(r) {
+			errs = append(errs, ParamError{
+				Key:    "cmd",
+				Reason: fmt.Sprintf("command name has invalid character %q", r),
+			})
+			break
+		}
+	}
+	for _, param := range c.Params {
+		if param.Key == "" {
+			errs = append(errs, ParamError{Key: param.Key, Reason: "empty key"})
+		} else if strings.ContainsAny(param.Key, ":\r\n") {
+			errs = append(errs, ParamError{Key: param.Key, Reason: "key has ':' or newlines"})
+		} else if isReservedKey(param.Key) {
+			errs = append(errs, ParamError{Key: param.Key, Reason: "reserved key"})
+		}
+		if value, ok := param.Value.(string); ok && !param.Blob && strings.ContainsAny(value, "\r\n") {
+			errs = append(errs, ParamError{Key: param.Key, Reason: "value has newlines, send it as a blob"})
+		}
+	}
+	return errs
+}
+
+// isReservedKey tells if the key is a line of the protocol: the command,
+// the commit, the end of the command or a blob
+func isReservedKey(key string) bool {
+	switch key {
+	case "cmd", "commit", "end":
+		return true
+	}
+	return strings.HasPrefix(key, "blob")
+}
+
+// isCommandNameRune tells if r can be part of a command name
+func isCommandNameRune(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-'
+}
+
+// TransField is a key-value pair returned by trans
+type TransField struct {
+	Key   string
+	Value string
+	// Blob the raw bytes of the value when trans sent it as a blob, nil
+	// otherwise. Value is empty for blobs
+	Blob []byte
+}
+
+// IsBlob tells if the value was sent as a blob
+func (f TransField) IsBlob() bool {
+	return f.Blob != nil
+}
+
+// TransFields the key-value pairs returned by trans, in the order they were
+// sent. The same key may appear several times, as in list-style commands
+type TransFields []TransField
+
+// Map returns the fields as a map. When a key is repeated its last value is
+// kept. Blobs are kept as their raw bytes
+func (f TransFields) Map() map[string]string {
+	m := make(map[string]string, len(f))
+	for _, field := range f {
+		if field.IsBlob() {
+			m[field.Key] = string(field.Blob)
+			continue
+		}
+		m[field.Key] = field.Value
+	}
+	return m
+}
+
+// Get returns the first value of the given key and if it was found
+func (f TransFields) Get(key string) (string, bool) {
+	for _, field := range f {
+		if field.Key == key {
+			return field.Value, true
+		}
+	}
+	return "", false
 }
 
 // TransResponse represents the response given to the execution of a TransCommand
 type TransResponse struct {
 	// Status the status of the response (normally TRANS_OK or TRANS_ERROR)
 	Status string
-	// Params additional params returned
+	// Params additional params returned. When a key is repeated only its
+	// last value is kept, Fields has all of them
 	Params map[string]string
+	// Fields additional params returned, in order and with repeated keys
+	Fields TransFields
+	// Warnings things that went wrong without failing the command, like
+	// characters that had to be replaced
+	Warnings []string
+	// Deprecated the command was asked with a deprecated alias
+	Deprecated bool
 }
 
 // TransRepository defines a storage for the trans-proxy commands
 type TransRepository interface {
-	// Execute executes the command on a trans-proxy server
-	Execute(command TransCommand) (TransResponse, error)
+	// Execute executes the command on a trans-proxy server. It gives up
+	// once ctx is done
+	Execute(ctx context.Context, command TransCommand) (TransResponse, error)
 }
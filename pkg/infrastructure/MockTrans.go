@@ -3,9 +3,12 @@ package infrastructure
 import (
 	"bufio"
 	"bytes"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
+	"os"
+	"strings"
 	"sync"
 )
 
@@ -28,12 +31,17 @@ const (
 // without the "end\n" message
 type Handler func([]byte) []byte
 
+// DisconnectingHandler is a Handler that can also drop the connection:
+// when it returns an error, the response is written and the connection is
+// closed without the "end\n" message
+type DisconnectingHandler func([]byte) ([]byte, error)
+
 // MockTransServer the struct tht represents a Mock trans server
 type MockTransServer struct {
 	Address  string
 	IsBusy   bool
 	listener net.Listener
-	handler  Handler
+	handler  DisconnectingHandler
 	mtx      sync.RWMutex
 }
 
@@ -43,6 +51,9 @@ func (srv *MockTransServer) Start() {
 		panic("trans test server already started")
 	}
 	srv.Address = srv.listener.Addr().String()
+	if srv.listener.Addr().Network() == "unix" {
+		srv.Address = TransUnixScheme + srv.Address
+	}
 	go func() {
 		_ = srv.Serve(srv.listener) // nolint: gosec
 	}()
@@ -73,58 +84,78 @@ func (srv *MockTransServer) handleRequest(conn io.ReadWriteCloser) {
 	busy := srv.IsBusy
 	srv.mtx.RUnlock()
 
+	// the client may have failed the TLS handshake, so the greeting can't
+	// be written
 	if busy {
-		_, err := conn.Write([]byte(BusyMessage))
-		if err != nil {
-			panic(err)
-		}
+		_, _ = conn.Write([]byte(BusyMessage)) // nolint: gosec
 		return
 	}
-	_, err := conn.Write([]byte(WelcomeMessage))
+	if _, err := conn.Write([]byte(WelcomeMessage)); err != nil {
+		return
+	}
+
+	// as trans does, a single command is answered on each connection, which
+	// is closed after the response
+	args, err := srv.readCommand(bufio.NewReader(conn))
 	if err != nil {
-		panic(err)
+		return
 	}
+	// the client may give up waiting and close the connection
+	_ = srv.respond(conn, args) // nolint: gosec
+}
 
-	br := bufio.NewReader(conn)
+// readCommand reads a command up to the end message. It returns io.EOF if
+// the client closed the connection without sending a full command, or the
+// error reading it, like a reset connection
+func (srv *MockTransServer) readCommand(br *bufio.Reader) ([]byte, error) {
 	var args []byte
-
 	for {
-		buf, err := br.ReadBytes('\n') // nolint: vetshadow
+		buf, err := br.ReadBytes('\n')
 		if err != nil {
-			if err != io.EOF {
-				panic(err)
-			}
-			break
+			return args, err
 		}
 
 		args = append(args, buf...)
 
 		if bytes.Equal(buf, []byte(EndMessage)) {
-			break
+			return args, nil
 		}
 	}
+}
 
+// respond writes the response given by the handler to the command, followed
+// by the end message. It returns the error of the handler, if any, after
+// writing the response
+func (srv *MockTransServer) respond(conn io.Writer, args []byte) error {
 	// get the handler and pass the args to ger a response
 	srv.mtx.RLock()
 	h := srv.handler
 	srv.mtx.RUnlock()
 
 	if h != nil {
-		res := h(args)
-		_, err = conn.Write(res)
-		if err != nil {
-			panic(err)
+		res, disconnect := h(args)
+		if _, err := conn.Write(res); err != nil {
+			return err
+		}
+		if disconnect != nil {
+			return disconnect
 		}
 	}
 	// add the end of the message
-	_, err = conn.Write([]byte(EndMessage))
-	if err != nil {
-		panic(err)
-	}
+	_, err := conn.Write([]byte(EndMessage))
+	return err
 }
 
 // SetHandler sets handler function.
 func (srv *MockTransServer) SetHandler(h Handler) {
+	srv.SetDisconnectingHandler(func(args []byte) ([]byte, error) {
+		return h(args), nil
+	})
+}
+
+// SetDisconnectingHandler sets a handler function that can drop the
+// connection.
+func (srv *MockTransServer) SetDisconnectingHandler(h DisconnectingHandler) {
 	srv.mtx.Lock()
 	srv.handler = h
 	srv.mtx.Unlock()
@@ -137,6 +168,72 @@ func (srv *MockTransServer) SetBusy(busy bool) {
 	srv.mtx.Unlock()
 }
 
+// Replay answers the commands with the responses of the records with the
+// same command, params and commit, in the order they were recorded. Once
+// the responses of a command run out, the last one is repeated. The params
+// masked in the records match any value. Commands never recorded get a
+// TRANS_ERROR telling so
+func (srv *MockTransServer) Replay(records []TransRecord) error {
+	wires := make([]transWire, len(records))
+	redacted := make(map[string]bool)
+	for i, record := range records {
+		wire, err := record.wire()
+		if err != nil {
+			return err
+		}
+		for _, param := range wire.params {
+			if string(param.value) == redactedValue {
+				redacted[strings.ToLower(param.key)] = true
+			}
+		}
+		wires[i] = wire
+	}
+	responses := make(map[string][][]byte)
+	for i, record := range records {
+		match := wires[i].mask(redacted).match()
+		response, err := record.response()
+		if err != nil {
+			return fmt.Errorf("response of %s: %s", record.Command, err)
+		}
+		responses[match] = append(responses[match], response)
+	}
+	var mtx sync.Mutex
+	replayed := make(map[string]int)
+	srv.SetHandler(func(input []byte) []byte {
+		wire, err := parseTransWire(input)
+		if err != nil {
+			return []byte(fmt.Sprintf("status:TRANS_ERROR\nerror:%s\n", err))
+		}
+		match := wire.mask(redacted).match()
+		recorded := responses[match]
+		if len(recorded) == 0 {
+			return []byte(fmt.Sprintf("status:TRANS_ERROR\nerror:no recorded response for %s\n", wire.command))
+		}
+		mtx.Lock()
+		i := replayed[match]
+		if i < len(recorded)-1 {
+			replayed[match]++
+		}
+		mtx.Unlock()
+		return recorded[i]
+	})
+	return nil
+}
+
+// ReplayFile replays the recording at path, see Replay
+func (srv *MockTransServer) ReplayFile(path string) error {
+	file, err := os.Open(path) // nolint: gosec
+	if err != nil {
+		return err
+	}
+	defer file.Close() // nolint: errcheck
+	records, err := ReadTransRecords(file)
+	if err != nil {
+		return err
+	}
+	return srv.Replay(records)
+}
+
 // NewMockTransServer starts and returns a new Server.
 // The caller should call Close when finished, to shut it down.
 func NewMockTransServer() *MockTransServer {
@@ -147,6 +244,33 @@ func NewMockTransServer() *MockTransServer {
 	return s
 }
 
+// NewMockTransTLSServer starts and returns a new Server that only accepts
+// TLS connections, with the given configuration.
+// The caller should call Close when finished, to shut it down.
+func NewMockTransTLSServer(config *tls.Config) *MockTransServer {
+	s := &MockTransServer{
+		listener: tls.NewListener(newLocalListener(), config),
+	}
+	s.Start()
+	return s
+}
+
+// NewMockTransUnixServer starts and returns a new Server listening on a
+// unix socket at path, its Address being unix://path.
+// The caller should call Close when finished, to shut it down and remove
+// the socket.
+func NewMockTransUnixServer(path string) *MockTransServer {
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		panic(fmt.Sprintf("trans test: failed to listen on %s: %v", path, err))
+	}
+	s := &MockTransServer{
+		listener: l,
+	}
+	s.Start()
+	return s
+}
+
 // newLocalListener starts a new TCP listener on the next available port
 func newLocalListener() net.Listener {
 	l, err := net.Listen("tcp", "127.0.0.1:0")
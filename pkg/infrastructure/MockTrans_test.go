@@ -2,10 +2,13 @@ package infrastructure
 
 import (
 	"bufio"
+	"context"
+	"io"
 	"net"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/domain"
 )
 
 func TestMockTransServerNoHandler(t *testing.T) {
@@ -78,3 +81,59 @@ func TestMockTransServerBusy(t *testing.T) {
 	assert.Error(t, err)
 	assert.Empty(t, res)
 }
+
+func TestMockTransServerClosesAfterCommand(t *testing.T) {
+	srv := NewMockTransServer()
+	srv.SetHandler(func(args []byte) []byte {
+		return []byte("status:TRANS_OK\n")
+	})
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Address)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	welcome, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, WelcomeMessage, welcome)
+
+	_, err = conn.Write([]byte("cmd:foo\ncommit:1\nend\n"))
+	assert.NoError(t, err)
+	res, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "status:TRANS_OK\n", res)
+	end, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, EndMessage, end)
+
+	// as trans does, the connection is closed after a single command
+	_, err = reader.ReadString('\n')
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestMockTransServerConnectionReset(t *testing.T) {
+	srv := NewMockTransServer()
+	srv.SetHandler(func(args []byte) []byte {
+		return []byte("status:TRANS_OK\n")
+	})
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Address)
+	assert.NoError(t, err)
+	_, err = bufio.NewReader(conn).ReadString('\n')
+	assert.NoError(t, err)
+	// closing with linger 0 resets the connection in the middle of a command
+	_, err = conn.Write([]byte("cmd:foo\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, conn.(*net.TCPConn).SetLinger(0))
+	assert.NoError(t, conn.Close())
+
+	// the server keeps answering the rest of clients
+	conf := TransConf{Host: srv.Address, Timeout: 15, AllowedCommands: "foo"}
+	transFactory := NewTextProtocolTransFactory(conf, &MockLoggerInfrastructure{})
+	defer transFactory.Close()
+	reply, err := transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: "foo"})
+	assert.NoError(t, err)
+	assert.Equal(t, domain.TransFields{{Key: "status", Value: "TRANS_OK"}}, reply.Fields)
+}
@@ -0,0 +1,180 @@
+package infrastructure
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// BreakerClosed state of a breaker that lets every command through
+	BreakerClosed = "closed"
+	// BreakerOpen state of a breaker that fails every command fast
+	BreakerOpen = "open"
+	// BreakerHalfOpen state of a breaker that lets a few probe commands
+	// through to decide if it should close again
+	BreakerHalfOpen = "half-open"
+)
+
+// errBreakerOpen is returned when a command is not sent because the
+// breaker of the backend is open
+var errBreakerOpen error = unavailableError("trans-proxy: circuit breaker is open")
+
+// TransBreakerStats is a snapshot of the state of a circuit breaker
+type TransBreakerStats struct {
+	// State current state of the breaker
+	State string
+	// Transitions number of times the breaker changed from a state to
+	// another, keyed by [from, to]
+	Transitions map[[2]string]int64
+}
+
+// circuitBreaker stops sending commands to a backend once the ratio of
+// failed commands goes over the configured threshold
+type circuitBreaker struct {
+	conf TransBreakerConf
+	// onChange is called, holding the lock, every time the state changes
+	onChange func(from, to string)
+
+	mtx         sync.Mutex
+	state       string
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	probes      int
+	successes   int
+	transitions map[[2]string]int64
+}
+
+// newCircuitBreaker creates a closed breaker
+func newCircuitBreaker(conf TransBreakerConf, onChange func(from, to string)) *circuitBreaker {
+	return &circuitBreaker{
+		conf:        conf,
+		onChange:    onChange,
+		state:       BreakerClosed,
+		windowStart: time.Now(),
+		transitions: make(map[[2]string]int64),
+	}
+}
+
+// Allow tells if a command can be sent. When it can, either done must be
+// called with the outcome of the command, or release if the outcome says
+// nothing about the backend, like a command the client canceled
+func (cb *circuitBreaker) Allow() (done func(success bool), release func(), err error) {
+	if cb.conf.ErrorRatio <= 0 {
+		return func(bool) {}, func() {}, nil
+	}
+	cb.mtx.Lock()
+	defer cb.mtx.Unlock()
+	now := time.Now()
+	if cb.state == BreakerOpen && now.Sub(cb.openedAt) >= cb.conf.OpenTimeout {
+		cb.changeState(BreakerHalfOpen, now)
+	}
+	switch cb.state {
+	case BreakerOpen:
+		return nil, nil, errBreakerOpen
+	case BreakerHalfOpen:
+		if cb.probes >= cb.halfOpenProbes() {
+			return nil, nil, errBreakerOpen
+		}
+		cb.probes++
+		openedAt := cb.openedAt
+		return cb.doneProbe, func() { cb.releaseProbe(openedAt) }, nil
+	}
+	return cb.done, func() {}, nil
+}
+
+// Stats returns a snapshot of the breaker state
+func (cb *circuitBreaker) Stats() TransBreakerStats {
+	cb.mtx.Lock()
+	defer cb.mtx.Unlock()
+	transitions := make(map[[2]string]int64, len(cb.transitions))
+	for key, value := range cb.transitions {
+		transitions[key] = value
+	}
+	return TransBreakerStats{
+		State:       cb.state,
+		Transitions: transitions,
+	}
+}
+
+// done counts the outcome of a command sent while closed, opening the
+// breaker if the failures ratio reached the threshold
+func (cb *circuitBreaker) done(success bool) {
+	cb.mtx.Lock()
+	defer cb.mtx.Unlock()
+	now := time.Now()
+	if cb.state != BreakerClosed {
+		return
+	}
+	if now.Sub(cb.windowStart) > cb.conf.Window {
+		cb.windowStart = now
+		cb.requests = 0
+		cb.failures = 0
+	}
+	cb.requests++
+	if success {
+		return
+	}
+	cb.failures++
+	if cb.requests >= cb.conf.MinRequests &&
+		float64(cb.failures)/float64(cb.requests) >= cb.conf.ErrorRatio {
+		cb.changeState(BreakerOpen, now)
+	}
+}
+
+// doneProbe counts the outcome of a probe sent while half-open. A single
+// failure opens the breaker again, while HalfOpenProbes successes close it
+func (cb *circuitBreaker) doneProbe(success bool) {
+	cb.mtx.Lock()
+	defer cb.mtx.Unlock()
+	if cb.state != BreakerHalfOpen {
+		return
+	}
+	now := time.Now()
+	if !success {
+		cb.changeState(BreakerOpen, now)
+		return
+	}
+	cb.successes++
+	if cb.successes >= cb.halfOpenProbes() {
+		cb.changeState(BreakerClosed, now)
+	}
+}
+
+// releaseProbe lets another probe through in place of one whose outcome
+// was not counted, unless the breaker changed its state meanwhile
+func (cb *circuitBreaker) releaseProbe(openedAt time.Time) {
+	cb.mtx.Lock()
+	defer cb.mtx.Unlock()
+	if cb.state == BreakerHalfOpen && cb.openedAt.Equal(openedAt) && cb.probes > 0 {
+		cb.probes--
+	}
+}
+
+// halfOpenProbes number of probes to let through while half-open, at least one
+func (cb *circuitBreaker) halfOpenProbes() int {
+	if cb.conf.HalfOpenProbes < 1 {
+		return 1
+	}
+	return cb.conf.HalfOpenProbes
+}
+
+// changeState moves the breaker to the given state, resetting its
+// counters. Must be called holding the lock
+func (cb *circuitBreaker) changeState(state string, now time.Time) {
+	from := cb.state
+	cb.state = state
+	cb.windowStart = now
+	cb.requests = 0
+	cb.failures = 0
+	cb.probes = 0
+	cb.successes = 0
+	if state == BreakerOpen {
+		cb.openedAt = now
+	}
+	cb.transitions[[2]string{from, state}]++
+	if cb.onChange != nil {
+		cb.onChange(from, state)
+	}
+}
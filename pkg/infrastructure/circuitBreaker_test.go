@@ -0,0 +1,119 @@
+package infrastructure
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	cb := newCircuitBreaker(TransBreakerConf{}, nil)
+	for i := 0; i < 10; i++ {
+		done, _, err := cb.Allow()
+		assert.NoError(t, err)
+		done(false)
+	}
+	assert.Equal(t, BreakerClosed, cb.Stats().State)
+}
+
+func TestCircuitBreakerOpens(t *testing.T) {
+	var changes [][2]string
+	cb := newCircuitBreaker(TransBreakerConf{
+		ErrorRatio:  0.5,
+		MinRequests: 4,
+		Window:      time.Minute,
+		OpenTimeout: time.Minute,
+	}, func(from, to string) {
+		changes = append(changes, [2]string{from, to})
+	})
+
+	for _, success := range []bool{true, false, true, false} {
+		done, _, err := cb.Allow()
+		assert.NoError(t, err)
+		done(success)
+	}
+	_, _, err := cb.Allow()
+	assert.Equal(t, errBreakerOpen, err)
+
+	stats := cb.Stats()
+	assert.Equal(t, BreakerOpen, stats.State)
+	assert.Equal(t, map[[2]string]int64{{BreakerClosed, BreakerOpen}: 1}, stats.Transitions)
+	assert.Equal(t, [][2]string{{BreakerClosed, BreakerOpen}}, changes)
+}
+
+func TestCircuitBreakerRatioBelowThreshold(t *testing.T) {
+	cb := newCircuitBreaker(TransBreakerConf{
+		ErrorRatio:  0.5,
+		MinRequests: 4,
+		Window:      time.Minute,
+	}, nil)
+
+	for _, success := range []bool{false, true, true, true, false} {
+		done, _, err := cb.Allow()
+		assert.NoError(t, err)
+		done(success)
+	}
+	assert.Equal(t, BreakerClosed, cb.Stats().State)
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	cb := newCircuitBreaker(TransBreakerConf{
+		ErrorRatio:     1,
+		MinRequests:    1,
+		Window:         time.Minute,
+		OpenTimeout:    10 * time.Millisecond,
+		HalfOpenProbes: 2,
+	}, nil)
+
+	done, _, _ := cb.Allow()
+	done(false)
+	time.Sleep(20 * time.Millisecond)
+
+	// a failed probe opens the breaker again
+	probe, _, err := cb.Allow()
+	assert.NoError(t, err)
+	assert.Equal(t, BreakerHalfOpen, cb.Stats().State)
+	probe(false)
+	assert.Equal(t, BreakerOpen, cb.Stats().State)
+	time.Sleep(20 * time.Millisecond)
+
+	// only HalfOpenProbes commands are let through
+	first, _, err := cb.Allow()
+	assert.NoError(t, err)
+	second, _, err := cb.Allow()
+	assert.NoError(t, err)
+	_, _, err = cb.Allow()
+	assert.Equal(t, errBreakerOpen, err)
+
+	first(true)
+	assert.Equal(t, BreakerHalfOpen, cb.Stats().State)
+	second(true)
+	assert.Equal(t, BreakerClosed, cb.Stats().State)
+}
+
+func TestCircuitBreakerReleasedProbe(t *testing.T) {
+	cb := newCircuitBreaker(TransBreakerConf{
+		ErrorRatio:  1,
+		MinRequests: 1,
+		Window:      time.Minute,
+		OpenTimeout: 10 * time.Millisecond,
+	}, nil)
+
+	done, _, _ := cb.Allow()
+	done(false)
+	time.Sleep(20 * time.Millisecond)
+
+	// a released probe neither closes nor opens the breaker, and another
+	// probe is let through in its place
+	_, release, err := cb.Allow()
+	assert.NoError(t, err)
+	_, _, err = cb.Allow()
+	assert.Equal(t, errBreakerOpen, err)
+	release()
+	assert.Equal(t, BreakerHalfOpen, cb.Stats().State)
+	probe, _, err := cb.Allow()
+	assert.NoError(t, err)
+	probe(true)
+	assert.Equal(t, BreakerClosed, cb.Stats().State)
+}
@@ -1,13 +1,19 @@
 package infrastructure
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
+
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/domain"
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/interfaces/handlers"
+	"gopkg.in/yaml.v3"
 )
 
 // RuntimeConfig config to start the app
@@ -85,14 +91,442 @@ type TransConf struct {
 	// AllowedCommands is a list with one or more trans commands, separated by '|'
 	// that indicates the allowed commands to be sent by this service
 	AllowedCommands string `env:"COMMANDS" envDefault:"transinfo"`
-	// Host is the host of the trans Server
+	// Host is the host of the trans Server. Several backends can be given
+	// separated by ',', each one as host, host:port or unix:///path of a
+	// unix socket
 	Host string `env:"HOST" envDefault:"localhost"`
-	// Port is the port of the trans server
+	// Port is the port of the trans server, used by the hosts without one
 	Port int `env:"PORT" envDefault:"20005"`
-	// Timeout wait time before a request times out
+	// Balancer is the strategy to pick the backend of each command,
+	// round-robin or least-in-flight
+	Balancer string `env:"BALANCER" envDefault:"round-robin"`
+	// Timeout wait time, in seconds, before a command times out
 	Timeout int `env:"TIMEOUT" envDefault:"15"`
-	// RetryAfter wait time between reconnection to the trans server
-	RetryAfter int `env:"RETRY" envDefault:"5"`
+	// Timeouts holds the time each phase of the commands may take
+	Timeouts TransTimeoutsConf `env:"TIMEOUTS_"`
+	// Limits holds the max size of the responses
+	Limits TransLimitsConf `env:"LIMITS_"`
+	// Retry is the retry policy of the commands without one of their own
+	Retry TransRetryConf `env:"RETRY_"`
+	// CommandsFile path of a json file with the settings of each command,
+	// keyed by command name. See TransCommandConf
+	CommandsFile string `env:"COMMANDS_FILE"`
+	// Commands settings of each command, loaded from CommandsFile
+	Commands map[string]TransCommandConf
+	// Pool holds the limits of the pool of connections kept open to trans
+	Pool TransPoolConf `env:"POOL_"`
+	// HealthCheck holds how the trans backends are probed
+	HealthCheck TransHealthCheckConf `env:"HEALTH_CHECK_"`
+	// Busy holds how to react when trans greets with 521 Busy
+	Busy TransBusyConf `env:"BUSY_"`
+	// Breaker holds when to stop sending commands to a failing backend
+	Breaker TransBreakerConf `env:"BREAKER_"`
+	// Params holds how the param values are written on the commands
+	Params TransParamsConf `env:"PARAMS_"`
+	// Charset the commands are written and the responses read with:
+	// iso-8859-1, windows-1252 or utf-8
+	Charset string `env:"CHARSET" envDefault:"iso-8859-1"`
+	// BackendCharsets overrides Charset for some backends, as a list of
+	// host:port=charset separated by ','
+	BackendCharsets string `env:"BACKEND_CHARSETS"`
+	// CharsetMode what to do with the params that can't be written in the
+	// charset: strict rejects the command and lenient replaces the characters
+	CharsetMode string `env:"CHARSET_MODE" envDefault:"strict"`
+	// DryRunClients the clients, as sent on X-Client-Id, that may ask for
+	// dry runs of the commands that allow them, separated by ','. * allows
+	// any client
+	DryRunClients string `env:"DRY_RUN_CLIENTS"`
+	// Batch holds the limits of the batches of commands
+	Batch TransBatchConf `env:"BATCH_"`
+	// Pipeline holds the limits of the pipelines of commands
+	Pipeline TransPipelineConf `env:"PIPELINE_"`
+	// Capture holds where the debug captures of the commands go
+	Capture TransCaptureConf `env:"CAPTURE_"`
+	// RecordFile path of a jsonl file every command and its response are
+	// appended to, to be replayed with MockTransServer. Empty disables it
+	RecordFile string `env:"RECORD_FILE"`
+	// TLS holds how the connections to trans are encrypted, if they are
+	TLS TransTLSConf `env:"TLS_"`
+	// Mode TransModeReal to send the commands to trans, or TransModeFake
+	// to answer them from FakeFixture, with no trans at all
+	Mode string `env:"MODE" envDefault:"real"`
+	// FakeFixture path of the yaml or json file with the responses of the
+	// fake trans, see TransFakeFixture
+	FakeFixture string `env:"FAKE_FIXTURE"`
+}
+
+// TransTLSConf holds how the connections to trans are encrypted
+type TransTLSConf struct {
+	// Enabled connects to trans using TLS
+	Enabled bool `env:"ENABLED" envDefault:"false"`
+	// CAFile path of the PEM bundle of the CAs trans certificates are
+	// verified with. Empty uses the CAs of the system
+	CAFile string `env:"CA_FILE"`
+	// CertFile and KeyFile paths of the PEM client certificate and key,
+	// for mutual TLS. Empty sends no client certificate
+	CertFile string `env:"CERT_FILE"`
+	KeyFile  string `env:"KEY_FILE"`
+	// ServerName name expected in the certificates of trans. Empty uses the
+	// host of each backend
+	ServerName string `env:"SERVER_NAME"`
+	// MinVersion minimum TLS version accepted: 1.0, 1.1, 1.2 or 1.3
+	MinVersion string `env:"MIN_VERSION" envDefault:"1.2"`
+}
+
+// TransBatchConf holds the limits of the batches of commands
+type TransBatchConf struct {
+	// Parallelism max number of commands of a batch sent at the same time
+	Parallelism int `env:"PARALLELISM" envDefault:"4"`
+	// MaxCommands max number of commands of a batch
+	MaxCommands int `env:"MAX_COMMANDS" envDefault:"20"`
+}
+
+// TransPipelineConf holds the limits of the pipelines of commands
+type TransPipelineConf struct {
+	// MaxSteps max number of steps of a pipeline
+	MaxSteps int `env:"MAX_STEPS" envDefault:"10"`
+}
+
+// TransCaptureConf holds where the debug captures of the commands go, and
+// which params are masked in them
+type TransCaptureConf struct {
+	// Sink where the captures are sent: CaptureSinkLog or CaptureSinkRing
+	Sink string `env:"SINK" envDefault:"ring"`
+	// RingSize number of captures kept by CaptureSinkRing, the oldest ones
+	// are dropped
+	RingSize int `env:"RING_SIZE" envDefault:"100"`
+	// Redact keys of the params masked in the captures and the recordings,
+	// separated by '|'. They are matched ignoring the case
+	Redact string `env:"REDACT" envDefault:"passwd|password|token"`
+	// Token allows capturing any command with the X-Trans-Capture header,
+	// and reading the captures. Empty disables both
+	Token string `env:"TOKEN" json:"-"`
+}
+
+// redactKeys returns the keys of the params to mask, in lower case
+func (c TransCaptureConf) redactKeys() map[string]bool {
+	redact := make(map[string]bool)
+	for _, key := range strings.Split(c.Redact, "|") {
+		if key = strings.TrimSpace(key); key != "" {
+			redact[strings.ToLower(key)] = true
+		}
+	}
+	return redact
+}
+
+// backendCharset returns the charset of the backend with the given address
+func (c TransConf) backendCharset(address string) (transCharset, error) {
+	name := c.Charset
+	for _, override := range strings.Split(c.BackendCharsets, ",") {
+		parts := strings.SplitN(strings.TrimSpace(override), "=", 2)
+		if len(parts) == 2 && parts[0] == address {
+			name = parts[1]
+		}
+	}
+	return newTransCharset(name, c.CharsetMode)
+}
+
+// CheckCharsets tells if the charsets of every backend are valid
+func (c TransConf) CheckCharsets() error {
+	for _, address := range parseTransBackends(c.Host, c.Port) {
+		if _, err := c.backendCharset(address); err != nil {
+			return fmt.Errorf("backend %s: %s", address, err)
+		}
+	}
+	return nil
+}
+
+// Schemas returns the schema of every command declaring its params
+func (c TransConf) Schemas() (map[string]domain.CommandSchema, error) {
+	schemas := make(map[string]domain.CommandSchema)
+	for name, command := range c.Commands {
+		if command.Params == nil {
+			continue
+		}
+		schema, err := domain.NewCommandSchema(command.Params)
+		if err != nil {
+			return nil, fmt.Errorf("command %s: %s", name, err)
+		}
+		schemas[name] = schema
+	}
+	return schemas, nil
+}
+
+// Aliases returns every alias of the commands by its name. An alias can't
+// be named as another command, which it would hide
+func (c TransConf) Aliases() (map[string]domain.CommandAlias, error) {
+	aliases := make(map[string]domain.CommandAlias)
+	for name, command := range c.Commands {
+		for _, alias := range command.Aliases {
+			if !aliasName.MatchString(alias.Name) {
+				return nil, fmt.Errorf("command %s: invalid alias name %q", name, alias.Name)
+			}
+			if other, ok := aliases[alias.Name]; ok {
+				return nil, fmt.Errorf("alias %s: declared for %s and %s", alias.Name, other.Command, name)
+			}
+			if _, ok := c.Commands[alias.Name]; ok && alias.Name != name {
+				return nil, fmt.Errorf("alias %s of %s: it's a command", alias.Name, name)
+			}
+			aliases[alias.Name] = domain.CommandAlias{
+				Name:       alias.Name,
+				Command:    name,
+				Params:     alias.Params,
+				Deprecated: alias.Deprecated,
+			}
+		}
+	}
+	return aliases, nil
+}
+
+// TransParamsConf holds the rules to write the json values of the params
+// as trans text
+type TransParamsConf struct {
+	// Bools how booleans are written: numbers (1/0) or words (true/false)
+	Bools string `env:"BOOLS" envDefault:"numbers"`
+	// Nulls what to do with null values: omit the param or reject the command
+	Nulls string `env:"NULLS" envDefault:"omit"`
+}
+
+// TransBreakerConf holds the configuration of the circuit breaker kept for
+// each trans backend
+type TransBreakerConf struct {
+	// ErrorRatio ratio of failed commands, between 0 and 1, that opens the
+	// breaker. Zero disables the breaker
+	ErrorRatio float64 `env:"ERROR_RATIO" envDefault:"0.5"`
+	// MinRequests commands needed in the window before the ratio is checked
+	MinRequests int `env:"MIN_REQUESTS" envDefault:"10"`
+	// Window time the commands are counted before starting over
+	Window time.Duration `env:"WINDOW" envDefault:"10s"`
+	// OpenTimeout time the breaker stays open before letting probes through
+	OpenTimeout time.Duration `env:"OPEN_TIMEOUT" envDefault:"5s"`
+	// HalfOpenProbes commands let through while half-open, all of them
+	// must succeed to close the breaker
+	HalfOpenProbes int `env:"HALF_OPEN_PROBES" envDefault:"3"`
+}
+
+// TransBusyConf holds how to react when trans is busy. Whether the
+// command is sent again is decided by its retry policy
+type TransBusyConf struct {
+	// Failover if the command should be tried on other backends before
+	// waiting to retry on the busy one
+	Failover bool `env:"FAILOVER" envDefault:"true"`
+	// ClientRetryAfter time clients are told to wait, with the Retry-After
+	// header, when trans is still busy after the retries
+	ClientRetryAfter time.Duration `env:"CLIENT_RETRY_AFTER" envDefault:"5s"`
+}
+
+// TransCommandConf holds the settings of a single command, read from the
+// commands file. Example:
+//
+//	{"transinfo": {"read_only": true, "retry": {"attempts": 3, "on": "connect,busy,timeout"}}}
+type TransCommandConf struct {
+	// ReadOnly tells the command doesn't change anything on trans
+	ReadOnly bool `json:"read_only"`
+	// Idempotent tells the command may be applied twice with the same result
+	Idempotent bool `json:"idempotent"`
+	// Retry overrides the default retry policy
+	Retry *TransRetryConf `json:"retry"`
+	// Group how the response keys are grouped when clients ask for
+	// nested objects
+	Group handlers.TransGrouping `json:"group"`
+	// DryRun tells the command may be run without committing it
+	DryRun bool `json:"dry_run"`
+	// Capture records every execution of the command, see TransCaptureConf
+	Capture bool `json:"capture"`
+	// Timeout overrides the time the whole command may take
+	Timeout Duration `json:"timeout"`
+	// Timeouts overrides the time some phases of the command may take,
+	// the ones left empty keep the default
+	Timeouts *TransTimeoutsConf `json:"timeouts"`
+	// Limits overrides the max size of the responses of the command, the
+	// limits left empty keep the default
+	Limits *TransLimitsConf `json:"limits"`
+	// Params the only params the command accepts, see domain.ParamSchema.
+	// Commands without params declared accept any of them, an empty list
+	// accepts none
+	Params []domain.ParamSchema `json:"params"`
+	// Aliases the public names the command may be asked with
+	Aliases []TransAliasConf `json:"aliases"`
+}
+
+// TransAliasConf holds a public name of a command, see domain.CommandAlias
+type TransAliasConf struct {
+	// Name the public name, optionally versioned like get_ad@v2
+	Name string `json:"name"`
+	// Params the params renamed, from their public name to the trans one
+	Params map[string]string `json:"params"`
+	// Deprecated logs a warning and tells the clients with the Deprecation
+	// header when the alias is used
+	Deprecated bool `json:"deprecated"`
+}
+
+// aliasName matches the names of the aliases, with an optional version
+var aliasName = regexp.MustCompile(`^[A-Za-z0-9_-]+(@[A-Za-z0-9._-]+)?$`) // nolint: gochecknoglobals
+
+// TransTimeoutsConf holds the time each phase of a command may take. Zero
+// values don't limit the phase, except for connect and greeting which
+// are limited by the timeout of the whole command
+type TransTimeoutsConf struct {
+	// Connect dialing the connection to trans
+	Connect Duration `env:"CONNECT" envDefault:"0s" json:"connect"`
+	// Greeting waiting for the greeting of trans on a new connection
+	Greeting Duration `env:"GREETING" envDefault:"0s" json:"greeting"`
+	// Write writing the command
+	Write Duration `env:"WRITE" envDefault:"0s" json:"write"`
+	// Read waiting for the whole response, once the command was written
+	Read Duration `env:"READ" envDefault:"0s" json:"read"`
+}
+
+// merge returns the timeouts with the phases set in override replaced
+func (t TransTimeoutsConf) merge(override TransTimeoutsConf) TransTimeoutsConf {
+	if override.Connect > 0 {
+		t.Connect = override.Connect
+	}
+	if override.Greeting > 0 {
+		t.Greeting = override.Greeting
+	}
+	if override.Write > 0 {
+		t.Write = override.Write
+	}
+	if override.Read > 0 {
+		t.Read = override.Read
+	}
+	return t
+}
+
+// TransLimitsConf holds the max size of the responses of a command. Zero
+// values don't limit the responses
+type TransLimitsConf struct {
+	// ResponseSize max bytes of the response, blobs included
+	ResponseSize int `env:"RESPONSE_SIZE" envDefault:"67108864" json:"response_size"`
+	// Lines max lines of the response, each blob counting as one
+	Lines int `env:"LINES" envDefault:"1000000" json:"lines"`
+	// BlobSize max bytes of each blob of the response
+	BlobSize int `env:"BLOB_SIZE" envDefault:"33554432" json:"blob_size"`
+}
+
+// merge returns the limits with the ones set in override replaced
+func (l TransLimitsConf) merge(override TransLimitsConf) TransLimitsConf {
+	if override.ResponseSize > 0 {
+		l.ResponseSize = override.ResponseSize
+	}
+	if override.Lines > 0 {
+		l.Lines = override.Lines
+	}
+	if override.BlobSize > 0 {
+		l.BlobSize = override.BlobSize
+	}
+	return l
+}
+
+// TransRetryConf holds when and how often a failed command is sent again
+type TransRetryConf struct {
+	// Attempts max number of times the command is sent, the first included
+	Attempts int `env:"ATTEMPTS" envDefault:"2" json:"attempts"`
+	// Backoff wait time before the first retry, doubled on each one
+	Backoff Duration `env:"BACKOFF" envDefault:"100ms" json:"backoff"`
+	// MaxBackoff max wait time between retries
+	MaxBackoff Duration `env:"MAX_BACKOFF" envDefault:"2s" json:"max_backoff"`
+	// Jitter ratio, between 0 and 1, the backoff is randomly moved up or down
+	Jitter float64 `env:"JITTER" envDefault:"0.2" json:"jitter"`
+	// On failure classes that are retried, separated by ',': connect, busy
+	// and timeout. Timeouts happen after the command was written, so they
+	// are only retried for read only or idempotent commands
+	On string `env:"ON" envDefault:"connect,busy" json:"on"`
+}
+
+// Duration is a time.Duration that is read from json or yaml as a string,
+// like "1.5s"
+type Duration time.Duration
+
+// UnmarshalJSON parses the duration from a json string
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var value string
+	if err := json.Unmarshal(b, &value); err != nil {
+		return err
+	}
+	duration, err := time.ParseDuration(value)
+	*d = Duration(duration)
+	return err
+}
+
+// UnmarshalYAML parses the duration from a yaml string
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return err
+	}
+	duration, err := time.ParseDuration(s)
+	*d = Duration(duration)
+	return err
+}
+
+// MarshalJSON writes the duration as a json string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// LoadCommands reads the settings of each command from CommandsFile, if any
+func (c *TransConf) LoadCommands() error {
+	if c.CommandsFile == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(c.CommandsFile)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, &c.Commands)
+}
+
+// Command returns the settings of the given command, filling the ones it
+// doesn't override with the defaults
+func (c TransConf) Command(name string) TransCommandConf {
+	command := c.Commands[name]
+	if command.Retry == nil {
+		command.Retry = &c.Retry
+	}
+	if command.Timeout <= 0 {
+		command.Timeout = Duration(time.Duration(c.Timeout) * time.Second)
+	}
+	timeouts := c.Timeouts
+	if command.Timeouts != nil {
+		timeouts = timeouts.merge(*command.Timeouts)
+	}
+	if timeouts.Connect <= 0 {
+		timeouts.Connect = command.Timeout
+	}
+	if timeouts.Greeting <= 0 {
+		timeouts.Greeting = command.Timeout
+	}
+	command.Timeouts = &timeouts
+	limits := c.Limits
+	if command.Limits != nil {
+		limits = limits.merge(*command.Limits)
+	}
+	command.Limits = &limits
+	return command
+}
+
+// TransHealthCheckConf holds the configuration of the checker that ejects
+// the dead trans backends
+type TransHealthCheckConf struct {
+	// Interval time between probes, zero disables the health checking
+	Interval time.Duration `env:"INTERVAL" envDefault:"10s"`
+	// Command sent to probe the backends, it must reply TRANS_OK
+	Command string `env:"COMMAND" envDefault:"transinfo"`
+	// Threshold consecutive failed probes needed to eject a backend
+	Threshold int `env:"THRESHOLD" envDefault:"2"`
+}
+
+// TransPoolConf holds the configuration of the pool of connections to the
+// trans server. Zero values disable the corresponding limit
+type TransPoolConf struct {
+	// MinIdle number of greeted connections the pool tries to keep ready
+	MinIdle int `env:"MIN_IDLE" envDefault:"0"`
+	// MaxOpen max number of connections open at the same time, in use or idle
+	MaxOpen int `env:"MAX_OPEN" envDefault:"20"`
+	// MaxIdleTime time an idle connection is kept before being evicted
+	MaxIdleTime time.Duration `env:"MAX_IDLE_TIME" envDefault:"30s"`
 }
 
 // Config holds all configuration for the service
@@ -105,6 +539,26 @@ type Config struct {
 	InBrowserCacheConf InBrowserCacheConf `env:"BROWSER_CACHE_"`
 }
 
+// TransMockConf holds the configuration of the trans mock
+type TransMockConf struct {
+	// Address where the trans commands are served, as host:port or
+	// unix:///path of a unix socket
+	Address string `env:"ADDRESS" envDefault:"0.0.0.0:20005"`
+	// ExpectationsFile path of a json file with the expectations set at
+	// start, written as the body of the /expectations endpoint
+	ExpectationsFile string `env:"EXPECTATIONS_FILE"`
+}
+
+// MockConfig holds the configuration of the trans-mock command, its
+// control API being served on Runtime
+type MockConfig struct {
+	Mock           TransMockConf  `env:"TRANS_MOCK_"`
+	PrometheusConf PrometheusConf `env:"PROMETHEUS_"`
+	LoggerConf     LoggerConf     `env:"LOGGER_"`
+	Runtime        RuntimeConfig  `env:"APP_"`
+	CorsConf       CorsConf       `env:"CORS_"`
+}
+
 // LoadFromEnv loads the config data from the environment variables
 func LoadFromEnv(data interface{}) {
 	load(reflect.ValueOf(data), "", "")
@@ -152,10 +606,21 @@ func load(conf reflect.Value, envTag, envDefault string) {
 			// Here for each type we should make a cast of the env variable and then set the value
 			case reflect.String:
 				reflectedConf.SetString(value)
+			case reflect.Int64:
+				if reflectedConf.Type() == reflect.TypeOf(time.Duration(0)) ||
+					reflectedConf.Type() == reflect.TypeOf(Duration(0)) {
+					if value, err := time.ParseDuration(value); err == nil {
+						reflectedConf.Set(reflect.ValueOf(value).Convert(reflectedConf.Type()))
+					}
+				}
 			case reflect.Int:
 				if value, err := strconv.Atoi(value); err == nil {
 					reflectedConf.Set(reflect.ValueOf(value))
 				}
+			case reflect.Float64:
+				if value, err := strconv.ParseFloat(value, 64); err == nil {
+					reflectedConf.Set(reflect.ValueOf(value))
+				}
 			case reflect.Bool:
 				if value, err := strconv.ParseBool(value); err == nil {
 					reflectedConf.Set(reflect.ValueOf(value))
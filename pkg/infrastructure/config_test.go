@@ -3,8 +3,11 @@ package infrastructure
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/domain"
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/interfaces/handlers"
 )
 
 type Nested struct {
@@ -12,12 +15,15 @@ type Nested struct {
 }
 
 type TestConf struct {
-	I  int    `env:"LE_I"`
-	S  string `env:"LE_S"`
-	F  string `env:"FROM"`
-	N  Nested `env:"NESTED_"`
-	D  string `env:"DEF" envDefault:"default_conf"`
-	OF string `env:"OTHERFILE"`
+	I  int           `env:"LE_I"`
+	S  string        `env:"LE_S"`
+	F  string        `env:"FROM"`
+	N  Nested        `env:"NESTED_"`
+	D  string        `env:"DEF" envDefault:"default_conf"`
+	OF string        `env:"OTHERFILE"`
+	T  time.Duration `env:"LE_T" envDefault:"1m"`
+	BT time.Duration `env:"BAD_T" envDefault:"1m"`
+	R  float64       `env:"LE_R"`
 }
 
 func TestConfigLoad(t *testing.T) {
@@ -27,6 +33,9 @@ func TestConfigLoad(t *testing.T) {
 		"NESTED_LE_F":    "true",
 		"FROM_FILE":      "testdata/from.data",
 		"OTHERFILE_FILE": "testdata/not.data",
+		"LE_T":           "250ms",
+		"BAD_T":          "forever",
+		"LE_R":           "0.25",
 	}
 	// Setup environment
 	for k, v := range env {
@@ -45,7 +54,141 @@ func TestConfigLoad(t *testing.T) {
 			F: true,
 		},
 		D: "default_conf",
+		T: 250 * time.Millisecond,
+		R: 0.25,
 	}
 
 	assert.Equal(t, expected, conf)
 }
+
+func TestTransConfCommands(t *testing.T) {
+	conf := TransConf{
+		CommandsFile: "testdata/commands.json",
+		Timeout:      15,
+		Timeouts: TransTimeoutsConf{
+			Connect: Duration(time.Second),
+			Write:   Duration(3 * time.Second),
+		},
+		Limits: TransLimitsConf{
+			ResponseSize: 1024,
+			Lines:        10,
+		},
+		Retry: TransRetryConf{
+			Attempts: 2,
+			On:       "connect",
+		},
+	}
+	assert.NoError(t, conf.LoadCommands())
+
+	expected := TransCommandConf{
+		ReadOnly: true,
+		Retry: &TransRetryConf{
+			Attempts:   3,
+			Backoff:    Duration(50 * time.Millisecond),
+			MaxBackoff: Duration(time.Second),
+			On:         "connect,busy,timeout",
+		},
+		Group: handlers.TransGrouping{
+			Separator: "_",
+			Prefixes:  []string{"ad"},
+		},
+		Timeout: Duration(5 * time.Second),
+		Timeouts: &TransTimeoutsConf{
+			Connect:  Duration(time.Second),
+			Greeting: Duration(500 * time.Millisecond),
+			Write:    Duration(time.Second),
+		},
+		Limits: &TransLimitsConf{
+			ResponseSize: 1024,
+			Lines:        100,
+		},
+		Params: []domain.ParamSchema{
+			{Name: "ad_id", Type: domain.ParamInt, Required: true},
+			{Name: "lang", Enum: []string{"es", "en"}},
+		},
+		Aliases: []TransAliasConf{
+			{Name: "ad@v2", Params: map[string]string{"id": "ad_id"}},
+			{Name: "ad@v1", Deprecated: true},
+		},
+	}
+	assert.Equal(t, expected, conf.Command("get_ad"))
+	// commands without settings get the default ones
+	defaults := TransCommandConf{
+		Retry:   &conf.Retry,
+		Timeout: Duration(15 * time.Second),
+		Timeouts: &TransTimeoutsConf{
+			Connect:  Duration(time.Second),
+			Greeting: Duration(15 * time.Second),
+			Write:    Duration(3 * time.Second),
+		},
+		Limits: &conf.Limits,
+	}
+	assert.Equal(t, defaults, conf.Command("newad"))
+	assert.Equal(t, defaults, conf.Command("transinfo"))
+}
+
+func TestTransConfSchemas(t *testing.T) {
+	conf := TransConf{CommandsFile: "testdata/commands.json"}
+	assert.NoError(t, conf.LoadCommands())
+	schemas, err := conf.Schemas()
+	assert.NoError(t, err)
+	// only the commands declaring their params have a schema
+	assert.Len(t, schemas, 1)
+	assert.Equal(t, domain.SchemaErrors{{Key: "ad_id", Reason: "required"}}, schemas["get_ad"].Validate(nil))
+
+	invalid := []struct {
+		params []domain.ParamSchema
+		err    string
+	}{
+		{[]domain.ParamSchema{{Type: "int"}}, "command newad: param without name"},
+		{[]domain.ParamSchema{{Name: "ad_id"}, {Name: "ad_id"}}, "command newad: param ad_id: declared twice"},
+		{[]domain.ParamSchema{{Name: "ad_id", Type: "number"}}, `command newad: param ad_id: unknown type "number"`},
+		{[]domain.ParamSchema{{Name: "ad_id", Pattern: "("}},
+			"command newad: param ad_id: error parsing regexp: missing closing ): `^(?:()$`"},
+	}
+	for _, c := range invalid {
+		conf.Commands = map[string]TransCommandConf{"newad": {Params: c.params}}
+		_, err := conf.Schemas()
+		assert.EqualError(t, err, c.err)
+	}
+}
+
+func TestTransConfAliases(t *testing.T) {
+	conf := TransConf{CommandsFile: "testdata/commands.json"}
+	assert.NoError(t, conf.LoadCommands())
+	aliases, err := conf.Aliases()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]domain.CommandAlias{
+		"ad@v2": {Name: "ad@v2", Command: "get_ad", Params: map[string]string{"id": "ad_id"}},
+		"ad@v1": {Name: "ad@v1", Command: "get_ad", Deprecated: true},
+	}, aliases)
+
+	invalid := []struct {
+		commands map[string]TransCommandConf
+		err      string
+	}{
+		{map[string]TransCommandConf{"get_ad": {Aliases: []TransAliasConf{{Name: "ad@"}}}},
+			`command get_ad: invalid alias name "ad@"`},
+		{map[string]TransCommandConf{"get_ad": {Aliases: []TransAliasConf{{Name: "ad\nend"}}}},
+			`command get_ad: invalid alias name "ad\nend"`},
+		{map[string]TransCommandConf{"get_ad": {Aliases: []TransAliasConf{{Name: "ad"}, {Name: "ad"}}}},
+			"alias ad: declared for get_ad and get_ad"},
+		{map[string]TransCommandConf{"get_ad": {Aliases: []TransAliasConf{{Name: "newad"}}}, "newad": {}},
+			"alias newad of get_ad: it's a command"},
+	}
+	for _, c := range invalid {
+		conf.Commands = c.commands
+		_, err := conf.Aliases()
+		assert.EqualError(t, err, c.err)
+	}
+	// a command can be deprecated by an alias with its own name
+	conf.Commands = map[string]TransCommandConf{"get_ad": {Aliases: []TransAliasConf{{Name: "get_ad", Deprecated: true}}}}
+	aliases, err = conf.Aliases()
+	assert.NoError(t, err)
+	assert.Equal(t, "get_ad", aliases["get_ad"].Command)
+}
+
+func TestTransConfCommandsMissingFile(t *testing.T) {
+	conf := TransConf{CommandsFile: "testdata/not.data"}
+	assert.Error(t, conf.LoadCommands())
+}
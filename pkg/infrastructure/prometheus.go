@@ -125,6 +125,154 @@ func (*Prometheus) NewEventsCollector(name, help string) EventCollector {
 	return EventCollector{counterVec}
 }
 
+// TrackTransBackends exports the state of the trans backends and the
+// usage of their connection pools, taking a snapshot with stats on every scrape
+func (*Prometheus) TrackTransBackends(stats func() []TransBackendStats) {
+	prometheus.MustRegister(&transBackendsCollector{stats: stats})
+}
+
+// transBackendsCollector is a prometheus.Collector that reports the stats
+// of each trans backend, labeled by its address
+type transBackendsCollector struct {
+	stats func() []TransBackendStats
+}
+
+// transBackendsMetrics describes every metric reported by transBackendsCollector
+var transBackendsMetrics = []struct { // nolint: gochecknoglobals
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+	value     func(TransBackendStats) float64
+}{
+	{
+		desc: prometheus.NewDesc("trans_backend_up",
+			"Whether the trans backend is receiving commands.", []string{"backend"}, nil),
+		valueType: prometheus.GaugeValue,
+		value: func(s TransBackendStats) float64 {
+			if s.Healthy {
+				return 1
+			}
+			return 0
+		},
+	},
+	{
+		desc: prometheus.NewDesc("trans_backend_in_flight_commands",
+			"A gauge of the commands being executed on the trans backend.", []string{"backend"}, nil),
+		valueType: prometheus.GaugeValue,
+		value:     func(s TransBackendStats) float64 { return float64(s.InFlight) },
+	},
+	{
+		desc: prometheus.NewDesc("trans_pool_open_connections",
+			"A gauge of the open connections of the trans pool.", []string{"backend"}, nil),
+		valueType: prometheus.GaugeValue,
+		value:     func(s TransBackendStats) float64 { return float64(s.Pool.Open) },
+	},
+	{
+		desc: prometheus.NewDesc("trans_pool_idle_connections",
+			"A gauge of the idle connections of the trans pool.", []string{"backend"}, nil),
+		valueType: prometheus.GaugeValue,
+		value:     func(s TransBackendStats) float64 { return float64(s.Pool.Idle) },
+	},
+	{
+		desc: prometheus.NewDesc("trans_pool_dials_total",
+			"A counter of the connections dialed by the trans pool.", []string{"backend"}, nil),
+		valueType: prometheus.CounterValue,
+		value:     func(s TransBackendStats) float64 { return float64(s.Pool.Dials) },
+	},
+	{
+		desc: prometheus.NewDesc("trans_pool_dial_errors_total",
+			"A counter of the failed dials of the trans pool.", []string{"backend"}, nil),
+		valueType: prometheus.CounterValue,
+		value:     func(s TransBackendStats) float64 { return float64(s.Pool.DialErrors) },
+	},
+	{
+		desc: prometheus.NewDesc("trans_pool_waits_total",
+			"A counter of the commands that waited for a connection of the trans pool.", []string{"backend"}, nil),
+		valueType: prometheus.CounterValue,
+		value:     func(s TransBackendStats) float64 { return float64(s.Pool.Waits) },
+	},
+	{
+		desc: prometheus.NewDesc("trans_pool_evictions_total",
+			"A counter of the idle connections evicted by the trans pool.", []string{"backend"}, nil),
+		valueType: prometheus.CounterValue,
+		value:     func(s TransBackendStats) float64 { return float64(s.Pool.Evictions) },
+	},
+}
+
+// transBreakerStateDesc describes the metric of the circuit breaker state
+var transBreakerStateDesc = prometheus.NewDesc( // nolint: gochecknoglobals
+	"trans_backend_breaker_state",
+	"Whether the circuit breaker of the trans backend is in the given state.",
+	[]string{"backend", "state"}, nil,
+)
+
+// transBreakerTransitionsDesc describes the metric of the circuit breaker transitions
+var transBreakerTransitionsDesc = prometheus.NewDesc( // nolint: gochecknoglobals
+	"trans_backend_breaker_transitions_total",
+	"A counter of the state changes of the circuit breaker of the trans backend.",
+	[]string{"backend", "from", "to"}, nil,
+)
+
+// transTimeoutsDesc describes the metric of the commands that timed out
+var transTimeoutsDesc = prometheus.NewDesc( // nolint: gochecknoglobals
+	"trans_backend_timeouts_total",
+	"A counter of the commands sent to the trans backend that timed out, by phase.",
+	[]string{"backend", "phase"}, nil,
+)
+
+// transLimitsDesc describes the metric of the responses that exceeded a limit
+var transLimitsDesc = prometheus.NewDesc( // nolint: gochecknoglobals
+	"trans_backend_response_limits_total",
+	"A counter of the responses of the trans backend that exceeded a limit, by limit.",
+	[]string{"backend", "limit"}, nil,
+)
+
+// Describe sends the descriptors of every metric of the trans backends
+func (c *transBackendsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, metric := range transBackendsMetrics {
+		ch <- metric.desc
+	}
+	ch <- transBreakerStateDesc
+	ch <- transBreakerTransitionsDesc
+	ch <- transTimeoutsDesc
+	ch <- transLimitsDesc
+}
+
+// Collect sends the current value of every metric of the trans backends
+func (c *transBackendsCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, backend := range c.stats() {
+		for _, metric := range transBackendsMetrics {
+			ch <- prometheus.MustNewConstMetric(
+				metric.desc, metric.valueType, metric.value(backend), backend.Address,
+			)
+		}
+		for _, state := range []string{BreakerClosed, BreakerOpen, BreakerHalfOpen} {
+			value := 0.0
+			if backend.Breaker.State == state {
+				value = 1
+			}
+			ch <- prometheus.MustNewConstMetric(
+				transBreakerStateDesc, prometheus.GaugeValue, value, backend.Address, state,
+			)
+		}
+		for transition, count := range backend.Breaker.Transitions {
+			ch <- prometheus.MustNewConstMetric(
+				transBreakerTransitionsDesc, prometheus.CounterValue, float64(count),
+				backend.Address, transition[0], transition[1],
+			)
+		}
+		for phase, count := range backend.Timeouts {
+			ch <- prometheus.MustNewConstMetric(
+				transTimeoutsDesc, prometheus.CounterValue, float64(count), backend.Address, phase,
+			)
+		}
+		for limit, count := range backend.Limits {
+			ch <- prometheus.MustNewConstMetric(
+				transLimitsDesc, prometheus.CounterValue, float64(count), backend.Address, limit,
+			)
+		}
+	}
+}
+
 var notSnakeChars = regexp.MustCompile("[^a-zA-Z0-9_]+") //nolint: gochecknoglobals
 var endStartUnderscore = regexp.MustCompile("^_|_$")     //nolint: gochecknoglobals
 
@@ -4,27 +4,131 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
-	"golang.org/x/text/encoding/charmap"
-
-	"github.com/eapache/go-resiliency/retrier"
 	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/domain"
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/interfaces/handlers"
 	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/interfaces/loggers"
 	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/interfaces/repository/services"
 )
 
+// errStaleConn is returned when a pooled connection turns out to be closed
+// by the server before the command could be sent through it
+var errStaleConn = errors.New("trans-proxy: stale pooled connection")
+
+// errConnClosed is returned when a pooled connection is closed by the
+// server after the command was written to it, without a response. Trans
+// may have run the command, so it's only sent again if it's safe
+var errConnClosed = errors.New("trans-proxy: connection closed without a response")
+
+// poolWaitError wraps the error of the context of a command that gave up
+// waiting for a slot of the pool, or for a new connection of it, before
+// sending anything to trans. The caller gets the error of the context
+type poolWaitError struct {
+	error
+}
+
+// unavailableError is returned when trans can't be reached. It is
+// domain.ErrTransUnavailable for the callers
+type unavailableError string
+
+// Error returns the description of the error
+func (e unavailableError) Error() string {
+	return string(e)
+}
+
+// Is tells if the error is target
+func (e unavailableError) Is(target error) bool {
+	return target == domain.ErrTransUnavailable
+}
+
+// errConnect is returned when no connection could be made to trans
+var errConnect error = unavailableError("Error connecting with trans-proxy server")
+
+// transOK status of the commands that succeed
+const transOK = "TRANS_OK"
+
+// transCommand is a command being sent to trans, along with its settings
+type transCommand struct {
+	name   string
+	params []domain.TransParams
+	dryRun bool
+	// capture records the exchanges with trans
+	capture bool
+	conf    TransCommandConf
+	// client the context of the caller, to tell its cancellations apart
+	// from the failures of the backends
+	client context.Context
+}
+
+// canceled tells if the caller went away, or its deadline passed, so the
+// command failed with no fault of the backend
+func (c *transCommand) canceled() bool {
+	return c.client != nil && c.client.Err() != nil
+}
+
+// retries tells if the failures of the given class can be retried. Once
+// the command was written, only read only or idempotent commands can be
+// sent again, or it may be applied twice
+func (c *transCommand) retries(class string) bool {
+	if class == "" || !c.conf.Retry.retries(class) {
+		return false
+	}
+	return class != FailureTimeout || c.conf.ReadOnly || c.conf.Idempotent
+}
+
+// transRequest is a command written in the charset of a backend, ready to
+// be sent to it
+type transRequest struct {
+	command *transCommand
+	charset transCharset
+	payload []byte
+	// warnings about the params that were changed to be written
+	warnings []string
+}
+
+// newTransRequest writes the command in the given charset
+func newTransRequest(command *transCommand, charset transCharset) (*transRequest, error) {
+	payload, warnings, err := appendCmd(nil, command.name, command.params, !command.dryRun, charset)
+	if err != nil {
+		return nil, err
+	}
+	return &transRequest{
+		command:  command,
+		charset:  charset,
+		payload:  payload,
+		warnings: warnings,
+	}, nil
+}
+
 // trans struct definition
 type trans struct {
 	conf            TransConf
 	logger          loggers.Logger
 	allowedCommands []string
+	balancer        *transBalancer
+	capturer        *transCapturer
+	recorder        *transRecorder
+}
+
+// TransFactory is a services.TransFactory that keeps pools of connections
+// to the trans backends, which must be released with Close on shutdown
+type TransFactory interface {
+	services.TransFactory
+	io.Closer
+	// Stats returns a snapshot of the state of every backend
+	Stats() []TransBackendStats
+	// Captures returns the captured commands kept in memory
+	handlers.TransCaptureReader
 }
 
 // textProtocolTransFactory is a auxiliar struct to create trans-proxy on demand
@@ -32,32 +136,239 @@ type textProtocolTransFactory struct {
 	conf            TransConf
 	logger          loggers.Logger
 	allowedCommands []string
+	balancer        *transBalancer
+	healthChecker   *transHealthChecker
+	capturer        *transCapturer
+	recorder        *transRecorder
+	// tls encrypts the connections to trans, nil if they are not
+	tls *tls.Config
+	// tlsErr why the TLS configuration couldn't be loaded, every connection
+	// fails with it so they are never sent in plain text
+	tlsErr error
 }
 
-// NewTextProtocolTransFactory initialize a services.TransFactory
+// NewTextProtocolTransFactory initialize a TransFactory with a pool of
+// connections for each one of the configured backends
 func NewTextProtocolTransFactory(
 	conf TransConf,
 	logger loggers.Logger,
-) services.TransFactory {
-	return &textProtocolTransFactory{
+) TransFactory {
+	factory := &textProtocolTransFactory{
 		conf:            conf,
 		logger:          logger,
 		allowedCommands: strings.Split(conf.AllowedCommands, "|"),
+		balancer: &transBalancer{
+			strategy: conf.Balancer,
+		},
+		capturer: newTransCapturer(conf.Capture, logger),
 	}
+	factory.tls, factory.tlsErr = conf.TLS.Config()
+	if factory.tlsErr != nil {
+		logger.Error("Trans TLS: %s", factory.tlsErr)
+	}
+	if conf.RecordFile != "" {
+		recorder, err := newTransRecorder(conf.RecordFile, conf.Capture.redactKeys(), logger)
+		if err != nil {
+			logger.Error("Trans recording %s: %s, not recording", conf.RecordFile, err)
+		}
+		factory.recorder = recorder
+	}
+	for _, address := range parseTransBackends(conf.Host, conf.Port) {
+		address := address
+		charset, err := conf.backendCharset(address)
+		if err != nil {
+			logger.Error("Trans backend %s: %s, using %s", address, err, CharsetISO88591)
+			charset, _ = newTransCharset(CharsetISO88591, conf.CharsetMode)
+		}
+		factory.balancer.backends = append(factory.balancer.backends, &transBackend{
+			address: address,
+			charset: charset,
+			pool: newTransPool(conf.Pool, func(ctx context.Context, timeouts *TransTimeoutsConf) (*transConn, error) {
+				return factory.connect(ctx, address, timeouts)
+			}),
+			breaker: newCircuitBreaker(conf.Breaker, func(from, to string) {
+				logger.Warn("Trans backend %s circuit breaker %s -> %s", address, from, to)
+			}),
+		})
+	}
+	if conf.HealthCheck.Interval > 0 {
+		factory.healthChecker = &transHealthChecker{
+			conf:     conf.HealthCheck,
+			backends: factory.balancer.backends,
+			logger:   logger,
+			probe:    factory.probe,
+			done:     make(chan struct{}),
+		}
+		factory.healthChecker.Start()
+	}
+	return factory
 }
 
-// MakeTransHandler initialize a services.TransHandler on demand
+// MakeTransHandler initialize a services.TransHandler on demand, that
+// sends its commands through the pooled connections
 func (t *textProtocolTransFactory) MakeTransHandler() services.TransHandler {
 	return &trans{
 		conf:            t.conf,
 		logger:          t.logger,
 		allowedCommands: t.allowedCommands,
+		balancer:        t.balancer,
+		capturer:        t.capturer,
+		recorder:        t.recorder,
+	}
+}
+
+// Stats returns a snapshot of the state of every backend
+func (t *textProtocolTransFactory) Stats() []TransBackendStats {
+	stats := make([]TransBackendStats, 0, len(t.balancer.backends))
+	for _, backend := range t.balancer.backends {
+		stats = append(stats, backend.Stats())
+	}
+	return stats
+}
+
+// Captures returns the captured commands kept in memory, the oldest first.
+// It's empty if the captures go to the log
+func (t *textProtocolTransFactory) Captures() []handlers.TransCapture {
+	return t.capturer.Captures()
+}
+
+// Close stops the health checking and closes the pooled connections
+func (t *textProtocolTransFactory) Close() error {
+	if t.healthChecker != nil {
+		_ = t.healthChecker.Close() // nolint: gosec
+	}
+	for _, backend := range t.balancer.backends {
+		_ = backend.pool.Close() // nolint: gosec
+	}
+	if t.recorder != nil {
+		return t.recorder.Close()
+	}
+	return nil
+}
+
+// connect returns a connection to the trans-proxy client, after checking
+// the server greeting. Failures are retried as told by the retry policy
+// of the command. The dial and the greeting are limited by the given
+// timeouts, or the default ones if nil, failing with a domain.TimeoutError.
+// Every phase gives up at the deadline of ctx if it comes first, failing
+// with the error of ctx. If TLS is enabled, the handshake is part of the dial
+func (t *textProtocolTransFactory) connect(
+	ctx context.Context, address string, timeouts *TransTimeoutsConf,
+) (*transConn, error) {
+	if t.tlsErr != nil {
+		return nil, t.tlsErr
+	}
+	if timeouts == nil {
+		timeouts = t.conf.Command("").Timeouts
+	}
+	connectTimeout := time.Duration(timeouts.Connect)
+	connectDeadline := ctxDeadline(ctx, connectTimeout)
+	network, dialTo := dialAddress(address)
+	dialer := net.Dialer{Timeout: connectTimeout}
+	conn, err := dialer.DialContext(ctx, network, dialTo)
+	if err != nil {
+		if ctxErr := contextErr(ctx); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, phaseTimeout(err, PhaseConnect, connectTimeout)
+	}
+	if t.tls != nil {
+		if conn, err = t.handshake(ctx, conn, address, connectDeadline); err != nil {
+			if ctxErr := contextErr(ctx); ctxErr != nil {
+				return nil, ctxErr
+			}
+			return nil, phaseTimeout(err, PhaseConnect, connectTimeout)
+		}
+	}
+	// Check greeting.
+	greetingTimeout := time.Duration(timeouts.Greeting)
+	reader := bufio.NewReader(conn)
+	_ = conn.SetReadDeadline(ctxDeadline(ctx, greetingTimeout)) // nolint: gosec
+	line, err := reader.ReadSlice('\n')
+	if ctxErr := contextErr(ctx); err != nil && ctxErr != nil {
+		err = ctxErr
+	}
+	err = phaseTimeout(err, PhaseGreeting, greetingTimeout)
+	if err == nil && bytes.Equal(line, []byte(BusyMessage)) {
+		err = domain.ErrTransBusy
+	} else if err == nil && !bytes.Equal(line, []byte(WelcomeMessage)) {
+		err = fmt.Errorf("trans-proxy: unexpected greeting: %q", line)
+	}
+	if err != nil {
+		_ = conn.Close() // nolint: gosec
+		return nil, err
+	}
+	_ = conn.SetReadDeadline(time.Time{}) // nolint: gosec
+	return &transConn{Conn: conn, reader: reader}, nil
+}
+
+// handshake encrypts the connection, verifying the certificate of trans
+// against the host of the address, or localhost for unix sockets, unless
+// a server name is configured. The connection is closed if it fails
+func (t *textProtocolTransFactory) handshake(
+	ctx context.Context, conn net.Conn, address string, until time.Time,
+) (net.Conn, error) {
+	config := t.tls
+	if config.ServerName == "" {
+		config = config.Clone()
+		config.ServerName = "localhost"
+		if network, _ := dialAddress(address); network == "tcp" {
+			config.ServerName, _, _ = net.SplitHostPort(address)
+		}
+	}
+	tlsConn := tls.Client(conn, config)
+	_ = tlsConn.SetDeadline(until) // nolint: gosec
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		_ = conn.Close() // nolint: gosec
+		return nil, err
+	}
+	_ = tlsConn.SetDeadline(time.Time{}) // nolint: gosec
+	return tlsConn, nil
+}
+
+// probe sends the health check command to the backend through a new
+// connection, so the dial is checked too
+func (t *textProtocolTransFactory) probe(ctx context.Context, backend *transBackend) error {
+	command := &transCommand{
+		name: t.conf.HealthCheck.Command,
+		conf: t.conf.Command(t.conf.HealthCheck.Command),
+	}
+	conn, err := t.connect(ctx, backend.address, command.conf.Timeouts)
+	if err != nil {
+		return err
+	}
+	defer conn.Close() // nolint: errcheck
+	handler := t.MakeTransHandler().(*trans)
+	// the health checks are not recorded as traffic
+	handler.recorder = nil
+	request, err := newTransRequest(command, backend.charset)
+	if err != nil {
+		return err
+	}
+	reply, err := handler.sendWithContext(ctx, conn, request)
+	if err != nil {
+		return err
 	}
+	if status, _ := reply.Fields.Get("status"); status != transOK {
+		return fmt.Errorf("trans-proxy: health check status %q", status)
+	}
+	return nil
 }
 
-// SendCommand use a socket connection to send commands to trans-proxy port
-func (handler *trans) SendCommand(cmd string, transParams []domain.TransParams) (map[string]string, error) {
-	respMap := make(map[string]string)
+// SendCommand use a pooled socket connection to send commands to trans-proxy port.
+// The response fields are returned in the order trans sent them.
+// If a backend can't be connected, the command fails over to the next one.
+// If trans is busy, the command is sent again after a backoff. Params
+// that can't be written in the charset of the backend fail the command
+// with domain.EncodingErrors, unless the charset mode is lenient. Dry
+// runs are sent with commit:0, so trans doesn't keep their changes. The
+// command gives up, closing its connection, when ctx is done or its
+// Timeout passes, whichever comes first. Each phase of the command is
+// limited by its own timeout too, see TransTimeoutsConf. The commands
+// configured or asked to be captured have their exchanges recorded, see
+// TransCaptureConf
+func (handler *trans) SendCommand(ctx context.Context, transCmd domain.TransCommand) (services.TransReply, error) {
+	cmd := transCmd.Command
 	// check if the command is allowed; if not, return error
 	valid := handler.isAllowedCommand(cmd)
 	if !valid {
@@ -65,30 +376,41 @@ func (handler *trans) SendCommand(cmd string, transParams []domain.TransParams)
 			"invalid command - commands allowed: %s",
 			handler.allowedCommands,
 		)
-		respMap["error"] = err.Error()
 		handler.logger.Error(err.Error())
-		return respMap, err
-	}
-	conn, err := handler.connect()
-	if err != nil {
-		handler.logger.Error("Error connecting to trans-proxy: %s\n", err.Error())
-		return respMap, fmt.Errorf("Error connecting with trans-proxy server")
+		return services.TransReply{Fields: domain.TransFields{{Key: "error", Value: err.Error()}}}, err
 	}
-	defer conn.Close() //nolint: errcheck, megacheck
 
-	// initiate the context so the request can timeout
-	ctx, cancel := context.WithTimeout(
-		context.Background(),
-		time.Duration(handler.conf.Timeout)*time.Second,
-	)
+	command := &transCommand{
+		name:   cmd,
+		params: transCmd.Params,
+		dryRun: transCmd.DryRun,
+		conf:   handler.conf.Command(cmd),
+		client: ctx,
+	}
+	command.capture = transCmd.Capture || command.conf.Capture
+	// the command times out at the deadline of the caller or the server
+	// timeout, whichever comes first
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(command.conf.Timeout))
 	defer cancel()
 
-	respMap, err = handler.sendWithContext(ctx, conn, cmd, transParams)
-	if err != nil {
-		handler.logger.Error("Error Sending command %s: %s\n", cmd, err)
+	retry := command.conf.Retry
+	candidates := handler.balancer.candidates()
+	for attempt := 1; ; attempt++ {
+		reply, err := handler.sendToAny(ctx, candidates, command)
+		if class := failureClass(err); attempt < retry.Attempts && command.retries(class) {
+			backoff := retry.backoff(attempt)
+			handler.logger.Warn("Error %s executing %s, retrying in %s\n", class, cmd, backoff)
+			select {
+			case <-time.After(backoff):
+				continue
+			case <-ctx.Done():
+			}
+		}
+		if err != nil {
+			handler.logger.Error("Error Sending command %s: %s\n", cmd, err)
+		}
+		return reply, err
 	}
-
-	return respMap, err
 }
 
 // isAllowedCommand checks if the given command can be sent to trans-proxy
@@ -101,31 +423,128 @@ func (handler *trans) isAllowedCommand(cmd string) bool {
 	return false
 }
 
-// connect returns a connection to the trans-proxy client.
-// Retries to connect after retryAfter time if the connection times out
-func (handler *trans) connect() (net.Conn, error) {
-	// initiate the retrier that will handle retry reconnect if the connection dies
-	r := retrier.New(
-		[]time.Duration{
-			time.Duration(handler.conf.RetryAfter) * time.Second},
-		nil,
-	)
-	var conn net.Conn
-	// set the function that starts the connection
-	err := r.Run(func() error {
-		var e error
-		conn, e = net.DialTimeout(
-			"tcp",
-			fmt.Sprintf(
-				"%s:%d",
-				handler.conf.Host,
-				handler.conf.Port,
-			),
-			time.Duration(handler.conf.Timeout)*time.Second,
-		)
-		return e
-	})
-	return conn, err
+// sendToAny sends the command to the first candidate that can be connected
+// and whose breaker is not open. Busy backends are skipped too if the busy
+// failover is enabled
+func (handler *trans) sendToAny(
+	ctx context.Context,
+	candidates []*transBackend,
+	command *transCommand,
+) (services.TransReply, error) {
+	lastErr := errConnect
+	for _, backend := range candidates {
+		reply, err := handler.sendTo(ctx, backend, command)
+		if err == domain.ErrTransBusy && handler.conf.Busy.Failover {
+			lastErr = err
+			continue
+		}
+		if failureClass(err) == FailureConnect && ctx.Err() == nil {
+			if lastErr != domain.ErrTransBusy {
+				lastErr = err
+			}
+			continue
+		}
+		return reply, err
+	}
+	return services.TransReply{}, lastErr
+}
+
+// sendTo sends the command to the given backend, unless its breaker is
+// open. Failing to connect or to get a response count as breaker failures,
+// not being able to write the command in the backend charset, nor the
+// caller going away, don't
+func (handler *trans) sendTo(
+	ctx context.Context,
+	backend *transBackend,
+	command *transCommand,
+) (services.TransReply, error) {
+	request, err := newTransRequest(command, backend.charset)
+	if err != nil {
+		return services.TransReply{}, err
+	}
+	done, release, err := backend.breaker.Allow()
+	if err != nil {
+		return services.TransReply{}, err
+	}
+	reply, err := handler.sendToBackend(ctx, backend, request)
+	// the commands that gave up before reaching trans, or that the client
+	// gave up on, tell nothing about the backend
+	if wait, ok := err.(poolWaitError); ok {
+		release()
+		return reply, wait.error
+	}
+	if command.canceled() {
+		release()
+	} else {
+		done(!breakerFailure(err))
+	}
+	return reply, err
+}
+
+// breakerFailure tells if the error counts as a failure of the backend on
+// its breaker: it couldn't be connected, took too long or the connection
+// failed. Errors of the command itself, like a response over its limits or
+// that can't be parsed, and a full pool don't count
+func breakerFailure(err error) bool {
+	var timeout domain.TimeoutError
+	if errors.As(err, &timeout) {
+		return true
+	}
+	switch err {
+	case errConnect, errConnClosed, errStaleConn:
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// sendToBackend sends the command through a connection of the backend pool.
+// The phases that time out are counted on the backend
+func (handler *trans) sendToBackend(
+	ctx context.Context,
+	backend *transBackend,
+	request *transRequest,
+) (services.TransReply, error) {
+	atomic.AddInt64(&backend.inFlight, 1)
+	defer atomic.AddInt64(&backend.inFlight, -1)
+	for {
+		conn, err := backend.pool.Get(ctx, request.command.conf.Timeouts)
+		if err == domain.ErrTransBusy {
+			return services.TransReply{}, err
+		}
+		// the command gave up waiting for a slot of the pool or while the
+		// connection was being made
+		if err != nil && ctx.Err() != nil {
+			return services.TransReply{}, poolWaitError{ctx.Err()}
+		}
+		var timeout domain.TimeoutError
+		if errors.As(err, &timeout) {
+			handler.logger.Error("Error connecting to trans-proxy %s: %s\n", backend.address, err.Error())
+			backend.countTimeout(timeout.Phase)
+			return services.TransReply{}, err
+		}
+		if err != nil {
+			handler.logger.Error("Error connecting to trans-proxy %s: %s\n", backend.address, err.Error())
+			return services.TransReply{}, errConnect
+		}
+		reply, err := handler.sendWithContext(ctx, conn, request)
+		// trans answers a single command per connection and then closes
+		// it, so the connection is never given back to the pool
+		backend.pool.Put(conn, false)
+		if errors.As(err, &timeout) {
+			backend.countTimeout(timeout.Phase)
+		}
+		var limit domain.ResponseLimitError
+		if errors.As(err, &limit) {
+			backend.countLimit(limit.Limit)
+		}
+		// the server closed the pooled connection before the command was
+		// sent, it's safe to send it again on another one
+		if err == errStaleConn {
+			continue
+		}
+		return reply, err
+	}
 }
 
 // sendWithContext sends the message to trans-proxy but is cancelable via a context.
@@ -133,11 +552,14 @@ func (handler *trans) connect() (net.Conn, error) {
 // for the trans-proxy to respond
 func (handler *trans) sendWithContext(
 	ctx context.Context,
-	conn io.ReadWriteCloser,
-	cmd string,
-	args []domain.TransParams,
-) (map[string]string, error) {
-	var resp map[string]string
+	conn *transConn,
+	request *transRequest,
+) (services.TransReply, error) {
+	// never start writing a command nobody waits for
+	if err := ctx.Err(); err != nil {
+		return services.TransReply{}, err
+	}
+	var reply services.TransReply
 	errChan := make(chan error, 1)
 
 	// starts the go routine that sends the message and retrieves the response and error, if any.
@@ -145,7 +567,7 @@ func (handler *trans) sendWithContext(
 	go func() {
 		errChan <- func() error {
 			var err error
-			resp, err = handler.send(conn, cmd, args)
+			reply, err = handler.send(conn, request)
 			return err
 		}()
 	}()
@@ -161,86 +583,158 @@ func (handler *trans) sendWithContext(
 		// wait for the goroutine to return and ignore the error
 		<-errChan
 		// return the context error: the operation timed out.
-		return nil, ctx.Err()
+		return services.TransReply{}, ctx.Err()
 	case err := <-errChan:
 		// in this case the send function returned before
 		// the timeout of the context.
-		return resp, err
+		return reply, err
 	}
 }
 
-func (handler *trans) send(conn io.ReadWriter, cmd string, args []domain.TransParams) (map[string]string, error) {
-	// Check greeting.
-	reader := bufio.NewReader(conn)
-	line, err := reader.ReadSlice('\n')
-	if err != nil {
-		return nil, err
+// send writes the request on an already greeted connection and reads the
+// response. The exchange is captured if the command is, unless the connection was
+// stale and the command is sent again on another one. Every exchange that
+// succeeds is recorded if recording is enabled
+func (handler *trans) send(conn *transConn, request *transRequest) (services.TransReply, error) {
+	start := time.Now()
+	reply, response, err := handler.exchange(conn, request)
+	if request.command.capture && handler.capturer != nil && err != errStaleConn {
+		handler.capturer.record(request.command, conn.RemoteAddr().String(), start, request.payload, response, err)
 	}
-	if !bytes.Equal(line, []byte("220 Welcome.\n")) {
-		return nil, fmt.Errorf("trans-proxy: unexpected greeting: %q", line)
+	if handler.recorder != nil && err == nil {
+		handler.recorder.record(request.payload, response)
 	}
+	return reply, err
+}
 
-	buf := make([]byte, 0)
-	// Send command to Trans.
-	buf = appendCmd(buf, cmd, args)
-	if _, err = conn.Write(buf); err != nil {
-		return nil, err
+// exchange writes the request and reads the response, which is returned
+// as it was read too. Writing and reading are limited by the timeouts of
+// the command. A connection that waited idle in the pool may have been
+// closed by trans meanwhile
+func (handler *trans) exchange(conn *transConn, request *transRequest) (services.TransReply, TransResponse, error) {
+	pooled := !conn.idleSince.IsZero()
+	timeouts := request.command.conf.Timeouts
+	writeTimeout := time.Duration(timeouts.Write)
+	_ = conn.SetWriteDeadline(deadline(writeTimeout)) // nolint: gosec
+	if _, err := conn.Write(request.payload); err != nil {
+		err = phaseTimeout(err, PhaseWrite, writeTimeout)
+		if _, timedOut := err.(domain.TimeoutError); pooled && !timedOut {
+			return services.TransReply{}, nil, errStaleConn
+		}
+		return services.TransReply{}, nil, err
 	}
+	_ = conn.SetWriteDeadline(time.Time{}) // nolint: gosec
 
-	var buffer bytes.Buffer
-	_, err = buffer.ReadFrom(reader)
+	readTimeout := time.Duration(timeouts.Read)
+	_ = conn.SetReadDeadline(deadline(readTimeout)) // nolint: gosec
+	defer conn.SetReadDeadline(time.Time{})         // nolint: errcheck
+	response, complete, err := readTransResponse(conn.reader, *request.command.conf.Limits)
 	if err != nil {
-		return nil, err
+		return services.TransReply{}, nil, phaseTimeout(err, PhaseRead, readTimeout)
 	}
-
-	buf, encodingErr := charmap.ISO8859_1.NewDecoder().Bytes(bytes.TrimSuffix(buffer.Bytes(), []byte("end\n")))
-	if encodingErr != nil {
-		handler.logger.Debug("Latin 1 expected, encoding error: %s\n", encodingErr.Error())
+	if pooled && !complete && len(response) == 0 {
+		return services.TransReply{}, response, errConnClosed
 	}
-	respMap, err := TransResponse(buf).Map()
+
+	fields, err := TransResponse(response).Fields()
 	if err != nil {
-		return respMap, fmt.Errorf("error parsing response: %s", err.Error())
+		return services.TransReply{Fields: fields}, response, fmt.Errorf("error parsing response: %s", err.Error())
+	}
+	reply := services.TransReply{
+		Fields:   fields,
+		Warnings: request.warnings,
 	}
-	return respMap, nil
+	if replaced := handler.decode(fields, request.charset); replaced > 0 {
+		handler.logger.Warn("Response of %s has %d bytes invalid in %s\n", request.command.name, replaced, request.charset.name)
+		reply.Warnings = append(reply.Warnings, fmt.Sprintf(
+			"response: %d characters invalid in %s were replaced", replaced, request.charset.name,
+		))
+	}
+	return reply, response, nil
+}
+
+// decode converts the keys and values of the response from the charset to
+// UTF-8, returning the number of invalid characters that were replaced.
+// Blobs are left as the raw bytes trans sent
+func (handler *trans) decode(fields domain.TransFields, charset transCharset) int {
+	replaced := 0
+	decodeString := func(s string) string {
+		decoded, n := charset.decode([]byte(s))
+		replaced += n
+		return decoded
+	}
+	for i := range fields {
+		fields[i].Key = decodeString(fields[i].Key)
+		if !fields[i].IsBlob() {
+			fields[i].Value = decodeString(fields[i].Value)
+		}
+	}
+	return replaced
 }
 
-// appendCmd Appends the command to the buffer. For the command format, see:
+// appendCmd Appends the command to the buffer, written in the given
+// charset. Trans keeps its changes only if commit is true. For the command format, see:
 // https://scmcoord.com/wiki/Trans#Protocol
-func appendCmd(buf []byte, cmd string, args []domain.TransParams) []byte {
+// It returns a warning for each param that had characters replaced in
+// lenient mode. In strict mode, the params that can't be written make it
+// fail with domain.EncodingErrors. Commands that would alter the protocol
+// are never written, see domain.TransCommand.CheckProtocol
+func appendCmd(
+	buf []byte, cmd string, args []domain.TransParams, commit bool, charset transCharset,
+) ([]byte, []string, error) {
+	if errs := (domain.TransCommand{Command: cmd, Params: args}).CheckProtocol(); len(errs) > 0 {
+		return nil, nil, errs
+	}
+	var warnings []string
+	var errs domain.ParamErrors
+	encode := func(key, s string) string {
+		encoded, replaced, err := charset.encode(s)
+		if err != nil {
+			errs = append(errs, domain.ParamError{Key: key, Reason: err.Error()})
+		}
+		if replaced > 0 {
+			warnings = append(warnings, fmt.Sprintf(
+				"param %s: %d characters can't be written in %s and were replaced", key, replaced, charset.name,
+			))
+		}
+		return encoded
+	}
 	buf = append(buf, "cmd:"...)
 	buf = append(buf, cmd...)
 	buf = append(buf, '\n')
 	for _, param := range args {
-		key := param.Key
-		if value, ok := param.Value.(string); ok {
-			if param.Blob {
-				if decoded, err := base64.StdEncoding.DecodeString(value); err == nil {
-					value = string(decoded)
-					buf = append(buf, "blob:"...)
-					buf = strconv.AppendInt(buf, int64(len(value)), 10)
-					buf = append(buf, ':')
-					buf = append(buf, key...)
-					buf = append(buf, '\n')
-					buf = append(buf, value...)
-					buf = append(buf, '\n')
-				}
-				continue
-			}
-			key, err := charmap.ISO8859_1.NewEncoder().String(key)
-			if err != nil {
-				continue
-			}
-			value, err = charmap.ISO8859_1.NewEncoder().String(value)
-			if err != nil {
-				continue
+		value, ok := param.Value.(string)
+		if !ok {
+			continue
+		}
+		key := encode(param.Key, param.Key)
+		if param.Blob {
+			// blobs are raw bytes, they are not written in the charset
+			if decoded, err := base64.StdEncoding.DecodeString(value); err == nil {
+				buf = append(buf, "blob:"...)
+				buf = strconv.AppendInt(buf, int64(len(decoded)), 10)
+				buf = append(buf, ':')
+				buf = append(buf, key...)
+				buf = append(buf, '\n')
+				buf = append(buf, decoded...)
+				buf = append(buf, '\n')
 			}
-			buf = append(buf, key...)
-			buf = append(buf, ':')
-			buf = append(buf, value...)
-			buf = append(buf, '\n')
+			continue
 		}
+		value = encode(param.Key, value)
+		buf = append(buf, key...)
+		buf = append(buf, ':')
+		buf = append(buf, value...)
+		buf = append(buf, '\n')
+	}
+	if len(errs) > 0 {
+		return nil, nil, domain.EncodingErrors(errs)
+	}
+	if commit {
+		buf = append(buf, "commit:1"...)
+	} else {
+		buf = append(buf, "commit:0"...)
 	}
-	buf = append(buf, "commit:1"...)
 	buf = append(buf, "\nend\n"...)
-	return buf
+	return buf, warnings, nil
 }
@@ -0,0 +1,256 @@
+package infrastructure
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/interfaces/loggers"
+)
+
+// TransUnixScheme prefix of the backends reached through a unix socket,
+// followed by the path of the socket
+const TransUnixScheme = "unix://"
+
+const (
+	// RoundRobin balancer strategy that picks the backends in turns
+	RoundRobin = "round-robin"
+	// LeastInFlight balancer strategy that picks the backend with the
+	// fewest commands being executed
+	LeastInFlight = "least-in-flight"
+)
+
+// TransBackendStats is a snapshot of the state of a trans backend
+type TransBackendStats struct {
+	// Address of the backend, as host:port
+	Address string
+	// Healthy tells if the backend is receiving commands
+	Healthy bool
+	// InFlight number of commands being executed on the backend
+	InFlight int64
+	// Pool usage of the connections to the backend
+	Pool TransPoolStats
+	// Breaker state of the circuit breaker of the backend
+	Breaker TransBreakerStats
+	// Timeouts number of commands that timed out in each phase
+	Timeouts map[string]int64
+	// Limits number of responses that exceeded each limit
+	Limits map[string]int64
+}
+
+// transBackend is one of the trans servers commands can be sent to
+type transBackend struct {
+	address string
+	charset transCharset
+	pool    *transPool
+	breaker *circuitBreaker
+	// inFlight number of commands being executed, accessed atomically
+	inFlight int64
+	// unhealthy is set to 1 while the backend is ejected, accessed atomically
+	unhealthy int32
+	// failures consecutive failed probes, only used by the health checker
+	failures int
+	// timeouts number of commands that timed out in each phase
+	timeouts      map[string]int64
+	timeoutsMutex sync.Mutex
+	// limits number of responses that exceeded each limit
+	limits      map[string]int64
+	limitsMutex sync.Mutex
+}
+
+// countTimeout counts a command that timed out in the given phase
+func (b *transBackend) countTimeout(phase string) {
+	b.timeoutsMutex.Lock()
+	defer b.timeoutsMutex.Unlock()
+	if b.timeouts == nil {
+		b.timeouts = make(map[string]int64)
+	}
+	b.timeouts[phase]++
+}
+
+// countLimit counts a response that exceeded the given limit
+func (b *transBackend) countLimit(limit string) {
+	b.limitsMutex.Lock()
+	defer b.limitsMutex.Unlock()
+	if b.limits == nil {
+		b.limits = make(map[string]int64)
+	}
+	b.limits[limit]++
+}
+
+// Healthy tells if the backend is receiving commands
+func (b *transBackend) Healthy() bool {
+	return atomic.LoadInt32(&b.unhealthy) == 0
+}
+
+// setHealthy ejects or re-admits the backend, telling if it changed
+func (b *transBackend) setHealthy(healthy bool) bool {
+	value := int32(1)
+	if healthy {
+		value = 0
+	}
+	return atomic.SwapInt32(&b.unhealthy, value) != value
+}
+
+// Stats returns a snapshot of the state of the backend
+func (b *transBackend) Stats() TransBackendStats {
+	timeouts := make(map[string]int64, len(transPhases))
+	b.timeoutsMutex.Lock()
+	for _, phase := range transPhases {
+		timeouts[phase] = b.timeouts[phase]
+	}
+	b.timeoutsMutex.Unlock()
+	limits := make(map[string]int64, len(transLimits))
+	b.limitsMutex.Lock()
+	for _, limit := range transLimits {
+		limits[limit] = b.limits[limit]
+	}
+	b.limitsMutex.Unlock()
+	return TransBackendStats{
+		Address:  b.address,
+		Healthy:  b.Healthy(),
+		InFlight: atomic.LoadInt64(&b.inFlight),
+		Pool:     b.pool.Stats(),
+		Breaker:  b.breaker.Stats(),
+		Timeouts: timeouts,
+		Limits:   limits,
+	}
+}
+
+// parseTransBackends splits a list of backends separated by ',', where
+// each one is host, host:port or a unix socket as unix:///path.
+// defaultPort is used when the port is missing
+func parseTransBackends(hosts string, defaultPort int) []string {
+	var addresses []string
+	for _, host := range strings.Split(hosts, ",") {
+		host = strings.TrimSpace(host)
+		if host == "" {
+			continue
+		}
+		if strings.HasPrefix(host, TransUnixScheme) {
+			addresses = append(addresses, host)
+			continue
+		}
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, strconv.Itoa(defaultPort))
+		}
+		addresses = append(addresses, host)
+	}
+	return addresses
+}
+
+// dialAddress returns the network and the address a backend is dialed with
+func dialAddress(address string) (string, string) {
+	if strings.HasPrefix(address, TransUnixScheme) {
+		return "unix", strings.TrimPrefix(address, TransUnixScheme)
+	}
+	return "tcp", address
+}
+
+// transBalancer decides which backend receives each command
+type transBalancer struct {
+	strategy string
+	backends []*transBackend
+	// next index of the backend to start with, accessed atomically
+	next uint64
+}
+
+// candidates returns the healthy backends in the order they should be
+// tried, the first one being the pick of the strategy and the rest the
+// failover. If every backend is ejected all of them are tried, as
+// the health checker may not have noticed yet they are back
+func (b *transBalancer) candidates() []*transBackend {
+	healthy := make([]*transBackend, 0, len(b.backends))
+	for _, backend := range b.backends {
+		if backend.Healthy() {
+			healthy = append(healthy, backend)
+		}
+	}
+	if len(healthy) == 0 {
+		healthy = append(healthy, b.backends...)
+	}
+	if len(healthy) == 0 {
+		return healthy
+	}
+	start := int((atomic.AddUint64(&b.next, 1) - 1) % uint64(len(healthy)))
+	candidates := make([]*transBackend, 0, len(healthy))
+	candidates = append(candidates, healthy[start:]...)
+	candidates = append(candidates, healthy[:start]...)
+	if b.strategy == LeastInFlight {
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return atomic.LoadInt64(&candidates[i].inFlight) <
+				atomic.LoadInt64(&candidates[j].inFlight)
+		})
+	}
+	return candidates
+}
+
+// transHealthChecker periodically probes every backend with a command,
+// ejecting the ones that fail Threshold times in a row and re-admitting
+// them as soon as a probe succeeds
+type transHealthChecker struct {
+	conf     TransHealthCheckConf
+	backends []*transBackend
+	logger   loggers.Logger
+	// probe sends the configured command to the backend
+	probe func(ctx context.Context, backend *transBackend) error
+	done  chan struct{}
+	once  sync.Once
+}
+
+// Start launches the goroutine that probes the backends
+func (hc *transHealthChecker) Start() {
+	go func() {
+		ticker := time.NewTicker(hc.conf.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-hc.done:
+				return
+			case <-ticker.C:
+				hc.checkAll()
+			}
+		}
+	}()
+}
+
+// Close stops probing the backends
+func (hc *transHealthChecker) Close() error {
+	hc.once.Do(func() { close(hc.done) })
+	return nil
+}
+
+// checkAll probes every backend concurrently and waits for the results
+func (hc *transHealthChecker) checkAll() {
+	var wg sync.WaitGroup
+	for _, backend := range hc.backends {
+		wg.Add(1)
+		go func(backend *transBackend) {
+			defer wg.Done()
+			hc.check(backend)
+		}(backend)
+	}
+	wg.Wait()
+}
+
+// check probes a backend and updates its health
+func (hc *transHealthChecker) check(backend *transBackend) {
+	ctx, cancel := context.WithTimeout(context.Background(), hc.conf.Interval)
+	defer cancel()
+	if err := hc.probe(ctx, backend); err != nil {
+		backend.failures++
+		if backend.failures >= hc.conf.Threshold && backend.setHealthy(false) {
+			hc.logger.Error("Trans backend %s ejected: %s", backend.address, err)
+		}
+		return
+	}
+	backend.failures = 0
+	if backend.setHealthy(true) {
+		hc.logger.Info("Trans backend %s is back", backend.address)
+	}
+}
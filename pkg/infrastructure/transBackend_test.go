@@ -0,0 +1,74 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTransBackends(t *testing.T) {
+	backends := parseTransBackends("trans1, trans2:5656,,[::1]:20005,unix:///var/run/trans.sock", 20005)
+	assert.Equal(t, []string{"trans1:20005", "trans2:5656", "[::1]:20005", "unix:///var/run/trans.sock"}, backends)
+}
+
+func TestDialAddress(t *testing.T) {
+	network, address := dialAddress("unix:///var/run/trans.sock")
+	assert.Equal(t, "unix", network)
+	assert.Equal(t, "/var/run/trans.sock", address)
+	network, address = dialAddress("trans1:20005")
+	assert.Equal(t, "tcp", network)
+	assert.Equal(t, "trans1:20005", address)
+}
+
+func TestBalancerRoundRobin(t *testing.T) {
+	a, b, c := &transBackend{address: "a"}, &transBackend{address: "b"}, &transBackend{address: "c"}
+	balancer := transBalancer{strategy: RoundRobin, backends: []*transBackend{a, b, c}}
+
+	assert.Equal(t, []*transBackend{a, b, c}, balancer.candidates())
+	assert.Equal(t, []*transBackend{b, c, a}, balancer.candidates())
+	// ejected backends are skipped
+	b.setHealthy(false)
+	assert.Equal(t, []*transBackend{a, c}, balancer.candidates())
+	// unless every backend is ejected
+	a.setHealthy(false)
+	c.setHealthy(false)
+	assert.Len(t, balancer.candidates(), 3)
+}
+
+func TestBalancerLeastInFlight(t *testing.T) {
+	a, b, c := &transBackend{address: "a"}, &transBackend{address: "b"}, &transBackend{address: "c"}
+	a.inFlight = 3
+	b.inFlight = 1
+	c.inFlight = 2
+	balancer := transBalancer{strategy: LeastInFlight, backends: []*transBackend{a, b, c}}
+
+	assert.Equal(t, []*transBackend{b, c, a}, balancer.candidates())
+}
+
+func TestHealthCheckerThreshold(t *testing.T) {
+	backend := &transBackend{address: "a"}
+	var probeErr error
+	logger := MockLoggerInfrastructure{}
+	logger.On("Error").Once()
+	logger.On("Info").Once()
+	checker := transHealthChecker{
+		conf:     TransHealthCheckConf{Threshold: 2},
+		backends: []*transBackend{backend},
+		logger:   &logger,
+		probe: func(context.Context, *transBackend) error {
+			return probeErr
+		},
+	}
+
+	probeErr = errors.New("down")
+	checker.checkAll()
+	assert.True(t, backend.Healthy())
+	checker.checkAll()
+	assert.False(t, backend.Healthy())
+	probeErr = nil
+	checker.checkAll()
+	assert.True(t, backend.Healthy())
+	logger.AssertExpectations(t)
+}
@@ -0,0 +1,187 @@
+package infrastructure
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/interfaces/handlers"
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/interfaces/loggers"
+)
+
+const (
+	// CaptureSinkLog capture sink that writes each capture to the log
+	CaptureSinkLog = "log"
+	// CaptureSinkRing capture sink that keeps the last captures in memory,
+	// to be read from the admin endpoint
+	CaptureSinkRing = "ring"
+)
+
+// redactedValue replaces the values of the masked params in the captures
+const redactedValue = "[REDACTED]"
+
+// transCapturer records the exchanges of the captured commands, masking
+// the sensitive params
+type transCapturer struct {
+	redact map[string]bool
+	// ring keeps the captures, nil if they go to the log
+	ring   *transCaptureRing
+	logger loggers.Logger
+}
+
+// newTransCapturer creates a capturer that sends the captures to the sink
+// of the configuration
+func newTransCapturer(conf TransCaptureConf, logger loggers.Logger) *transCapturer {
+	capturer := &transCapturer{
+		redact: conf.redactKeys(),
+		logger: logger,
+	}
+	if conf.Sink != CaptureSinkLog {
+		capturer.ring = newTransCaptureRing(conf.RingSize)
+	}
+	return capturer
+}
+
+// record masks and stores the exchange of a command
+func (c *transCapturer) record(
+	command *transCommand, backend string, start time.Time, request, response []byte, err error,
+) {
+	capture := handlers.TransCapture{
+		Time:     start,
+		Command:  command.name,
+		Backend:  backend,
+		Duration: time.Since(start).String(),
+		Request:  redactWire(request, c.redact),
+		Response: redactWire(response, c.redact),
+	}
+	if err != nil {
+		capture.Error = err.Error()
+	}
+	// the wire is written in the charset of trans, that json can't keep
+	// as it is unless it's UTF-8
+	if !utf8.ValidString(capture.Request) || !utf8.ValidString(capture.Response) {
+		capture.Encoding = handlers.CaptureEncodingBase64
+		capture.Request = base64.StdEncoding.EncodeToString([]byte(capture.Request))
+		capture.Response = base64.StdEncoding.EncodeToString([]byte(capture.Response))
+	}
+	if c.ring != nil {
+		c.ring.add(capture)
+		return
+	}
+	encoded, _ := json.Marshal(capture) // nolint: gosec
+	c.logger.Info("Trans capture %s", encoded)
+}
+
+// Captures returns the captures kept in memory, the oldest first
+func (c *transCapturer) Captures() []handlers.TransCapture {
+	if c.ring == nil {
+		return nil
+	}
+	return c.ring.captures()
+}
+
+// transCaptureRing keeps the last captures, dropping the oldest ones
+type transCaptureRing struct {
+	mutex sync.Mutex
+	ring  []handlers.TransCapture
+	// next index the next capture is written to
+	next int
+	full bool
+}
+
+// newTransCaptureRing creates a ring that keeps size captures, at least one
+func newTransCaptureRing(size int) *transCaptureRing {
+	if size < 1 {
+		size = 1
+	}
+	return &transCaptureRing{ring: make([]handlers.TransCapture, size)}
+}
+
+// add stores the capture, replacing the oldest one if the ring is full
+func (r *transCaptureRing) add(capture handlers.TransCapture) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.ring[r.next] = capture
+	r.next = (r.next + 1) % len(r.ring)
+	r.full = r.full || r.next == 0
+}
+
+// captures returns a copy of the stored captures, the oldest first
+func (r *transCaptureRing) captures() []handlers.TransCapture {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if !r.full {
+		return append([]handlers.TransCapture(nil), r.ring[:r.next]...)
+	}
+	captures := make([]handlers.TransCapture, 0, len(r.ring))
+	captures = append(captures, r.ring[r.next:]...)
+	return append(captures, r.ring[:r.next]...)
+}
+
+// redactWire returns the text of a request or response of trans, with the
+// values of the given keys masked. The contents of the blobs are replaced
+// by their size, as they are usually binary and large
+func redactWire(wire []byte, redact map[string]bool) string {
+	return string(maskWire(wire, redact, true))
+}
+
+// maskWire returns a request or response of trans with the values of the
+// given keys masked, blobs included. The contents of the rest of blobs are
+// replaced by their size if summarize is set, or kept otherwise
+func maskWire(wire []byte, redact map[string]bool, summarize bool) []byte {
+	var buf bytes.Buffer
+	for n := 0; n < len(wire); {
+		line := wire[n:]
+		if i := bytes.IndexByte(line, '\n'); i >= 0 {
+			line = line[:i+1]
+		}
+		n += len(line)
+		if blobLen, ok := wireBlobLen(line); ok {
+			key := bytes.TrimSuffix(line[bytes.IndexByte(line[5:], ':')+6:], []byte("\n"))
+			switch {
+			case redact[strings.ToLower(string(key))]:
+				fmt.Fprintf(&buf, "blob:%d:%s\n%s\n", len(redactedValue), key, redactedValue)
+			case summarize:
+				buf.Write(line)
+				fmt.Fprintf(&buf, "<%d bytes>\n", blobLen)
+			default:
+				end := n + blobLen + 1
+				if end > len(wire) {
+					end = len(wire)
+				}
+				buf.Write(line)
+				buf.Write(wire[n:end])
+			}
+			n += blobLen + 1
+			continue
+		}
+		if i := bytes.IndexByte(line, ':'); i >= 0 && redact[strings.ToLower(string(line[:i]))] {
+			buf.Write(line[:i+1])
+			buf.WriteString(redactedValue)
+			buf.WriteByte('\n')
+			continue
+		}
+		buf.Write(line)
+	}
+	return buf.Bytes()
+}
+
+// wireBlobLen returns the length of the blob that follows the line, if the
+// line is a blob header like blob:<length>:<key>
+func wireBlobLen(line []byte) (int, bool) {
+	if !bytes.HasPrefix(line, []byte("blob:")) {
+		return 0, false
+	}
+	i := bytes.IndexByte(line[5:], ':')
+	if i == -1 {
+		return 0, false
+	}
+	blobLen, err := strconv.Atoi(string(line[5 : 5+i]))
+	return blobLen, err == nil && blobLen >= 0
+}
@@ -0,0 +1,120 @@
+package infrastructure
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/domain"
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/interfaces/handlers"
+)
+
+func TestRedactWire(t *testing.T) {
+	redact := map[string]bool{"passwd": true, "token": true}
+	request := []byte("cmd:login\nemail:a@b.c\nPasswd:secret\nblob:3:image\n\x00\x01\x02\ntoken:t\ncommit:1\nend\n")
+	assert.Equal(t,
+		"cmd:login\nemail:a@b.c\nPasswd:[REDACTED]\nblob:3:image\n<3 bytes>\ntoken:[REDACTED]\ncommit:1\nend\n",
+		redactWire(request, redact),
+	)
+	assert.Equal(t, "status:TRANS_OK", redactWire([]byte("status:TRANS_OK"), redact))
+	assert.Equal(t, "", redactWire(nil, redact))
+}
+
+func TestMaskWire(t *testing.T) {
+	redact := map[string]bool{"passwd": true, "token": true}
+	request := []byte("cmd:login\npasswd:secret\nblob:3:image\n\x00\x01\x02\nblob:2:token\nab\ncommit:1\nend\n")
+	// the blobs are kept, unless they are masked
+	assert.Equal(t,
+		"cmd:login\npasswd:[REDACTED]\nblob:3:image\n\x00\x01\x02\nblob:10:token\n[REDACTED]\ncommit:1\nend\n",
+		string(maskWire(request, redact, false)),
+	)
+}
+
+func TestTransCaptureRing(t *testing.T) {
+	ring := newTransCaptureRing(2)
+	assert.Empty(t, ring.captures())
+	ring.add(handlers.TransCapture{Command: "a"})
+	assert.Equal(t, []handlers.TransCapture{{Command: "a"}}, ring.captures())
+	ring.add(handlers.TransCapture{Command: "b"})
+	ring.add(handlers.TransCapture{Command: "c"})
+	assert.Equal(t, []handlers.TransCapture{{Command: "b"}, {Command: "c"}}, ring.captures())
+}
+
+func TestSendCommandCapture(t *testing.T) {
+	server := NewMockTransServer()
+	defer server.Close()
+	server.SetHandler(func(input []byte) []byte {
+		return []byte("status:TRANS_OK\ntoken:abc\n")
+	})
+	conf := TransConf{
+		Host:            server.Address,
+		Timeout:         15,
+		AllowedCommands: "login|get_ad",
+		Commands: map[string]TransCommandConf{
+			"login": {Capture: true},
+		},
+		Capture: TransCaptureConf{
+			Sink:     CaptureSinkRing,
+			RingSize: 10,
+			Redact:   "passwd|token",
+		},
+	}
+	logger := MockLoggerInfrastructure{}
+	transFactory := NewTextProtocolTransFactory(conf, &logger)
+	defer transFactory.Close()
+
+	// the command is captured by its settings
+	params := []domain.TransParams{{Key: "passwd", Value: "secret"}}
+	_, err := transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: "login", Params: params})
+	assert.NoError(t, err)
+	// or because it was asked to
+	_, err = transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: "get_ad"})
+	assert.NoError(t, err)
+	_, err = transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: "get_ad", Capture: true})
+	assert.NoError(t, err)
+
+	captures := transFactory.Captures()
+	assert.Len(t, captures, 2)
+	assert.Equal(t, "login", captures[0].Command)
+	assert.Equal(t, server.Address, captures[0].Backend)
+	assert.Equal(t, "cmd:login\npasswd:[REDACTED]\ncommit:1\nend\n", captures[0].Request)
+	assert.Equal(t, "status:TRANS_OK\ntoken:[REDACTED]\n", captures[0].Response)
+	assert.Empty(t, captures[0].Error)
+	assert.Equal(t, "get_ad", captures[1].Command)
+	assert.Equal(t, "cmd:get_ad\ncommit:1\nend\n", captures[1].Request)
+	logger.AssertExpectations(t)
+}
+
+func TestTransCapturerEncoding(t *testing.T) {
+	capturer := newTransCapturer(TransCaptureConf{Sink: CaptureSinkRing, RingSize: 10, Redact: "passwd"}, nil)
+	command := &transCommand{name: "newad"}
+	// "subject:año" written in ISO-8859-1
+	request := []byte("cmd:newad\nsubject:a\xf1o\npasswd:secret\ncommit:1\nend\n")
+	capturer.record(command, "trans:20005", time.Now(), request, []byte("status:TRANS_OK\n"), nil)
+	capturer.record(command, "trans:20005", time.Now(), []byte("cmd:newad\ncommit:1\nend\n"), nil, nil)
+
+	captures := capturer.Captures()
+	assert.Equal(t, handlers.CaptureEncodingBase64, captures[0].Encoding)
+	decoded, err := base64.StdEncoding.DecodeString(captures[0].Request)
+	assert.NoError(t, err)
+	assert.Equal(t, "cmd:newad\nsubject:a\xf1o\npasswd:[REDACTED]\ncommit:1\nend\n", string(decoded))
+	decoded, err = base64.StdEncoding.DecodeString(captures[0].Response)
+	assert.NoError(t, err)
+	assert.Equal(t, "status:TRANS_OK\n", string(decoded))
+	// the captures in UTF-8 are kept as they are
+	assert.Empty(t, captures[1].Encoding)
+	assert.Equal(t, "cmd:newad\ncommit:1\nend\n", captures[1].Request)
+}
+
+func TestTransCapturerLogSink(t *testing.T) {
+	logger := MockLoggerInfrastructure{}
+	logger.On("Info").Once()
+	capturer := newTransCapturer(TransCaptureConf{Sink: CaptureSinkLog}, &logger)
+	command := &transCommand{name: "get_ad"}
+	capturer.record(command, "trans:20005", time.Now(), []byte("cmd:get_ad\nend\n"), nil, errors.New("read timeout"))
+	assert.Nil(t, capturer.Captures())
+	logger.AssertExpectations(t)
+}
@@ -0,0 +1,124 @@
+package infrastructure
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+const (
+	// CharsetISO88591 Latin 1, the charset trans uses by default
+	CharsetISO88591 = "iso-8859-1"
+	// CharsetWindows1252 Latin 1 plus some printable characters, like €
+	CharsetWindows1252 = "windows-1252"
+	// CharsetUTF8 UTF-8, for backends that take the text as is
+	CharsetUTF8 = "utf-8"
+
+	// CharsetStrict rejects the commands with params that can't be written
+	// in the charset of the backend
+	CharsetStrict = "strict"
+	// CharsetLenient writes the characters that can't be written in the
+	// charset of the backend as a replacement, and warns about it
+	CharsetLenient = "lenient"
+)
+
+// charsetReplacement is written instead of the characters that can't be
+// encoded in lenient mode
+const charsetReplacement = '?'
+
+// transCharset encodes the commands sent to a backend and decodes its
+// responses
+type transCharset struct {
+	name string
+	// charmap of the single byte charsets, nil for UTF-8
+	charmap *charmap.Charmap
+	lenient bool
+}
+
+// newTransCharset returns the charset with the given name, ISO-8859-1 if
+// empty. mode must be CharsetStrict or CharsetLenient, strict if empty
+func newTransCharset(name, mode string) (transCharset, error) {
+	charset := transCharset{name: strings.ToLower(name)}
+	switch charset.name {
+	case "", CharsetISO88591, "latin1":
+		charset.name = CharsetISO88591
+		charset.charmap = charmap.ISO8859_1
+	case CharsetWindows1252, "cp1252":
+		charset.name = CharsetWindows1252
+		charset.charmap = charmap.Windows1252
+	case CharsetUTF8, "utf8":
+		charset.name = CharsetUTF8
+	default:
+		return charset, fmt.Errorf("trans-proxy: unknown charset %q", name)
+	}
+	switch mode {
+	case "", CharsetStrict:
+	case CharsetLenient:
+		charset.lenient = true
+	default:
+		return charset, fmt.Errorf("trans-proxy: unknown charset mode %q", mode)
+	}
+	return charset, nil
+}
+
+// encode writes s in the charset. The characters that can't be written
+// make it fail, unless the charset is lenient: then they are replaced and
+// the number of replaced characters is returned
+func (c transCharset) encode(s string) (string, int, error) {
+	var buf strings.Builder
+	replaced := 0
+	for i, r := range s {
+		if r == utf8.RuneError {
+			if _, size := utf8.DecodeRuneInString(s[i:]); size == 1 {
+				if !c.lenient {
+					return "", 0, fmt.Errorf("invalid UTF-8 at byte %d", i)
+				}
+				replaced++
+				buf.WriteRune(charsetReplacement)
+				continue
+			}
+		}
+		if c.charmap == nil {
+			buf.WriteRune(r)
+			continue
+		}
+		b, ok := c.charmap.EncodeRune(r)
+		if !ok {
+			if !c.lenient {
+				return "", 0, fmt.Errorf("character %q can't be written in %s", r, c.name)
+			}
+			replaced++
+			b = charsetReplacement
+		}
+		buf.WriteByte(b)
+	}
+	return buf.String(), replaced, nil
+}
+
+// decode reads b from the charset as UTF-8. The bytes that are not valid
+// in the charset are replaced with U+FFFD, and their number returned
+func (c transCharset) decode(b []byte) (string, int) {
+	var buf strings.Builder
+	replaced := 0
+	if c.charmap == nil {
+		for len(b) > 0 {
+			r, size := utf8.DecodeRune(b)
+			if r == utf8.RuneError && size == 1 {
+				replaced++
+			}
+			buf.WriteRune(r)
+			b = b[size:]
+		}
+		return buf.String(), replaced
+	}
+	for _, octet := range b {
+		r := c.charmap.DecodeByte(octet)
+		if r == utf8.RuneError {
+			replaced++
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String(), replaced
+}
@@ -0,0 +1,261 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"regexp"
+	"strings"
+	"time"
+
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/domain"
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/interfaces/handlers"
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/interfaces/loggers"
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/interfaces/repository/services"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// TransModeReal mode that sends the commands to trans
+	TransModeReal = "real"
+	// TransModeFake mode that answers the commands from a fixture, see
+	// TransFakeFixture
+	TransModeFake = "fake"
+)
+
+const (
+	// FakeErrorBusy fake error that answers as a busy trans
+	FakeErrorBusy = "busy"
+	// FakeErrorConnect fake error that answers as an unreachable trans
+	FakeErrorConnect = "connect"
+	// FakeErrorTimeout fake error that answers as a trans that takes too
+	// long to respond
+	FakeErrorTimeout = "timeout"
+)
+
+// TransFakeFixture holds the responses of the fake trans, read from yaml
+// or json:
+//
+//	latency: 10ms
+//	commands:
+//	  get_ad:
+//	    - params: {ad_id: "[0-9]+"}
+//	      response: {status: TRANS_OK, subject: car}
+//	    - response: {status: TRANS_ERROR, error: AD_NOT_FOUND}
+//	      latency: 1s
+//	  newad:
+//	    - error: busy
+//	      error_rate: 0.2
+//	      response: {status: TRANS_OK, ad_id: "10"}
+type TransFakeFixture struct {
+	// Latency added to every command without a latency of its own
+	Latency Duration `yaml:"latency"`
+	// Commands the rules of each command, the first one that matches the
+	// params of the command answers it
+	Commands map[string][]TransFakeRule `yaml:"commands"`
+}
+
+// TransFakeRule is a response of the fake trans to the commands whose
+// params match
+type TransFakeRule struct {
+	// Params regular expressions the whole value of each param must match.
+	// Params not listed can have any value
+	Params map[string]string `yaml:"params"`
+	// Response the fields of the response, in order. A list as value
+	// repeats the key
+	Response fakeTransFields `yaml:"response"`
+	// Latency overrides the latency of the fixture
+	Latency *Duration `yaml:"latency"`
+	// Error fails the command as FakeErrorBusy, FakeErrorConnect,
+	// FakeErrorTimeout, or with any other message
+	Error string `yaml:"error"`
+	// ErrorRate chance, between 0 and 1, the command fails with Error
+	// instead of getting Response. Zero always fails if Error is set
+	ErrorRate float64 `yaml:"error_rate"`
+
+	params transParamPatterns
+}
+
+// transParamPatterns are the regular expressions the whole value of each
+// param must match, keyed by param
+type transParamPatterns map[string]*regexp.Regexp
+
+// compileTransParamPatterns compiles the patterns of the params
+func compileTransParamPatterns(patterns map[string]string) (transParamPatterns, error) {
+	compiled := make(transParamPatterns, len(patterns))
+	for key, pattern := range patterns {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("param %s: %s", key, err)
+		}
+		compiled[key] = re
+	}
+	return compiled, nil
+}
+
+// match tells if the params have a value matching each pattern
+func (p transParamPatterns) match(params []domain.TransParams) bool {
+	for key, re := range p {
+		matched := false
+		for _, param := range params {
+			if value, ok := param.Value.(string); ok && param.Key == key && re.MatchString(value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// fakeTransFields are the fields of a response, read from a yaml mapping
+// keeping their order
+type fakeTransFields domain.TransFields
+
+// UnmarshalYAML reads the fields from a mapping, where a list as value
+// repeats the key
+func (f *fakeTransFields) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: the response must be a mapping", value.Line)
+	}
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		key, node := value.Content[i].Value, value.Content[i+1]
+		var values []string
+		if node.Kind == yaml.SequenceNode {
+			if err := node.Decode(&values); err != nil {
+				return err
+			}
+		} else {
+			values = []string{node.Value}
+		}
+		for _, v := range values {
+			*f = append(*f, domain.TransField{Key: key, Value: v})
+		}
+	}
+	return nil
+}
+
+// LoadTransFakeFixture reads the fixture at path, in yaml or json
+func LoadTransFakeFixture(path string) (*TransFakeFixture, error) {
+	b, err := ioutil.ReadFile(path) // nolint: gosec
+	if err != nil {
+		return nil, err
+	}
+	var fixture TransFakeFixture
+	if err := yaml.Unmarshal(b, &fixture); err != nil {
+		return nil, err
+	}
+	for name, rules := range fixture.Commands {
+		for i := range rules {
+			if rules[i].params, err = compileTransParamPatterns(rules[i].Params); err != nil {
+				return nil, fmt.Errorf("command %s, rule %d, %s", name, i, err)
+			}
+		}
+	}
+	return &fixture, nil
+}
+
+// fakeTransFactory is a TransFactory that answers the commands from a
+// fixture, for local development without trans
+type fakeTransFactory struct {
+	fixture         *TransFakeFixture
+	logger          loggers.Logger
+	allowedCommands []string
+}
+
+// NewFakeTransFactory initialize a TransFactory that answers the commands
+// from the fixture of the configuration
+func NewFakeTransFactory(conf TransConf, logger loggers.Logger) (TransFactory, error) {
+	fixture, err := LoadTransFakeFixture(conf.FakeFixture)
+	if err != nil {
+		return nil, fmt.Errorf("trans fake fixture %s: %s", conf.FakeFixture, err)
+	}
+	return &fakeTransFactory{
+		fixture:         fixture,
+		logger:          logger,
+		allowedCommands: strings.Split(conf.AllowedCommands, "|"),
+	}, nil
+}
+
+// MakeTransHandler returns the handler that answers from the fixture
+func (f *fakeTransFactory) MakeTransHandler() services.TransHandler {
+	return f
+}
+
+// Stats returns no backends, as there are none
+func (f *fakeTransFactory) Stats() []TransBackendStats {
+	return nil
+}
+
+// Captures returns nothing, the fake trans has no wire to capture
+func (f *fakeTransFactory) Captures() []handlers.TransCapture {
+	return nil
+}
+
+// Close does nothing
+func (f *fakeTransFactory) Close() error {
+	return nil
+}
+
+// SendCommand answers the command with the first rule of the fixture that
+// matches its params, after the latency of the rule. Commands not in the
+// fixture answer as trans does for unknown commands
+func (f *fakeTransFactory) SendCommand(ctx context.Context, command domain.TransCommand) (services.TransReply, error) {
+	allowed := false
+	for _, name := range f.allowedCommands {
+		allowed = allowed || name == command.Command
+	}
+	if !allowed {
+		err := fmt.Errorf("invalid command - commands allowed: %s", f.allowedCommands)
+		f.logger.Error(err.Error())
+		return services.TransReply{Fields: domain.TransFields{{Key: "error", Value: err.Error()}}}, err
+	}
+	rules, ok := f.fixture.Commands[command.Command]
+	if !ok {
+		return services.TransReply{Fields: domain.TransFields{{Key: "status", Value: domain.TransNoCommand}}}, nil
+	}
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.params.match(command.Params) {
+			continue
+		}
+		latency := time.Duration(f.fixture.Latency)
+		if rule.Latency != nil {
+			latency = time.Duration(*rule.Latency)
+		}
+		select {
+		case <-time.After(latency):
+		case <-ctx.Done():
+			return services.TransReply{}, ctx.Err()
+		}
+		if err := rule.err(latency); err != nil {
+			f.logger.Error("Fake error sending command %s: %s\n", command.Command, err)
+			return services.TransReply{}, err
+		}
+		return services.TransReply{Fields: append(domain.TransFields(nil), rule.Response...)}, nil
+	}
+	return services.TransReply{Fields: domain.TransFields{
+		{Key: "status", Value: "TRANS_ERROR"},
+		{Key: "error", Value: "no fake response matches the params"},
+	}}, nil
+}
+
+// err returns the error the rule fails with this time, if any
+func (r *TransFakeRule) err(latency time.Duration) error {
+	if r.Error == "" || r.ErrorRate > 0 && rand.Float64() >= r.ErrorRate { // nolint: gosec
+		return nil
+	}
+	switch r.Error {
+	case FakeErrorBusy:
+		return domain.ErrTransBusy
+	case FakeErrorConnect:
+		return errConnect
+	case FakeErrorTimeout:
+		return domain.TimeoutError{Phase: PhaseRead, Timeout: latency}
+	}
+	return errors.New(r.Error)
+}
@@ -0,0 +1,94 @@
+package infrastructure
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/domain"
+)
+
+func TestFakeTransFactory(t *testing.T) {
+	conf := TransConf{
+		Mode:            TransModeFake,
+		FakeFixture:     "testdata/fake.yml",
+		AllowedCommands: "get_ad|newad|deletead|bump_ad|slow|transinfo",
+	}
+	logger := MockLoggerInfrastructure{}
+	logger.On("Error")
+	transFactory, err := NewFakeTransFactory(conf, &logger)
+	require.NoError(t, err)
+	defer transFactory.Close()
+	handler := transFactory.MakeTransHandler()
+	send := func(command string, params ...domain.TransParams) (domain.TransFields, error) {
+		reply, err := handler.SendCommand(context.Background(), domain.TransCommand{Command: command, Params: params})
+		return reply.Fields, err
+	}
+
+	fields, err := send("get_ad", domain.TransParams{Key: "ad_id", Value: "10"})
+	assert.NoError(t, err)
+	assert.Equal(t, domain.TransFields{
+		{Key: "status", Value: "TRANS_OK"},
+		{Key: "subject", Value: "car"},
+		{Key: "image", Value: "a.jpg"},
+		{Key: "image", Value: "b.jpg"},
+	}, fields)
+
+	// the pattern must match the whole value
+	fields, err = send("get_ad", domain.TransParams{Key: "ad_id", Value: "10a"})
+	assert.NoError(t, err)
+	assert.Equal(t, domain.TransFields{{Key: "status", Value: "TRANS_ERROR"}, {Key: "error", Value: "AD_NOT_FOUND"}}, fields)
+
+	fields, err = send("transinfo")
+	assert.NoError(t, err)
+	assert.Equal(t, domain.TransFields{{Key: "status", Value: domain.TransNoCommand}}, fields)
+
+	_, err = send("newad")
+	assert.Equal(t, domain.ErrTransBusy, err)
+	_, err = send("deletead")
+	assert.Equal(t, domain.TimeoutError{Phase: PhaseRead, Timeout: 5 * time.Millisecond}, err)
+	_, err = send("bump_ad")
+	assert.EqualError(t, err, "bump failed")
+	_, err = send("clear")
+	assert.EqualError(t, err, "invalid command - commands allowed: [get_ad newad deletead bump_ad slow transinfo]")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	_, err = handler.SendCommand(ctx, domain.TransCommand{Command: "slow"})
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	assert.Empty(t, transFactory.Stats())
+	assert.Empty(t, transFactory.Captures())
+	logger.AssertExpectations(t)
+}
+
+func TestLoadTransFakeFixture(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trans-fake")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	path := filepath.Join(dir, "fake.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte(
+		`{"latency": "2s", "commands": {"get_ad": [{"params": {"ad_id": "1"}, "response": {"status": "TRANS_OK"}}]}}`,
+	), 0600))
+	fixture, err := LoadTransFakeFixture(path)
+	assert.NoError(t, err)
+	assert.Equal(t, Duration(2*time.Second), fixture.Latency)
+	assert.Equal(t, fakeTransFields{{Key: "status", Value: "TRANS_OK"}}, fixture.Commands["get_ad"][0].Response)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"commands": {"get_ad": [{"params": {"ad_id": "("}}]}}`), 0600))
+	_, err = LoadTransFakeFixture(path)
+	assert.EqualError(t, err, "command get_ad, rule 0, param ad_id: error parsing regexp: missing closing ): `^(?:()$`")
+
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"commands": {"get_ad": [{"response": "TRANS_OK"}]}}`), 0600))
+	_, err = LoadTransFakeFixture(path)
+	assert.EqualError(t, err, "line 1: the response must be a mapping")
+
+	_, err = NewFakeTransFactory(TransConf{FakeFixture: filepath.Join(dir, "missing.yml")}, &MockLoggerInfrastructure{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,71 @@
+package infrastructure
+
+import (
+	"bufio"
+
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/domain"
+)
+
+const (
+	// LimitResponseSize limit of the bytes of a response
+	LimitResponseSize = "response_size"
+	// LimitLines limit of the lines of a response
+	LimitLines = "lines"
+	// LimitBlobSize limit of the bytes of a blob of a response
+	LimitBlobSize = "blob_size"
+)
+
+// transLimits every limit of the responses
+var transLimits = []string{LimitResponseSize, LimitLines, LimitBlobSize} // nolint: gochecknoglobals
+
+// transResponseLimiter tells when a response being read exceeds the limits
+// of its command
+type transResponseLimiter struct {
+	limits TransLimitsConf
+	size   int
+	lines  int
+}
+
+// readLine reads a line of the response, failing as soon as it's longer
+// than the bytes the response can still take, so a line that never ends
+// isn't kept in memory. The line is not counted, see line
+func (l *transResponseLimiter) readLine(reader *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		line = append(line, chunk...)
+		// the end message doesn't count, it must fit even on a full response
+		if l.limits.ResponseSize > 0 && len(line) > l.limits.ResponseSize-l.size+len(EndMessage) {
+			return nil, domain.ResponseLimitError{Limit: LimitResponseSize, Max: l.limits.ResponseSize}
+		}
+		if err != bufio.ErrBufferFull {
+			return line, err
+		}
+	}
+}
+
+// line counts a line of n bytes of the response
+func (l *transResponseLimiter) line(n int) error {
+	l.lines++
+	if l.limits.Lines > 0 && l.lines > l.limits.Lines {
+		return domain.ResponseLimitError{Limit: LimitLines, Max: l.limits.Lines}
+	}
+	return l.add(n)
+}
+
+// blob counts a blob of n bytes, followed by a newline, before it's read
+func (l *transResponseLimiter) blob(n int) error {
+	if l.limits.BlobSize > 0 && n > l.limits.BlobSize {
+		return domain.ResponseLimitError{Limit: LimitBlobSize, Max: l.limits.BlobSize}
+	}
+	return l.add(n + 1)
+}
+
+// add counts n bytes more of the response
+func (l *transResponseLimiter) add(n int) error {
+	l.size += n
+	if l.limits.ResponseSize > 0 && l.size > l.limits.ResponseSize {
+		return domain.ResponseLimitError{Limit: LimitResponseSize, Max: l.limits.ResponseSize}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package infrastructure
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/domain"
+)
+
+func TestReadTransResponseLimits(t *testing.T) {
+	response := "status:TRANS_OK\nblob:5:image\nabcde\nsubject:car\n"
+	cases := []struct {
+		name   string
+		input  string
+		limits TransLimitsConf
+		err    error
+	}{
+		{"unlimited", response + EndMessage, TransLimitsConf{}, nil},
+		{"exact", response + EndMessage, TransLimitsConf{ResponseSize: len(response), Lines: 3, BlobSize: 5}, nil},
+		{"size", response + EndMessage, TransLimitsConf{ResponseSize: len(response) - 1},
+			domain.ResponseLimitError{Limit: LimitResponseSize, Max: len(response) - 1}},
+		{"lines", response + EndMessage, TransLimitsConf{Lines: 2},
+			domain.ResponseLimitError{Limit: LimitLines, Max: 2}},
+		{"blob", response + EndMessage, TransLimitsConf{BlobSize: 4},
+			domain.ResponseLimitError{Limit: LimitBlobSize, Max: 4}},
+		// the blob is refused by its length, before reading it
+		{"blob length", "blob:1000000000:image\n", TransLimitsConf{ResponseSize: 100},
+			domain.ResponseLimitError{Limit: LimitResponseSize, Max: 100}},
+		// a line is refused before it ends
+		{"endless line", "status:" + strings.Repeat("a", 10000), TransLimitsConf{ResponseSize: 100},
+			domain.ResponseLimitError{Limit: LimitResponseSize, Max: 100}},
+		{"closed", response, TransLimitsConf{Lines: 2},
+			domain.ResponseLimitError{Limit: LimitLines, Max: 2}},
+		// without limits, the announced length is never allocated up front
+		{"unlimited blob length", "blob:1000000000:image\nabc", TransLimitsConf{}, io.ErrUnexpectedEOF},
+		{"huge blob length", "blob:9223372036854775807:image\n", TransLimitsConf{},
+			errors.New(`trans-proxy: blob length too large: "blob:9223372036854775807:image\n"`)},
+		{"invalid blob length", "blob:99999999999999999999:image\n", TransLimitsConf{},
+			errors.New(`trans-proxy: cannot parse blob length: "blob:99999999999999999999:image\n"`)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			read, complete, err := readTransResponse(bufio.NewReaderSize(strings.NewReader(c.input), 16), c.limits)
+			assert.Equal(t, c.err, err)
+			if c.err == nil {
+				assert.True(t, complete)
+				assert.Equal(t, response, string(read))
+			}
+		})
+	}
+}
+
+func TestTransResponseBlobLength(t *testing.T) {
+	_, err := TransResponse("blob:9223372036854775807:image\nabc\n").Fields()
+	assert.EqualError(t, err, `trans-proxy: cannot parse blob length: "9223372036854775807"`)
+	_, err = TransResponse("blob:2147483647:image\nabc\n").Fields()
+	assert.EqualError(t, err, `trans-proxy: blob is too short: "abc\n"`)
+}
+
+func TestSendCommandResponseLimits(t *testing.T) {
+	server := NewMockTransServer()
+	defer server.Close()
+	server.SetHandler(func(input []byte) []byte {
+		return []byte(strings.Repeat("ad_id:1\n", 10))
+	})
+	conf := TransConf{
+		Host:            server.Address,
+		Timeout:         15,
+		AllowedCommands: "list_ads|get_ad",
+		Commands: map[string]TransCommandConf{
+			"get_ad": {Limits: &TransLimitsConf{Lines: 5}},
+		},
+	}
+	logger := MockLoggerInfrastructure{}
+	logger.On("Error").Once()
+	transFactory := NewTextProtocolTransFactory(conf, &logger)
+	defer transFactory.Close()
+
+	reply, err := transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: "list_ads"})
+	assert.NoError(t, err)
+	assert.Len(t, reply.Fields, 10)
+
+	_, err = transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: "get_ad"})
+	assert.Equal(t, domain.ResponseLimitError{Limit: LimitLines, Max: 5}, err)
+	stats := transFactory.Stats()
+	assert.Equal(t, int64(1), stats[0].Limits[LimitLines])
+	assert.Equal(t, int64(0), stats[0].Limits[LimitResponseSize])
+	// the rest of the response was not read, so the connection is closed
+	assert.Equal(t, 0, stats[0].Pool.Idle)
+	logger.AssertExpectations(t)
+}
@@ -0,0 +1,222 @@
+package infrastructure
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/domain"
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/interfaces/handlers"
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/interfaces/loggers"
+)
+
+// errMockDisconnect makes the MockTransServer drop the connection
+var errMockDisconnect = errors.New("trans mock: disconnect")
+
+// TransMock is a MockTransServer scripted with expectations and faults, as
+// the trans-mock command serves it to the services that can't use the
+// MockTransServer in their tests
+type TransMock struct {
+	server *MockTransServer
+	logger loggers.Logger
+
+	mtx          sync.Mutex
+	expectations []*transMockExpectation
+	faults       transMockFaults
+	calls        []handlers.TransMockCall
+}
+
+// transMockExpectation is an expectation ready to match the commands
+type transMockExpectation struct {
+	handlers.TransMockExpectation
+	params   transParamPatterns
+	latency  time.Duration
+	answered int
+}
+
+// transMockFaults are the faults injected in every command
+type transMockFaults struct {
+	latency        time.Duration
+	disconnectRate float64
+}
+
+// NewTransMock starts a TransMock serving on address, as host:port or
+// unix:///path of a unix socket.
+// The caller should call Close when finished, to shut it down.
+func NewTransMock(address string, logger loggers.Logger) (*TransMock, error) {
+	l, err := net.Listen(dialAddress(address))
+	if err != nil {
+		return nil, err
+	}
+	mock := &TransMock{
+		server: &MockTransServer{listener: l},
+		logger: logger,
+	}
+	mock.server.SetDisconnectingHandler(mock.respond)
+	mock.server.Start()
+	return mock, nil
+}
+
+// Address returns the address trans commands are served on
+func (m *TransMock) Address() string {
+	return m.server.Address
+}
+
+// Close shuts down the server
+func (m *TransMock) Close() error {
+	m.server.Close()
+	return nil
+}
+
+// Expect adds the expectations after the ones already set. None is added if
+// any of them is invalid
+func (m *TransMock) Expect(expectations []handlers.TransMockExpectation) error {
+	ready := make([]*transMockExpectation, 0, len(expectations))
+	for i, expectation := range expectations {
+		if expectation.Command == "" {
+			return fmt.Errorf("expectation %d: the command is required", i)
+		}
+		if expectation.Times < 0 {
+			return fmt.Errorf("expectation %d: times can't be negative", i)
+		}
+		params, err := compileTransParamPatterns(expectation.Params)
+		if err != nil {
+			return fmt.Errorf("expectation %d: %s", i, err)
+		}
+		latency, err := parseMockLatency(expectation.Latency)
+		if err != nil {
+			return fmt.Errorf("expectation %d: %s", i, err)
+		}
+		if expectation.Response != "" && !strings.HasSuffix(expectation.Response, "\n") {
+			expectation.Response += "\n"
+		}
+		ready = append(ready, &transMockExpectation{
+			TransMockExpectation: expectation,
+			params:               params,
+			latency:              latency,
+		})
+	}
+	m.mtx.Lock()
+	m.expectations = append(m.expectations, ready...)
+	m.mtx.Unlock()
+	return nil
+}
+
+// ExpectFile adds the expectations of the json file at path, written as
+// the body of the /expectations endpoint
+func (m *TransMock) ExpectFile(path string) error {
+	b, err := ioutil.ReadFile(path) // nolint: gosec
+	if err != nil {
+		return err
+	}
+	var input handlers.TransMockExpectHandlerInput
+	if err := json.Unmarshal(b, &input); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	return m.Expect(input.Expectations)
+}
+
+// SetFaults replaces the faults injected in every command
+func (m *TransMock) SetFaults(faults handlers.TransMockFaults) error {
+	latency, err := parseMockLatency(faults.Latency)
+	if err != nil {
+		return err
+	}
+	if faults.DisconnectRate < 0 || faults.DisconnectRate > 1 {
+		return fmt.Errorf("the disconnect rate must be between 0 and 1, got %g", faults.DisconnectRate)
+	}
+	m.mtx.Lock()
+	m.faults = transMockFaults{latency: latency, disconnectRate: faults.DisconnectRate}
+	m.mtx.Unlock()
+	m.server.SetBusy(faults.Busy)
+	return nil
+}
+
+// Calls gives the commands received, the oldest first
+func (m *TransMock) Calls() []handlers.TransMockCall {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return append([]handlers.TransMockCall(nil), m.calls...)
+}
+
+// Reset removes the expectations, the faults and the calls
+func (m *TransMock) Reset() {
+	m.mtx.Lock()
+	m.expectations = nil
+	m.faults = transMockFaults{}
+	m.calls = nil
+	m.mtx.Unlock()
+	m.server.SetBusy(false)
+}
+
+// respond answers the command with the first expectation that matches it,
+// after the latency of the expectation and the faults. Commands no
+// expectation matches get a TRANS_ERROR telling so
+func (m *TransMock) respond(input []byte) ([]byte, error) {
+	wire, err := parseTransWire(input)
+	if err != nil {
+		return []byte(fmt.Sprintf("status:TRANS_ERROR\nerror:%s\n", err)), nil
+	}
+	call := handlers.TransMockCall{
+		Time:    time.Now(),
+		Command: wire.command,
+		Params:  make([]handlers.TransMockParam, 0, len(wire.params)),
+		DryRun:  !wire.commit,
+	}
+	params := make([]domain.TransParams, 0, len(wire.params))
+	for _, param := range wire.params {
+		value := string(param.value)
+		if param.blob {
+			value = base64.StdEncoding.EncodeToString(param.value)
+		}
+		call.Params = append(call.Params, handlers.TransMockParam{Key: param.key, Value: value, Blob: param.blob})
+		params = append(params, domain.TransParams{Key: param.key, Value: value, Blob: param.blob})
+	}
+
+	m.mtx.Lock()
+	var expectation *transMockExpectation
+	for _, e := range m.expectations {
+		if e.Command == wire.command && (e.Times == 0 || e.answered < e.Times) && e.params.match(params) {
+			expectation = e
+			expectation.answered++
+			break
+		}
+	}
+	call.Expected = expectation != nil
+	m.calls = append(m.calls, call)
+	faults := m.faults
+	m.mtx.Unlock()
+
+	response := []byte(fmt.Sprintf("status:TRANS_ERROR\nerror:no expectation for %s\n", wire.command))
+	latency, disconnect := faults.latency, false
+	if expectation != nil {
+		response = []byte(expectation.Response)
+		latency += expectation.latency
+		disconnect = expectation.Disconnect
+	}
+	time.Sleep(latency)
+	if disconnect || faults.disconnectRate > 0 && rand.Float64() < faults.disconnectRate { // nolint: gosec
+		m.logger.Info("Disconnecting on command %s", wire.command)
+		return nil, errMockDisconnect
+	}
+	return response, nil
+}
+
+// parseMockLatency parses a latency, empty being none
+func parseMockLatency(latency string) (time.Duration, error) {
+	if latency == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(latency)
+	if err != nil {
+		return 0, fmt.Errorf("invalid latency %q", latency)
+	}
+	return d, nil
+}
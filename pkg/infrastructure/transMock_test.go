@@ -0,0 +1,152 @@
+package infrastructure
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/domain"
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/interfaces/handlers"
+)
+
+func TestTransMockExpectations(t *testing.T) {
+	logger := MockLoggerInfrastructure{}
+	mock, err := NewTransMock("127.0.0.1:0", &logger)
+	require.NoError(t, err)
+	defer mock.Close()
+	require.NoError(t, mock.Expect([]handlers.TransMockExpectation{
+		{Command: "get_ad", Params: map[string]string{"ad_id": "1"}, Response: "status:TRANS_OK\nsubject:car", Times: 1},
+		{Command: "get_ad", Params: map[string]string{"ad_id": "[0-9]+"}, Response: "status:TRANS_ERROR\nerror:AD_NOT_FOUND\n"},
+	}))
+
+	conf := TransConf{Host: mock.Address(), Timeout: 15, AllowedCommands: "get_ad|newad"}
+	transFactory := NewTextProtocolTransFactory(conf, &logger)
+	defer transFactory.Close()
+	send := func(command domain.TransCommand) domain.TransFields {
+		reply, err := transFactory.MakeTransHandler().SendCommand(context.Background(), command)
+		assert.NoError(t, err)
+		return reply.Fields
+	}
+	getAd := domain.TransCommand{Command: "get_ad", Params: []domain.TransParams{{Key: "ad_id", Value: "1"}}}
+
+	assert.Equal(t, domain.TransFields{{Key: "status", Value: "TRANS_OK"}, {Key: "subject", Value: "car"}}, send(getAd))
+	// the first expectation only answers once
+	assert.Equal(t, domain.TransFields{{Key: "status", Value: "TRANS_ERROR"}, {Key: "error", Value: "AD_NOT_FOUND"}}, send(getAd))
+	assert.Equal(t, domain.TransFields{
+		{Key: "status", Value: "TRANS_ERROR"},
+		{Key: "error", Value: "no expectation for newad"},
+	}, send(domain.TransCommand{Command: "newad", DryRun: true}))
+
+	calls := mock.Calls()
+	assert.Len(t, calls, 3)
+	assert.Equal(t, "get_ad", calls[0].Command)
+	assert.Equal(t, []handlers.TransMockParam{{Key: "ad_id", Value: "1"}}, calls[0].Params)
+	assert.True(t, calls[0].Expected)
+	assert.Equal(t, "newad", calls[2].Command)
+	assert.True(t, calls[2].DryRun)
+	assert.False(t, calls[2].Expected)
+
+	mock.Reset()
+	assert.Empty(t, mock.Calls())
+	assert.Equal(t, domain.TransFields{
+		{Key: "status", Value: "TRANS_ERROR"},
+		{Key: "error", Value: "no expectation for get_ad"},
+	}, send(getAd))
+	logger.AssertExpectations(t)
+}
+
+func TestTransMockFaults(t *testing.T) {
+	logger := MockLoggerInfrastructure{}
+	logger.On("Info")
+	logger.On("Error")
+	mock, err := NewTransMock("127.0.0.1:0", &logger)
+	require.NoError(t, err)
+	defer mock.Close()
+	require.NoError(t, mock.Expect([]handlers.TransMockExpectation{
+		{Command: "newad", Response: "status:TRANS_OK\n", Disconnect: true},
+		{Command: "get_ad", Response: "status:TRANS_OK\n", Latency: "30ms"},
+	}))
+	conf := TransConf{Host: mock.Address(), Timeout: 15, AllowedCommands: "get_ad|newad"}
+	send := func(command string) (domain.TransFields, error) {
+		transFactory := NewTextProtocolTransFactory(conf, &logger)
+		defer transFactory.Close()
+		reply, err := transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: command})
+		return reply.Fields, err
+	}
+	ok := domain.TransFields{{Key: "status", Value: "TRANS_OK"}}
+
+	start := time.Now()
+	fields, err := send("get_ad")
+	assert.NoError(t, err)
+	assert.Equal(t, ok, fields)
+	assert.True(t, time.Since(start) >= 30*time.Millisecond)
+	// the connection is closed without an answer, which the proxy reads as
+	// an empty response
+	fields, err = send("newad")
+	assert.NoError(t, err)
+	assert.Empty(t, fields)
+
+	require.NoError(t, mock.SetFaults(handlers.TransMockFaults{Latency: "20ms"}))
+	start = time.Now()
+	_, err = send("get_ad")
+	assert.NoError(t, err)
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+
+	require.NoError(t, mock.SetFaults(handlers.TransMockFaults{DisconnectRate: 1}))
+	fields, err = send("get_ad")
+	assert.NoError(t, err)
+	assert.Empty(t, fields)
+
+	require.NoError(t, mock.SetFaults(handlers.TransMockFaults{Busy: true}))
+	_, err = send("get_ad")
+	assert.Equal(t, domain.ErrTransBusy, err)
+	mock.Reset()
+	_, err = send("get_ad")
+	assert.NoError(t, err)
+
+	assert.EqualError(t, mock.SetFaults(handlers.TransMockFaults{Latency: "soon"}), `invalid latency "soon"`)
+	assert.EqualError(t, mock.SetFaults(handlers.TransMockFaults{DisconnectRate: 2}), "the disconnect rate must be between 0 and 1, got 2")
+}
+
+func TestTransMockInvalidExpectations(t *testing.T) {
+	mock, err := NewTransMock("127.0.0.1:0", &MockLoggerInfrastructure{})
+	require.NoError(t, err)
+	defer mock.Close()
+
+	assert.EqualError(t, mock.Expect([]handlers.TransMockExpectation{{Response: "status:TRANS_OK"}}),
+		"expectation 0: the command is required")
+	assert.EqualError(t, mock.Expect([]handlers.TransMockExpectation{{Command: "get_ad"}, {Command: "get_ad", Latency: "1"}}),
+		`expectation 1: invalid latency "1"`)
+	assert.EqualError(t, mock.Expect([]handlers.TransMockExpectation{{Command: "get_ad", Times: -1}}),
+		"expectation 0: times can't be negative")
+	assert.EqualError(t, mock.Expect([]handlers.TransMockExpectation{{Command: "get_ad", Params: map[string]string{"ad_id": "("}}}),
+		"expectation 0: param ad_id: error parsing regexp: missing closing ): `^(?:()$`")
+	// none of them was added
+	assert.Empty(t, mock.expectations)
+}
+
+func TestTransMockExpectFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trans-mock")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	path := filepath.Join(dir, "expectations.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte(
+		`{"expectations": [{"command": "transinfo", "response": "status:TRANS_OK"}]}`,
+	), 0600))
+
+	mock, err := NewTransMock("127.0.0.1:0", &MockLoggerInfrastructure{})
+	require.NoError(t, err)
+	defer mock.Close()
+	assert.NoError(t, mock.ExpectFile(path))
+	assert.Len(t, mock.expectations, 1)
+	assert.Equal(t, "status:TRANS_OK\n", mock.expectations[0].Response)
+
+	assert.Error(t, mock.ExpectFile(filepath.Join(dir, "missing.json")))
+	require.NoError(t, ioutil.WriteFile(path, []byte("{"), 0600))
+	assert.Error(t, mock.ExpectFile(path))
+}
@@ -0,0 +1,290 @@
+package infrastructure
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// transConn is a connection to trans that already received the greeting
+// and is ready to accept a command
+type transConn struct {
+	net.Conn
+	// reader buffers the reads made on the connection, it must be kept
+	// along the connection so no buffered data is lost between commands
+	reader *bufio.Reader
+	// idleSince is the time the connection was put idle in the pool, zero
+	// if it was dialed for the command using it
+	idleSince time.Time
+}
+
+// TransPoolStats is a snapshot of the usage of a pool of trans connections
+type TransPoolStats struct {
+	// Open number of connections currently open, in use or idle
+	Open int
+	// Idle number of connections waiting to be used
+	Idle int
+	// Dials total number of connections successfully dialed
+	Dials int64
+	// DialErrors total number of connections that could not be dialed
+	DialErrors int64
+	// Waits total number of times a command had to wait for a connection
+	Waits int64
+	// Evictions total number of idle connections closed for being too old
+	Evictions int64
+}
+
+// transPool keeps a bounded number of greeted connections to trans ready
+// to be used, so commands don't have to pay a handshake each. Trans closes
+// a connection after answering its command, so only fresh connections are
+// kept idle: the ones dialed to keep MinIdle ready
+type transPool struct {
+	conf TransPoolConf
+	// dial opens and greets a new connection to trans, within the given
+	// timeouts or the default ones if nil
+	dial func(ctx context.Context, timeouts *TransTimeoutsConf) (*transConn, error)
+
+	mtx     sync.Mutex
+	idle    []*transConn
+	open    int
+	waiters []chan struct{}
+	stats   TransPoolStats
+	closed  bool
+	done    chan struct{}
+}
+
+// newTransPool creates a pool of connections made with dial. If the conf
+// requires it, a goroutine is started to keep MinIdle connections ready and
+// evict the ones idle for longer than MaxIdleTime
+func newTransPool(conf TransPoolConf, dial func(ctx context.Context, timeouts *TransTimeoutsConf) (*transConn, error)) *transPool {
+	pool := &transPool{
+		conf: conf,
+		dial: dial,
+		done: make(chan struct{}),
+	}
+	if conf.MinIdle > 0 || conf.MaxIdleTime > 0 {
+		go pool.maintain()
+	}
+	return pool
+}
+
+// Get returns an idle connection or dials a new one. When MaxOpen
+// connections are already open, it waits for one to be released until
+// the context is done. New connections are dialed within the given
+// timeouts, or the default ones if nil, and the deadline of the context
+func (p *transPool) Get(ctx context.Context, timeouts *TransTimeoutsConf) (*transConn, error) {
+	p.mtx.Lock()
+	waited := false
+	for {
+		if p.closed {
+			p.mtx.Unlock()
+			return nil, fmt.Errorf("trans-proxy: connection pool closed")
+		}
+		if conn := p.popIdle(); conn != nil {
+			p.mtx.Unlock()
+			return conn, nil
+		}
+		if p.conf.MaxOpen <= 0 || p.open < p.conf.MaxOpen {
+			p.open++
+			p.mtx.Unlock()
+			return p.dialConn(ctx, timeouts)
+		}
+		if !waited {
+			waited = true
+			p.stats.Waits++
+		}
+		signal := make(chan struct{}, 1)
+		p.waiters = append(p.waiters, signal)
+		p.mtx.Unlock()
+
+		select {
+		case <-signal:
+			p.mtx.Lock()
+		case <-ctx.Done():
+			p.mtx.Lock()
+			p.removeWaiter(signal)
+			// the signal may have arrived along with the cancellation,
+			// hand it to the next in line so it's not lost
+			select {
+			case <-signal:
+				p.notify()
+			default:
+			}
+			p.mtx.Unlock()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Put gives the connection back to the pool to be used. Connections that
+// are not reusable, like the ones that already ran a command, are closed
+// freeing their slot
+func (p *transPool) Put(conn *transConn, reusable bool) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if reusable && !p.closed {
+		conn.idleSince = time.Now()
+		p.idle = append(p.idle, conn)
+	} else {
+		_ = conn.Close() // nolint: gosec
+		p.open--
+	}
+	p.notify()
+}
+
+// Stats returns a snapshot of the pool usage
+func (p *transPool) Stats() TransPoolStats {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	stats := p.stats
+	stats.Open = p.open
+	stats.Idle = len(p.idle)
+	return stats
+}
+
+// Close closes every idle connection and stops the pool maintenance.
+// Connections in use are closed as they are released
+func (p *transPool) Close() error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+	for _, conn := range p.idle {
+		_ = conn.Close() // nolint: gosec
+		p.open--
+	}
+	p.idle = nil
+	for range p.waiters {
+		p.notify()
+	}
+	return nil
+}
+
+// dialConn dials a new connection whose slot was already reserved in open,
+// giving up when ctx is done
+func (p *transPool) dialConn(ctx context.Context, timeouts *TransTimeoutsConf) (*transConn, error) {
+	conn, err := p.dial(ctx, timeouts)
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if err != nil {
+		p.open--
+		p.stats.DialErrors++
+		p.notify()
+		return nil, err
+	}
+	p.stats.Dials++
+	return conn, nil
+}
+
+// popIdle returns the most recently used idle connection that hasn't
+// expired, evicting the expired ones. Must be called holding the lock
+func (p *transPool) popIdle() *transConn {
+	for len(p.idle) > 0 {
+		conn := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if p.expired(conn, time.Now()) {
+			p.evict(conn)
+			continue
+		}
+		return conn
+	}
+	return nil
+}
+
+// expired tells if the connection was idle for longer than MaxIdleTime
+func (p *transPool) expired(conn *transConn, now time.Time) bool {
+	return p.conf.MaxIdleTime > 0 && now.Sub(conn.idleSince) > p.conf.MaxIdleTime
+}
+
+// evict closes an idle connection. Must be called holding the lock
+func (p *transPool) evict(conn *transConn) {
+	_ = conn.Close() // nolint: gosec
+	p.open--
+	p.stats.Evictions++
+	p.notify()
+}
+
+// notify wakes up the first command waiting for a connection.
+// Must be called holding the lock
+func (p *transPool) notify() {
+	if len(p.waiters) == 0 {
+		return
+	}
+	signal := p.waiters[0]
+	p.waiters = p.waiters[1:]
+	signal <- struct{}{}
+}
+
+// removeWaiter removes signal from the waiting list. Must be called
+// holding the lock
+func (p *transPool) removeWaiter(signal chan struct{}) {
+	for i, waiter := range p.waiters {
+		if waiter == signal {
+			p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// maintain periodically evicts expired connections and dials new ones
+// until MinIdle connections are ready, until the pool is closed
+func (p *transPool) maintain() {
+	interval := time.Second
+	if p.conf.MaxIdleTime > 0 && p.conf.MaxIdleTime/2 < interval {
+		interval = p.conf.MaxIdleTime / 2
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		p.evictExpired()
+		p.fillIdle()
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// evictExpired closes the idle connections that expired
+func (p *transPool) evictExpired() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	now := time.Now()
+	idle := p.idle[:0]
+	for _, conn := range p.idle {
+		if p.expired(conn, now) {
+			p.evict(conn)
+			continue
+		}
+		idle = append(idle, conn)
+	}
+	p.idle = idle
+}
+
+// fillIdle dials connections until MinIdle are idle or MaxOpen are open
+func (p *transPool) fillIdle() {
+	for {
+		p.mtx.Lock()
+		full := p.closed || len(p.idle) >= p.conf.MinIdle ||
+			(p.conf.MaxOpen > 0 && p.open >= p.conf.MaxOpen)
+		if !full {
+			p.open++
+		}
+		p.mtx.Unlock()
+		if full {
+			return
+		}
+		conn, err := p.dialConn(context.Background(), nil)
+		if err != nil {
+			return
+		}
+		p.Put(conn, true)
+	}
+}
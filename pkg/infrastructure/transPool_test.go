@@ -0,0 +1,104 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// pipeDialer returns a dial function that creates in memory connections
+func pipeDialer() func(context.Context, *TransTimeoutsConf) (*transConn, error) {
+	return func(context.Context, *TransTimeoutsConf) (*transConn, error) {
+		client, _ := net.Pipe()
+		return &transConn{Conn: client}, nil
+	}
+}
+
+func TestTransPoolReuse(t *testing.T) {
+	pool := newTransPool(TransPoolConf{MaxOpen: 2}, pipeDialer())
+	defer pool.Close()
+
+	conn, err := pool.Get(context.Background(), nil)
+	assert.NoError(t, err)
+	pool.Put(conn, true)
+	reused, err := pool.Get(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, conn, reused)
+	pool.Put(reused, false)
+
+	assert.Equal(t, TransPoolStats{Dials: 1}, pool.Stats())
+}
+
+func TestTransPoolWait(t *testing.T) {
+	pool := newTransPool(TransPoolConf{MaxOpen: 1}, pipeDialer())
+	defer pool.Close()
+
+	conn, err := pool.Get(context.Background(), nil)
+	assert.NoError(t, err)
+
+	// no connection available before the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = pool.Get(ctx, nil)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// the connection is handed to the waiting command once released
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		pool.Put(conn, true)
+	}()
+	waited, err := pool.Get(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, conn, waited)
+
+	stats := pool.Stats()
+	assert.Equal(t, int64(2), stats.Waits)
+	assert.Equal(t, 1, stats.Open)
+}
+
+func TestTransPoolDialError(t *testing.T) {
+	pool := newTransPool(TransPoolConf{MaxOpen: 1}, func(context.Context, *TransTimeoutsConf) (*transConn, error) {
+		return nil, errors.New("refused")
+	})
+	defer pool.Close()
+
+	_, err := pool.Get(context.Background(), nil)
+	assert.Error(t, err)
+	// the slot of the failed dial is released
+	_, err = pool.Get(context.Background(), nil)
+	assert.Error(t, err)
+	assert.Equal(t, TransPoolStats{DialErrors: 2}, pool.Stats())
+}
+
+func TestTransPoolMinIdleAndEviction(t *testing.T) {
+	pool := newTransPool(
+		TransPoolConf{MinIdle: 2, MaxIdleTime: 20 * time.Millisecond},
+		pipeDialer(),
+	)
+	defer pool.Close()
+
+	assert.Eventually(t, func() bool {
+		return pool.Stats().Idle == 2
+	}, time.Second, 5*time.Millisecond)
+	// expired connections are replaced to keep MinIdle ready
+	assert.Eventually(t, func() bool {
+		stats := pool.Stats()
+		return stats.Evictions >= 2 && stats.Idle == 2
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestTransPoolClose(t *testing.T) {
+	pool := newTransPool(TransPoolConf{}, pipeDialer())
+	conn, err := pool.Get(context.Background(), nil)
+	assert.NoError(t, err)
+	pool.Put(conn, true)
+
+	assert.NoError(t, pool.Close())
+	assert.Equal(t, 0, pool.Stats().Open)
+	_, err = pool.Get(context.Background(), nil)
+	assert.Error(t, err)
+}
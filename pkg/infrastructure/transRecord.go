@@ -0,0 +1,276 @@
+package infrastructure
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/interfaces/loggers"
+)
+
+// RecordEncodingBase64 encoding of the records whose params or response
+// are not valid UTF-8, as they were written in a charset of trans
+const RecordEncodingBase64 = "base64"
+
+// TransRecord is a command executed on trans and its response, as a line
+// of a recording. The params and the response are the ones on the wire
+type TransRecord struct {
+	Time    time.Time          `json:"time"`
+	Command string             `json:"command"`
+	Params  []TransRecordParam `json:"params"`
+	// DryRun tells the command was sent with commit:0
+	DryRun bool `json:"dry_run,omitempty"`
+	// Response the text trans answered, without the end line
+	Response string `json:"response"`
+	// Encoding RecordEncodingBase64 if the keys and values of the params
+	// and the response are encoded in base64, empty if they are written as
+	// they are
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// TransRecordParam is a param of a recorded command. The values of the
+// blobs are always encoded in base64
+type TransRecordParam struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Blob  bool   `json:"blob,omitempty"`
+}
+
+// newTransRecord builds the record of the request written to trans and
+// the response it got
+func newTransRecord(request []byte, response []byte) (TransRecord, error) {
+	wire, err := parseTransWire(request)
+	if err != nil {
+		return TransRecord{}, err
+	}
+	record := TransRecord{
+		Time:    time.Now(),
+		Command: wire.command,
+		Params:  make([]TransRecordParam, 0, len(wire.params)),
+		DryRun:  !wire.commit,
+	}
+	encode := func(text []byte) string { return string(text) }
+	if !wire.validUTF8() || !utf8.Valid(response) {
+		record.Encoding = RecordEncodingBase64
+		encode = base64.StdEncoding.EncodeToString
+	}
+	for _, param := range wire.params {
+		value := encode(param.value)
+		if param.blob {
+			value = base64.StdEncoding.EncodeToString(param.value)
+		}
+		record.Params = append(record.Params, TransRecordParam{
+			Key: encode([]byte(param.key)), Value: value, Blob: param.blob,
+		})
+	}
+	record.Response = encode(response)
+	return record, nil
+}
+
+// wire returns the command as it was written to trans, which is matched
+// on replay by its name, params and commit
+func (r TransRecord) wire() (transWire, error) {
+	decode := func(text string) ([]byte, error) { return []byte(text), nil }
+	if r.Encoding == RecordEncodingBase64 {
+		decode = base64.StdEncoding.DecodeString
+	}
+	wire := transWire{command: r.Command, commit: !r.DryRun}
+	for _, param := range r.Params {
+		key, err := decode(param.Key)
+		if err != nil {
+			return wire, fmt.Errorf("param %s of %s: %s", param.Key, r.Command, err)
+		}
+		var value []byte
+		if param.Blob {
+			value, err = base64.StdEncoding.DecodeString(param.Value)
+		} else {
+			value, err = decode(param.Value)
+		}
+		if err != nil {
+			return wire, fmt.Errorf("param %s of %s: %s", param.Key, r.Command, err)
+		}
+		wire.params = append(wire.params, transWireParam{key: string(key), value: value, blob: param.Blob})
+	}
+	return wire, nil
+}
+
+// response returns the recorded response as it was on the wire
+func (r TransRecord) response() ([]byte, error) {
+	if r.Encoding == RecordEncodingBase64 {
+		return base64.StdEncoding.DecodeString(r.Response)
+	}
+	return []byte(r.Response), nil
+}
+
+// ReadTransRecords reads the records of a recording, one json per line
+func ReadTransRecords(reader io.Reader) ([]TransRecord, error) {
+	var records []TransRecord
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var record TransRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// transRecorder appends the commands executed on trans and their
+// responses to a recording, masking the sensitive params
+type transRecorder struct {
+	mutex  sync.Mutex
+	file   io.WriteCloser
+	redact map[string]bool
+	logger loggers.Logger
+}
+
+// newTransRecorder opens the recording at path, appending to it if it
+// already exists. Only the owner can read it, as it holds production
+// traffic
+func newTransRecorder(path string, redact map[string]bool, logger loggers.Logger) (*transRecorder, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600) // nolint: gosec
+	if err != nil {
+		return nil, err
+	}
+	return &transRecorder{file: file, redact: redact, logger: logger}, nil
+}
+
+// record appends the exchange to the recording, with the values of the
+// redacted keys masked. Failing to do it doesn't fail the command, it's
+// only logged
+func (r *transRecorder) record(request []byte, response []byte) {
+	record, err := newTransRecord(maskWire(request, r.redact, false), maskWire(response, r.redact, false))
+	if err == nil {
+		var line []byte
+		if line, err = json.Marshal(record); err == nil {
+			r.mutex.Lock()
+			_, err = r.file.Write(append(line, '\n'))
+			r.mutex.Unlock()
+		}
+	}
+	if err != nil {
+		r.logger.Error("Error recording trans command: %s", err)
+	}
+}
+
+// Close closes the recording
+func (r *transRecorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.file.Close()
+}
+
+// transWire is a command as it's written to trans
+type transWire struct {
+	command string
+	params  []transWireParam
+	commit  bool
+}
+
+// transWireParam is a param of a command as it's written to trans
+type transWireParam struct {
+	key   string
+	value []byte
+	blob  bool
+}
+
+// parseTransWire parses the text of a command written to trans, up to the
+// end line
+func parseTransWire(wire []byte) (transWire, error) {
+	var parsed transWire
+	for n := 0; n < len(wire); {
+		line := wire[n:]
+		i := bytes.IndexByte(line, '\n')
+		if i == -1 {
+			return parsed, fmt.Errorf("trans-proxy: unterminated line %q", line)
+		}
+		line = line[:i]
+		n += i + 1
+		if blobLen, ok := wireBlobLen(line); ok {
+			if n+blobLen >= len(wire) {
+				return parsed, fmt.Errorf("trans-proxy: truncated blob %q", line)
+			}
+			key := line[bytes.IndexByte(line[5:], ':')+6:]
+			parsed.params = append(parsed.params, transWireParam{
+				key: string(key), value: wire[n : n+blobLen], blob: true,
+			})
+			n += blobLen + 1
+			continue
+		}
+		if string(line) == strings.TrimSuffix(EndMessage, "\n") {
+			break
+		}
+		parts := bytes.SplitN(line, []byte(":"), 2)
+		if len(parts) != 2 {
+			return parsed, fmt.Errorf("trans-proxy: invalid line %q", line)
+		}
+		switch key := string(parts[0]); {
+		case key == "cmd" && parsed.command == "":
+			parsed.command = string(parts[1])
+		case key == "commit":
+			parsed.commit = string(parts[1]) == "1"
+		default:
+			parsed.params = append(parsed.params, transWireParam{key: key, value: parts[1]})
+		}
+	}
+	return parsed, nil
+}
+
+// mask replaces the values of the params with the given keys by
+// redactedValue, as they are in the recordings
+func (w transWire) mask(redact map[string]bool) transWire {
+	params := make([]transWireParam, len(w.params))
+	for i, param := range w.params {
+		if redact[strings.ToLower(param.key)] {
+			param.value = []byte(redactedValue)
+		}
+		params[i] = param
+	}
+	w.params = params
+	return w
+}
+
+// validUTF8 tells if the keys and the values that are not blobs are valid
+// UTF-8
+func (w transWire) validUTF8() bool {
+	for _, param := range w.params {
+		if !utf8.ValidString(param.key) || !param.blob && !utf8.Valid(param.value) {
+			return false
+		}
+	}
+	return true
+}
+
+// match returns the key the command is matched with on replay. The params
+// are sorted, as they may be written in any order
+func (w transWire) match() string {
+	params := append([]transWireParam(nil), w.params...)
+	sort.SliceStable(params, func(i, j int) bool {
+		if params[i].key != params[j].key {
+			return params[i].key < params[j].key
+		}
+		return bytes.Compare(params[i].value, params[j].value) < 0
+	})
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s\n%t\n", w.command, w.commit)
+	for _, param := range params {
+		fmt.Fprintf(&buf, "%s:%t:%d:", param.key, param.blob, len(param.value))
+		buf.Write(param.value)
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
@@ -0,0 +1,242 @@
+package infrastructure
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/domain"
+)
+
+func TestParseTransWire(t *testing.T) {
+	wire, err := parseTransWire([]byte("cmd:newad\nsubject:a:b\nblob:3:image\na\nb\ncommit:0\nend\n"))
+	assert.NoError(t, err)
+	expected := transWire{
+		command: "newad",
+		params: []transWireParam{
+			{key: "subject", value: []byte("a:b")},
+			{key: "image", value: []byte("a\nb"), blob: true},
+		},
+	}
+	assert.Equal(t, expected, wire)
+
+	_, err = parseTransWire([]byte("cmd:newad\nblob:10:image\nabc\nend\n"))
+	assert.Error(t, err)
+	_, err = parseTransWire([]byte("cmd:newad\nsubject"))
+	assert.Error(t, err)
+}
+
+func TestReadTransRecords(t *testing.T) {
+	records, err := ReadTransRecords(strings.NewReader(
+		`{"command":"get_ad","params":[{"key":"ad_id","value":"1"}],"response":"status:TRANS_OK\n"}` + "\n\n" +
+			`{"command":"transinfo","params":[],"response":"status:TRANS_OK\n"}` + "\n",
+	))
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+	assert.Equal(t, []TransRecordParam{{Key: "ad_id", Value: "1"}}, records[0].Params)
+
+	_, err = ReadTransRecords(strings.NewReader("{}\nnot json\n"))
+	assert.EqualError(t, err, "line 2: invalid character 'o' in literal null (expecting 'u')")
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trans-record")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	recording := filepath.Join(dir, "recording.jsonl")
+
+	server := NewMockTransServer()
+	defer server.Close()
+	server.SetHandler(func(input []byte) []byte {
+		if strings.Contains(string(input), "ad_id:2") {
+			return []byte("status:TRANS_OK\nsubject:se\xf1or\n")
+		}
+		return []byte("status:TRANS_OK\nsubject:car\n")
+	})
+	conf := TransConf{
+		Host:            server.Address,
+		Timeout:         15,
+		Charset:         CharsetISO88591,
+		AllowedCommands: "get_ad|add_image",
+		RecordFile:      recording,
+	}
+	logger := MockLoggerInfrastructure{}
+	commands := []domain.TransCommand{
+		{Command: "get_ad", Params: []domain.TransParams{{Key: "ad_id", Value: "1"}}},
+		{Command: "get_ad", Params: []domain.TransParams{{Key: "ad_id", Value: "2"}}},
+		{Command: "add_image", Params: []domain.TransParams{{Key: "image", Value: "ZWRnYXI=", Blob: true}}, DryRun: true},
+	}
+	var recorded []domain.TransFields
+	transFactory := NewTextProtocolTransFactory(conf, &logger)
+	for _, command := range commands {
+		reply, err := transFactory.MakeTransHandler().SendCommand(context.Background(), command)
+		assert.NoError(t, err)
+		recorded = append(recorded, reply.Fields)
+	}
+	assert.NoError(t, transFactory.Close())
+
+	file, err := os.Open(recording)
+	assert.NoError(t, err)
+	defer file.Close() // nolint: errcheck
+	records, err := ReadTransRecords(file)
+	assert.NoError(t, err)
+	assert.Len(t, records, 3)
+	assert.Equal(t, "get_ad", records[0].Command)
+	assert.Equal(t, []TransRecordParam{{Key: "ad_id", Value: "1"}}, records[0].Params)
+	assert.Equal(t, "status:TRANS_OK\nsubject:car\n", records[0].Response)
+	// the response in iso-8859-1 can't be written as it is
+	assert.Equal(t, RecordEncodingBase64, records[1].Encoding)
+	assert.Equal(t, []TransRecordParam{{Key: "image", Value: "ZWRnYXI=", Blob: true}}, records[2].Params)
+	assert.True(t, records[2].DryRun)
+
+	// the replay answers as the recorded trans did
+	replay := NewMockTransServer()
+	defer replay.Close()
+	assert.NoError(t, replay.ReplayFile(recording))
+	conf.Host = replay.Address
+	conf.RecordFile = ""
+	transFactory = NewTextProtocolTransFactory(conf, &logger)
+	defer transFactory.Close()
+	for i, command := range commands {
+		reply, err := transFactory.MakeTransHandler().SendCommand(context.Background(), command)
+		assert.NoError(t, err)
+		assert.Equal(t, recorded[i], reply.Fields)
+	}
+	// a command is matched on its params and commit too
+	reply, err := transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{
+		Command: "get_ad", Params: []domain.TransParams{{Key: "ad_id", Value: "1"}}, DryRun: true,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, domain.TransFields{
+		{Key: "status", Value: "TRANS_ERROR"},
+		{Key: "error", Value: "no recorded response for get_ad"},
+	}, reply.Fields)
+	logger.AssertExpectations(t)
+}
+
+func TestRecordAndReplaySeveralParams(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trans-record")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	recording := filepath.Join(dir, "recording.jsonl")
+
+	server := NewMockTransServer()
+	defer server.Close()
+	server.SetHandler(func(input []byte) []byte {
+		return []byte("status:TRANS_OK\nlist_id:" + strings.Split(string(input), "\n")[1] + "\n")
+	})
+	conf := TransConf{
+		Host:            server.Address,
+		Timeout:         15,
+		AllowedCommands: "newad",
+		RecordFile:      recording,
+	}
+	logger := MockLoggerInfrastructure{}
+	params := []domain.TransParams{
+		{Key: "subject", Value: "car"},
+		{Key: "category", Value: "2020"},
+		{Key: "region", Value: "15"},
+		{Key: "image", Value: "YQ==", Blob: true},
+		{Key: "image", Value: "Yg==", Blob: true},
+	}
+	transFactory := NewTextProtocolTransFactory(conf, &logger)
+	recorded, err := transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{
+		Command: "newad", Params: params,
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, transFactory.Close())
+
+	replay := NewMockTransServer()
+	defer replay.Close()
+	assert.NoError(t, replay.ReplayFile(recording))
+	conf.Host = replay.Address
+	conf.RecordFile = ""
+	transFactory = NewTextProtocolTransFactory(conf, &logger)
+	defer transFactory.Close()
+	// the params come from a json object, so they may be sent in any order
+	reordered := []domain.TransParams{params[4], params[2], params[0], params[3], params[1]}
+	reply, err := transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{
+		Command: "newad", Params: reordered,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, recorded.Fields, reply.Fields)
+	logger.AssertExpectations(t)
+}
+
+func TestRecordRedacted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trans-record")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	recording := filepath.Join(dir, "recording.jsonl")
+
+	server := NewMockTransServer()
+	defer server.Close()
+	server.SetHandler(func(input []byte) []byte {
+		return []byte("status:TRANS_OK\ntoken:abc123\n")
+	})
+	conf := TransConf{
+		Host:            server.Address,
+		Timeout:         15,
+		AllowedCommands: "login",
+		RecordFile:      recording,
+		Capture:         TransCaptureConf{Redact: "passwd|token"},
+	}
+	logger := MockLoggerInfrastructure{}
+	transFactory := NewTextProtocolTransFactory(conf, &logger)
+	login := func(passwd string) domain.TransCommand {
+		return domain.TransCommand{Command: "login", Params: []domain.TransParams{
+			{Key: "email", Value: "a@b.cl"},
+			{Key: "passwd", Value: passwd},
+		}}
+	}
+	_, err = transFactory.MakeTransHandler().SendCommand(context.Background(), login("secret"))
+	assert.NoError(t, err)
+	assert.NoError(t, transFactory.Close())
+
+	info, err := os.Stat(recording)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	file, err := os.Open(recording)
+	assert.NoError(t, err)
+	defer file.Close() // nolint: errcheck
+	records, err := ReadTransRecords(file)
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+	assert.Equal(t, []TransRecordParam{{Key: "email", Value: "a@b.cl"}, {Key: "passwd", Value: redactedValue}}, records[0].Params)
+	assert.Equal(t, "status:TRANS_OK\ntoken:[REDACTED]\n", records[0].Response)
+
+	// the masked params match any value on replay
+	replay := NewMockTransServer()
+	defer replay.Close()
+	assert.NoError(t, replay.Replay(records))
+	conf.Host = replay.Address
+	conf.RecordFile = ""
+	transFactory = NewTextProtocolTransFactory(conf, &logger)
+	defer transFactory.Close()
+	reply, err := transFactory.MakeTransHandler().SendCommand(context.Background(), login("other"))
+	assert.NoError(t, err)
+	assert.Equal(t, domain.TransFields{{Key: "status", Value: "TRANS_OK"}, {Key: "token", Value: redactedValue}}, reply.Fields)
+	logger.AssertExpectations(t)
+}
+
+func TestReplayInOrder(t *testing.T) {
+	server := NewMockTransServer()
+	defer server.Close()
+	assert.NoError(t, server.Replay([]TransRecord{
+		{Command: "transinfo", Response: "status:TRANS_OK\nn:1\n"},
+		{Command: "transinfo", Response: "status:TRANS_OK\nn:2\n"},
+	}))
+	conf := TransConf{Host: server.Address, Timeout: 15, AllowedCommands: "transinfo"}
+	transFactory := NewTextProtocolTransFactory(conf, &MockLoggerInfrastructure{})
+	defer transFactory.Close()
+	// the last response is repeated once they run out
+	for _, n := range []string{"1", "2", "2"} {
+		reply, err := transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: "transinfo"})
+		assert.NoError(t, err)
+		assert.Equal(t, domain.TransFields{{Key: "status", Value: "TRANS_OK"}, {Key: "n", Value: n}}, reply.Fields)
+	}
+}
@@ -1,30 +1,57 @@
 package infrastructure
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
+	"io"
+	"math"
 	"strconv"
+
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/domain"
 )
 
+// maxBlobLength the longest blob length a response may announce, even if
+// the size of the blobs is not limited
+const maxBlobLength = math.MaxInt32
+
 // TransResponse a Trans response in bytes.
 type TransResponse []byte
 
 // Map returns a new map from a response.
 func (r TransResponse) Map() (map[string]string, error) {
 	m := make(map[string]string)
-	err := r.apply(func(key, value string) {
-		m[key] = value
+	err := r.apply(func(key string, value []byte, blob bool) {
+		m[key] = string(value)
 	})
 	return m, err
 }
 
-// apply applies the given function on all key-value pairs of the response.
-func (r TransResponse) apply(f func(key, value string)) error {
+// Fields returns the key-value pairs of a response in order, keeping the
+// repeated keys. Blobs are returned as a copy of their raw bytes.
+func (r TransResponse) Fields() (domain.TransFields, error) {
+	var fields domain.TransFields
+	err := r.apply(func(key string, value []byte, blob bool) {
+		field := domain.TransField{Key: key}
+		if blob {
+			field.Blob = append(make([]byte, 0, len(value)), value...)
+		} else {
+			field.Value = string(value)
+		}
+		fields = append(fields, field)
+	})
+	return fields, err
+}
+
+// apply applies the given function on all key-value pairs of the response,
+// telling if the value is a blob. value is only valid during the call.
+func (r TransResponse) apply(f func(key string, value []byte, blob bool)) error {
 	n := 0
 	for n < len(r) {
-		blobLen := 0
+		blob, blobLen := false, 0
 		// Check if the value is a blob.
 		if len(r) > n+5 && bytes.Equal(r[n:n+5], []byte("blob:")) {
+			blob = true
 			i := bytes.IndexByte(r[n+5:], ':')
 			if i == -1 {
 				return fmt.Errorf("trans-proxy: invalid blob %q", r[n:])
@@ -32,15 +59,15 @@ func (r TransResponse) apply(f func(key, value string)) error {
 			n += 5
 			var err error
 			blobLen, err = strconv.Atoi(string(r[n : n+i]))
-			if err != nil {
-				return fmt.Errorf("trans-proxy: cannot parse blob length: %v", err)
+			if err != nil || blobLen < 0 || blobLen > maxBlobLength {
+				return fmt.Errorf("trans-proxy: cannot parse blob length: %q", r[n:n+i])
 			}
 			n += i + 1
 		}
 
 		// if current field is blob field - key terminator is newline, not ':'
 		var i int
-		if blobLen > 0 {
+		if blob {
 			i = bytes.IndexByte(r[n:], '\n')
 		} else {
 			i = bytes.IndexByte(r[n:], ':')
@@ -53,10 +80,13 @@ func (r TransResponse) apply(f func(key, value string)) error {
 		key := string(r[n : n+i])
 		n += i + 1
 
+		if blobLen > len(r)-n {
+			return fmt.Errorf("trans-proxy: blob is too short: %q", r[n:])
+		}
 		vl := n + blobLen
 		// if current field is not blob field - read until newline, if there is a glob field,
 		// we already have value length in blobLen variable
-		if blobLen <= 0 {
+		if !blob {
 			i = bytes.IndexByte(r[n:], '\n')
 			if i == -1 {
 				return fmt.Errorf("trans-proxy: newline is missing: %q", r[n:])
@@ -64,8 +94,70 @@ func (r TransResponse) apply(f func(key, value string)) error {
 			vl += i
 		}
 
-		f(key, string(r[n:vl]))
+		if vl > len(r) {
+			return fmt.Errorf("trans-proxy: blob is too short: %q", r[n:])
+		}
+		f(key, r[n:vl], blob)
 		n = vl + 1
 	}
 	return nil
 }
+
+// readTransResponse reads a trans response from reader up to the end
+// message, which is not included. Blob sections are read by their length,
+// so their content can't be taken as the end of the response. It tells if
+// the end message was found: if the server closed the connection before
+// sending it, the response read so far is returned anyway. Reading stops
+// with a domain.ResponseLimitError as soon as the response exceeds limits
+func readTransResponse(reader *bufio.Reader, limits TransLimitsConf) (TransResponse, bool, error) {
+	var response []byte
+	limiter := transResponseLimiter{limits: limits}
+	for {
+		line, err := limiter.readLine(reader)
+		if err == io.EOF {
+			if len(line) > 0 {
+				if err := limiter.line(len(line)); err != nil {
+					return nil, false, err
+				}
+			}
+			return append(response, line...), false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		if bytes.Equal(line, []byte(EndMessage)) {
+			return response, true, nil
+		}
+		if err := limiter.line(len(line)); err != nil {
+			return nil, false, err
+		}
+		response = append(response, line...)
+		if !bytes.HasPrefix(line, []byte("blob:")) {
+			continue
+		}
+		i := bytes.IndexByte(line[5:], ':')
+		if i == -1 {
+			return nil, false, fmt.Errorf("trans-proxy: invalid blob %q", line)
+		}
+		blobLen, err := strconv.Atoi(string(line[5 : 5+i]))
+		if err != nil || blobLen < 0 {
+			return nil, false, fmt.Errorf("trans-proxy: cannot parse blob length: %q", line)
+		}
+		if blobLen > maxBlobLength {
+			return nil, false, fmt.Errorf("trans-proxy: blob length too large: %q", line)
+		}
+		if err := limiter.blob(blobLen); err != nil {
+			return nil, false, err
+		}
+		// the blob content is followed by a newline. It's copied as it
+		// arrives, the announced length is never allocated up front
+		buf := bytes.NewBuffer(response)
+		if _, err := io.CopyN(buf, reader, int64(blobLen)+1); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, false, err
+		}
+		response = buf.Bytes()
+	}
+}
@@ -0,0 +1,66 @@
+package infrastructure
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/domain"
+)
+
+const (
+	// FailureConnect class of the failures to get a connection to trans,
+	// including backends with their breaker open
+	FailureConnect = "connect"
+	// FailureBusy class of the failures due to trans being busy
+	FailureBusy = "busy"
+	// FailureTimeout class of the failures writing the command or waiting
+	// for the response, as trans may have got it
+	FailureTimeout = "timeout"
+)
+
+// failureClass returns the retry class of err, empty if it's not retryable.
+// Timeouts before the command is written are connect failures
+func failureClass(err error) string {
+	var timeout domain.TimeoutError
+	if errors.As(err, &timeout) {
+		if timeout.Phase == PhaseConnect || timeout.Phase == PhaseGreeting {
+			return FailureConnect
+		}
+		return FailureTimeout
+	}
+	switch err {
+	case errConnClosed:
+		return FailureTimeout
+	case errConnect, errBreakerOpen:
+		return FailureConnect
+	case domain.ErrTransBusy:
+		return FailureBusy
+	}
+	return ""
+}
+
+// retries tells if the failures of the given class are retried
+func (r TransRetryConf) retries(class string) bool {
+	for _, on := range strings.Split(r.On, ",") {
+		if strings.TrimSpace(on) == class {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the wait time before the given retry, starting at 1.
+// It grows exponentially up to MaxBackoff, and is moved randomly by Jitter
+func (r TransRetryConf) backoff(retry int) time.Duration {
+	backoff := float64(r.Backoff) * math.Pow(2, float64(retry-1))
+	if r.MaxBackoff > 0 && backoff > float64(r.MaxBackoff) {
+		backoff = float64(r.MaxBackoff)
+	}
+	if r.Jitter > 0 {
+		backoff *= 1 + r.Jitter*(2*rand.Float64()-1) // nolint: gosec
+	}
+	return time.Duration(backoff)
+}
@@ -0,0 +1,54 @@
+package infrastructure
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/domain"
+)
+
+func TestFailureClass(t *testing.T) {
+	assert.Equal(t, FailureConnect, failureClass(errConnect))
+	assert.Equal(t, FailureConnect, failureClass(errBreakerOpen))
+	assert.Equal(t, FailureBusy, failureClass(domain.ErrTransBusy))
+	assert.Equal(t, FailureTimeout, failureClass(domain.TimeoutError{Phase: PhaseRead}))
+	assert.Equal(t, FailureTimeout, failureClass(domain.TimeoutError{Phase: PhaseWrite}))
+	assert.Equal(t, FailureTimeout, failureClass(errConnClosed))
+	assert.Equal(t, FailureConnect, failureClass(domain.TimeoutError{Phase: PhaseConnect}))
+	assert.Equal(t, FailureConnect, failureClass(domain.TimeoutError{Phase: PhaseGreeting}))
+	assert.Equal(t, "", failureClass(errors.New("error parsing response")))
+	assert.Equal(t, "", failureClass(nil))
+}
+
+func TestRetryBackoff(t *testing.T) {
+	retry := TransRetryConf{
+		Backoff:    Duration(100 * time.Millisecond),
+		MaxBackoff: Duration(time.Second),
+	}
+	assert.Equal(t, 100*time.Millisecond, retry.backoff(1))
+	assert.Equal(t, 200*time.Millisecond, retry.backoff(2))
+	assert.Equal(t, 400*time.Millisecond, retry.backoff(3))
+	assert.Equal(t, time.Second, retry.backoff(5))
+
+	retry.Jitter = 0.5
+	for i := 0; i < 10; i++ {
+		backoff := retry.backoff(2)
+		assert.True(t, backoff >= 100*time.Millisecond && backoff <= 300*time.Millisecond)
+	}
+}
+
+func TestCommandRetries(t *testing.T) {
+	retry := &TransRetryConf{On: "connect, timeout"}
+	write := transCommand{conf: TransCommandConf{Retry: retry}}
+	read := transCommand{conf: TransCommandConf{Retry: retry, ReadOnly: true}}
+	idempotent := transCommand{conf: TransCommandConf{Retry: retry, Idempotent: true}}
+
+	assert.True(t, write.retries(FailureConnect))
+	assert.False(t, write.retries(FailureBusy))
+	assert.False(t, write.retries(FailureTimeout))
+	assert.True(t, read.retries(FailureTimeout))
+	assert.True(t, idempotent.retries(FailureTimeout))
+	assert.False(t, read.retries(""))
+}
@@ -0,0 +1,51 @@
+package infrastructure
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// tlsVersions the TLS versions TransTLSConf.MinVersion accepts
+var tlsVersions = map[string]uint16{ // nolint: gochecknoglobals
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Config returns the TLS configuration of the connections to trans, nil
+// if TLS is not enabled. It fails if a file can't be loaded or the
+// version is unknown
+func (c TransTLSConf) Config() (*tls.Config, error) {
+	if !c.Enabled {
+		return nil, nil
+	}
+	minVersion, ok := tlsVersions[c.MinVersion]
+	if !ok {
+		return nil, fmt.Errorf("unknown TLS version %q", c.MinVersion)
+	}
+	config := &tls.Config{
+		ServerName: c.ServerName,
+		MinVersion: minVersion,
+	}
+	if c.CAFile != "" {
+		pem, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.CAFile)
+		}
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
@@ -0,0 +1,227 @@
+package infrastructure
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/domain"
+)
+
+// testCertificate is a certificate and its key, signed by a test CA
+type testCertificate struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCertificate creates a certificate for the template, signed by
+// parent, or self signed if parent is nil
+func newTestCertificate(t *testing.T, template *x509.Certificate, parent *testCertificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return &testCertificate{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// tlsKeyPair returns the certificate to be used by a tls.Config
+func (c *testCertificate) tlsKeyPair(t *testing.T) tls.Certificate {
+	pair, err := tls.X509KeyPair(c.certPEM, c.keyPEM)
+	require.NoError(t, err)
+	return pair
+}
+
+// newTestPKI creates a CA, a certificate for trans and one for the proxy,
+// writing the CA and the proxy ones to dir. It returns the TLS
+// configuration of a trans that requires the proxy certificate
+func newTestPKI(t *testing.T, dir string) (*tls.Config, TransTLSConf) {
+	ca := newTestCertificate(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "trans test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	server := newTestCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "trans"},
+		DNSNames:     []string{"trans.local"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+	client := newTestCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "trans-proxy"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+
+	conf := TransTLSConf{
+		Enabled:    true,
+		CAFile:     filepath.Join(dir, "ca.pem"),
+		CertFile:   filepath.Join(dir, "client.pem"),
+		KeyFile:    filepath.Join(dir, "client-key.pem"),
+		MinVersion: "1.2",
+	}
+	require.NoError(t, ioutil.WriteFile(conf.CAFile, ca.certPEM, 0600))
+	require.NoError(t, ioutil.WriteFile(conf.CertFile, client.certPEM, 0600))
+	require.NoError(t, ioutil.WriteFile(conf.KeyFile, client.keyPEM, 0600))
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	return &tls.Config{
+		Certificates: []tls.Certificate{server.tlsKeyPair(t)},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}, conf
+}
+
+func TestTransTLSConfConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trans-tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	_, conf := newTestPKI(t, dir)
+
+	config, err := conf.Config()
+	assert.NoError(t, err)
+	assert.Len(t, config.Certificates, 1)
+	assert.NotNil(t, config.RootCAs)
+	assert.Equal(t, uint16(tls.VersionTLS12), config.MinVersion)
+
+	disabled, err := TransTLSConf{}.Config()
+	assert.NoError(t, err)
+	assert.Nil(t, disabled)
+
+	invalid := conf
+	invalid.MinVersion = "2.0"
+	_, err = invalid.Config()
+	assert.EqualError(t, err, `unknown TLS version "2.0"`)
+
+	invalid = conf
+	invalid.CAFile = invalid.KeyFile
+	_, err = invalid.Config()
+	assert.EqualError(t, err, "no certificates found in "+conf.KeyFile)
+
+	invalid = conf
+	invalid.KeyFile = ""
+	_, err = invalid.Config()
+	assert.Error(t, err)
+}
+
+func TestSendCommandTLSClientContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trans-tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	_, tlsConf := newTestPKI(t, dir)
+
+	// a server that accepts connections but never answers the handshake
+	silent := newLocalListener()
+	defer silent.Close() // nolint: errcheck
+	go func() {
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close() // nolint: errcheck
+		}
+	}()
+	conf := TransConf{
+		Host:            silent.Addr().String(),
+		Timeout:         15,
+		Timeouts:        TransTimeoutsConf{Connect: Duration(3 * time.Second)},
+		AllowedCommands: "transinfo",
+		TLS:             tlsConf,
+	}
+	logger := MockLoggerInfrastructure{}
+	logger.On("Error")
+	transFactory := NewTextProtocolTransFactory(conf, &logger)
+	defer transFactory.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = transFactory.MakeTransHandler().SendCommand(ctx, domain.TransCommand{Command: "transinfo"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.True(t, time.Since(start) < time.Second)
+	logger.AssertExpectations(t)
+}
+
+func TestSendCommandTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trans-tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	serverConf, tlsConf := newTestPKI(t, dir)
+
+	server := NewMockTransTLSServer(serverConf)
+	defer server.Close()
+	server.SetHandler(func(input []byte) []byte {
+		assert.Equal(t, "cmd:transinfo\ncommit:1\nend\n", string(input))
+		return []byte("status:TRANS_OK\n")
+	})
+	conf := TransConf{
+		Host:            server.Address,
+		Timeout:         15,
+		AllowedCommands: "transinfo",
+		TLS:             tlsConf,
+	}
+	logger := MockLoggerInfrastructure{}
+	transFactory := NewTextProtocolTransFactory(conf, &logger)
+	defer transFactory.Close()
+	reply, err := transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: "transinfo"})
+	assert.NoError(t, err)
+	assert.Equal(t, domain.TransFields{{Key: "status", Value: "TRANS_OK"}}, reply.Fields)
+
+	// the certificate of trans must have the server name
+	conf.TLS.ServerName = "trans.local"
+	named := NewTextProtocolTransFactory(conf, &logger)
+	defer named.Close()
+	_, err = named.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: "transinfo"})
+	assert.NoError(t, err)
+
+	logger.On("Error")
+	conf.TLS.ServerName = "other.local"
+	mismatch := NewTextProtocolTransFactory(conf, &logger)
+	defer mismatch.Close()
+	_, err = mismatch.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: "transinfo"})
+	assert.Equal(t, errConnect, err)
+
+	// trans requires the client certificate
+	conf.TLS.ServerName = ""
+	conf.TLS.CertFile, conf.TLS.KeyFile = "", ""
+	anonymous := NewTextProtocolTransFactory(conf, &logger)
+	defer anonymous.Close()
+	_, err = anonymous.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: "transinfo"})
+	assert.Equal(t, errConnect, err)
+	logger.AssertExpectations(t)
+}
@@ -0,0 +1,63 @@
+package infrastructure
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/domain"
+)
+
+const (
+	// PhaseConnect phase of dialing a connection to trans
+	PhaseConnect = "connect"
+	// PhaseGreeting phase of waiting for the greeting of a new connection
+	PhaseGreeting = "greeting"
+	// PhaseWrite phase of writing the command
+	PhaseWrite = "write"
+	// PhaseRead phase of reading the response
+	PhaseRead = "read"
+)
+
+// transPhases every phase of a command, in the order they happen
+var transPhases = []string{PhaseConnect, PhaseGreeting, PhaseWrite, PhaseRead} // nolint: gochecknoglobals
+
+// phaseTimeout returns a domain.TimeoutError for the given phase if err is
+// a network timeout, err otherwise
+func phaseTimeout(err error, phase string, timeout time.Duration) error {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return domain.TimeoutError{Phase: phase, Timeout: timeout}
+	}
+	return err
+}
+
+// deadline returns the time a phase that starts now and may take timeout
+// ends, or the zero time if it's not limited
+func deadline(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
+
+// ctxDeadline returns the deadline of a phase that may take timeout, moved
+// to the deadline of ctx if it comes first
+func ctxDeadline(ctx context.Context, timeout time.Duration) time.Time {
+	until := deadline(timeout)
+	if ctxUntil, ok := ctx.Deadline(); ok && (until.IsZero() || ctxUntil.Before(until)) {
+		return ctxUntil
+	}
+	return until
+}
+
+// contextErr returns the error of ctx if it's done or its deadline passed,
+// even if it was not told yet, nil otherwise
+func contextErr(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if until, ok := ctx.Deadline(); ok && !time.Now().Before(until) {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
@@ -1,9 +1,18 @@
 package infrastructure
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -39,13 +48,13 @@ func TestSendCommandInvalidCommand(t *testing.T) {
 		Host:            host,
 		Port:            port,
 		Timeout:         15,
-		RetryAfter:      5,
 		AllowedCommands: test,
 	}
 	logger := MockLoggerInfrastructure{}
 	logger.On("Error")
-	expectedResponse := map[string]string{}
-	expectedResponse["error"] = "Invalid Command. Valid commands: [test]"
+	expectedResponse := domain.TransFields{
+		{Key: "error", Value: "invalid command - commands allowed: [test]"},
+	}
 	cmd := "transinfo"
 	params := []domain.TransParams{
 		{
@@ -57,9 +66,9 @@ func TestSendCommandInvalidCommand(t *testing.T) {
 	transFactory := NewTextProtocolTransFactory(conf, &logger)
 	transHandler := transFactory.MakeTransHandler()
 
-	resp, err := transHandler.SendCommand(cmd, params)
+	resp, err := transHandler.SendCommand(context.Background(), domain.TransCommand{Command: cmd, Params: params})
 	assert.Error(t, err)
-	assert.Equal(t, expectedResponse, resp)
+	assert.Equal(t, expectedResponse, resp.Fields)
 	logger.AssertExpectations(t)
 }
 
@@ -84,12 +93,11 @@ func TestSendCommandTimeout(t *testing.T) {
 		Host:            host,
 		Port:            port,
 		Timeout:         1,
-		RetryAfter:      5,
 		AllowedCommands: test,
 	}
 	logger := MockLoggerInfrastructure{}
 	logger.On("Error")
-	var expectedResponse map[string]string
+	var expectedResponse domain.TransFields
 	cmd := test
 	params := []domain.TransParams{
 		{
@@ -100,9 +108,9 @@ func TestSendCommandTimeout(t *testing.T) {
 
 	transFactory := NewTextProtocolTransFactory(conf, &logger)
 	transHandler := transFactory.MakeTransHandler()
-	resp, err := transHandler.SendCommand(cmd, params)
+	resp, err := transHandler.SendCommand(context.Background(), domain.TransCommand{Command: cmd, Params: params})
 	assert.Error(t, err)
-	assert.Equal(t, expectedResponse, resp)
+	assert.Equal(t, expectedResponse, resp.Fields)
 	logger.AssertExpectations(t)
 }
 
@@ -118,12 +126,11 @@ func TestSendCommandBusyServer(t *testing.T) {
 		Host:            host,
 		Port:            port,
 		Timeout:         15,
-		RetryAfter:      5,
 		AllowedCommands: test,
 	}
 	logger := MockLoggerInfrastructure{}
 	logger.On("Error")
-	var expectedResponse map[string]string
+	var expectedResponse domain.TransFields
 	cmd := test
 	params := []domain.TransParams{
 		{
@@ -135,9 +142,9 @@ func TestSendCommandBusyServer(t *testing.T) {
 	transFactory := NewTextProtocolTransFactory(conf, &logger)
 	transHandler := transFactory.MakeTransHandler()
 
-	resp, err := transHandler.SendCommand(cmd, params)
+	resp, err := transHandler.SendCommand(context.Background(), domain.TransCommand{Command: cmd, Params: params})
 	assert.Error(t, err)
-	assert.Equal(t, expectedResponse, resp)
+	assert.Equal(t, expectedResponse, resp.Fields)
 	logger.AssertExpectations(t)
 }
 
@@ -160,12 +167,10 @@ func TestSendCommandOK(t *testing.T) {
 		Host:            host,
 		Port:            port,
 		Timeout:         15,
-		RetryAfter:      5,
 		AllowedCommands: test,
 	}
 	logger := MockLoggerInfrastructure{}
-	expectedResponse := make(map[string]string)
-	expectedResponse["status"] = usecases.TransOK
+	expectedResponse := domain.TransFields{{Key: "status", Value: usecases.TransOK}}
 	cmd := test
 	params := []domain.TransParams{
 		{
@@ -177,9 +182,9 @@ func TestSendCommandOK(t *testing.T) {
 	transFactory := NewTextProtocolTransFactory(conf, &logger)
 	transHandler := transFactory.MakeTransHandler()
 
-	resp, err := transHandler.SendCommand(cmd, params)
+	resp, err := transHandler.SendCommand(context.Background(), domain.TransCommand{Command: cmd, Params: params})
 	assert.NoError(t, err)
-	assert.Equal(t, expectedResponse, resp)
+	assert.Equal(t, expectedResponse, resp.Fields)
 	logger.AssertExpectations(t)
 }
 
@@ -202,12 +207,10 @@ func TestSendCommandBlobOK(t *testing.T) {
 		Host:            host,
 		Port:            port,
 		Timeout:         15,
-		RetryAfter:      5,
 		AllowedCommands: test,
 	}
 	logger := MockLoggerInfrastructure{}
-	expectedResponse := make(map[string]string)
-	expectedResponse["status"] = usecases.TransOK
+	expectedResponse := domain.TransFields{{Key: "status", Value: usecases.TransOK}}
 	cmd := test
 	params := []domain.TransParams{
 		{
@@ -220,9 +223,9 @@ func TestSendCommandBlobOK(t *testing.T) {
 	transFactory := NewTextProtocolTransFactory(conf, &logger)
 	transHandler := transFactory.MakeTransHandler()
 
-	resp, err := transHandler.SendCommand(cmd, params)
+	resp, err := transHandler.SendCommand(context.Background(), domain.TransCommand{Command: cmd, Params: params})
 	assert.NoError(t, err)
-	assert.Equal(t, expectedResponse, resp)
+	assert.Equal(t, expectedResponse, resp.Fields)
 	logger.AssertExpectations(t)
 }
 func TestISO8859Input(t *testing.T) {
@@ -241,10 +244,10 @@ func TestISO8859Input(t *testing.T) {
 		Host:            host,
 		Port:            port,
 		Timeout:         15,
-		RetryAfter:      5,
 		AllowedCommands: test,
 	}
 	logger := MockLoggerInfrastructure{}
+	logger.On("Error").Once()
 	cmd := test
 	params := []domain.TransParams{
 		{
@@ -256,8 +259,859 @@ func TestISO8859Input(t *testing.T) {
 	transFactory := NewTextProtocolTransFactory(conf, &logger)
 	transHandler := transFactory.MakeTransHandler()
 
-	resp, err := transHandler.SendCommand(cmd, params)
+	// the value is not valid UTF-8, so it can't be written in any charset
+	resp, err := transHandler.SendCommand(context.Background(), domain.TransCommand{Command: cmd, Params: params})
+	expectedErr := domain.EncodingErrors{{Key: "param1", Reason: "invalid UTF-8 at byte 2"}}
+	assert.Equal(t, expectedErr, err)
+	assert.Empty(t, resp.Fields)
+	logger.AssertExpectations(t)
+}
+
+func TestSendCommandClosesConnection(t *testing.T) {
+	response := "status:TRANS_OK\n"
+	handlerFunc := func(input []byte) []byte {
+		return []byte(response)
+	}
+	server := NewMockTransServer()
+	defer server.Close()
+	server.SetHandler(handlerFunc)
+
+	addr := strings.Split(server.Address, ":")
+	host := addr[0]
+	port, _ := strconv.Atoi(addr[1])
+	conf := TransConf{
+		Host:            host,
+		Port:            port,
+		Timeout:         15,
+		AllowedCommands: test,
+		Pool: TransPoolConf{
+			MaxOpen: 1,
+		},
+	}
+	logger := MockLoggerInfrastructure{}
+	expectedResponse := domain.TransFields{{Key: "status", Value: usecases.TransOK}}
+
+	transFactory := NewTextProtocolTransFactory(conf, &logger)
+	defer transFactory.Close()
+	for i := 0; i < 3; i++ {
+		resp, err := transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: test})
+		assert.NoError(t, err)
+		assert.Equal(t, expectedResponse, resp.Fields)
+	}
+	// trans closes each connection after its command, so none is reused
+	stats := transFactory.Stats()[0].Pool
+	assert.Equal(t, int64(3), stats.Dials)
+	assert.Equal(t, 0, stats.Idle)
+	assert.Equal(t, 0, stats.Open)
+	logger.AssertExpectations(t)
+}
+
+func TestSendCommandUsesIdleConnection(t *testing.T) {
+	server := NewMockTransServer()
+	defer server.Close()
+	server.SetHandler(func(input []byte) []byte {
+		return []byte("status:TRANS_OK\n")
+	})
+	conf := TransConf{
+		Host:            server.Address,
+		Timeout:         15,
+		AllowedCommands: test,
+		Pool: TransPoolConf{
+			MinIdle: 1,
+			MaxOpen: 2,
+		},
+	}
+	logger := MockLoggerInfrastructure{}
+	transFactory := NewTextProtocolTransFactory(conf, &logger)
+	defer transFactory.Close()
+	assert.Eventually(t, func() bool {
+		return transFactory.Stats()[0].Pool.Idle == 1
+	}, time.Second, 5*time.Millisecond)
+
+	// the command takes the greeted connection kept ready
+	resp, err := transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: test})
+	assert.NoError(t, err)
+	assert.Equal(t, domain.TransFields{{Key: "status", Value: usecases.TransOK}}, resp.Fields)
+	stats := transFactory.Stats()[0].Pool
+	assert.Equal(t, int64(1), stats.Dials)
+	logger.AssertExpectations(t)
+}
+
+func TestSendCommandFailover(t *testing.T) {
+	server := NewMockTransServer()
+	defer server.Close()
+	server.SetHandler(func(input []byte) []byte {
+		return []byte("status:TRANS_OK\n")
+	})
+	// nothing listens on the first backend
+	down := newLocalListener()
+	downAddress := down.Addr().String()
+	down.Close()
+
+	conf := TransConf{
+		Host:            downAddress + "," + server.Address,
+		Timeout:         15,
+		AllowedCommands: test,
+	}
+	logger := MockLoggerInfrastructure{}
+	logger.On("Error")
+
+	transFactory := NewTextProtocolTransFactory(conf, &logger)
+	defer transFactory.Close()
+	for i := 0; i < 2; i++ {
+		resp, err := transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: test})
+		assert.NoError(t, err)
+		assert.Equal(t, domain.TransFields{{Key: "status", Value: usecases.TransOK}}, resp.Fields)
+	}
+	stats := transFactory.Stats()
+	assert.Equal(t, downAddress, stats[0].Address)
+	assert.Equal(t, int64(0), stats[0].Pool.Dials)
+	assert.Equal(t, int64(2), stats[1].Pool.Dials)
+}
+
+func TestHealthCheckEjectsBackend(t *testing.T) {
+	server := NewMockTransServer()
+	defer server.Close()
+	server.SetHandler(func(input []byte) []byte {
+		return []byte("status:TRANS_OK\n")
+	})
+
+	conf := TransConf{
+		Host:            server.Address,
+		Timeout:         15,
+		AllowedCommands: test,
+		HealthCheck: TransHealthCheckConf{
+			Interval:  10 * time.Millisecond,
+			Command:   "transinfo",
+			Threshold: 1,
+		},
+	}
+	logger := MockLoggerInfrastructure{}
+	logger.On("Error")
+	logger.On("Info")
+
+	transFactory := NewTextProtocolTransFactory(conf, &logger)
+	defer transFactory.Close()
+
+	server.SetBusy(true)
+	assert.Eventually(t, func() bool {
+		return !transFactory.Stats()[0].Healthy
+	}, time.Second, 5*time.Millisecond)
+	server.SetBusy(false)
+	assert.Eventually(t, func() bool {
+		return transFactory.Stats()[0].Healthy
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestSendCommandBusyRetry(t *testing.T) {
+	busy := NewMockTransServer()
+	defer busy.Close()
+	busy.SetBusy(true)
+
+	conf := TransConf{
+		Host:            busy.Address,
+		Timeout:         15,
+		AllowedCommands: test,
+		Retry: TransRetryConf{
+			Attempts: 3,
+			Backoff:  Duration(time.Millisecond),
+			On:       FailureBusy,
+		},
+	}
+	logger := MockLoggerInfrastructure{}
+	logger.On("Warn").Twice()
+	logger.On("Error").Once()
+
+	transFactory := NewTextProtocolTransFactory(conf, &logger)
+	defer transFactory.Close()
+	_, err := transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: test})
+	assert.Equal(t, domain.ErrTransBusy, err)
+	assert.Equal(t, int64(3), transFactory.Stats()[0].Pool.DialErrors)
+	logger.AssertExpectations(t)
+}
+
+func TestSendCommandBusyFailover(t *testing.T) {
+	busy := NewMockTransServer()
+	defer busy.Close()
+	busy.SetBusy(true)
+	server := NewMockTransServer()
+	defer server.Close()
+	server.SetHandler(func(input []byte) []byte {
+		return []byte("status:TRANS_OK\n")
+	})
+
+	conf := TransConf{
+		Host:            busy.Address + "," + server.Address,
+		Timeout:         15,
+		AllowedCommands: test,
+		Busy: TransBusyConf{
+			Failover: true,
+		},
+	}
+	logger := MockLoggerInfrastructure{}
+
+	transFactory := NewTextProtocolTransFactory(conf, &logger)
+	defer transFactory.Close()
+	resp, err := transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: test})
+	assert.NoError(t, err)
+	assert.Equal(t, domain.TransFields{{Key: "status", Value: usecases.TransOK}}, resp.Fields)
+	logger.AssertExpectations(t)
+}
+
+func TestSendCommandBreakerOpen(t *testing.T) {
+	// nothing listens on the backend
+	down := newLocalListener()
+	downAddress := down.Addr().String()
+	down.Close()
+
+	conf := TransConf{
+		Host:            downAddress,
+		Timeout:         15,
+		AllowedCommands: test,
+		Breaker: TransBreakerConf{
+			ErrorRatio:  1,
+			MinRequests: 1,
+			Window:      time.Minute,
+			OpenTimeout: time.Minute,
+		},
+	}
+	logger := MockLoggerInfrastructure{}
+	logger.On("Error")
+	logger.On("Warn").Once()
+
+	transFactory := NewTextProtocolTransFactory(conf, &logger)
+	defer transFactory.Close()
+	_, err := transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: test})
+	assert.Equal(t, errConnect, err)
+	// the backend is not dialed again while the breaker is open
+	_, err = transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: test})
+	assert.Equal(t, errBreakerOpen, err)
+	assert.ErrorIs(t, err, domain.ErrTransUnavailable)
+	stats := transFactory.Stats()[0]
+	assert.Equal(t, BreakerOpen, stats.Breaker.State)
+	assert.Equal(t, int64(1), stats.Pool.DialErrors)
+	logger.AssertExpectations(t)
+}
+
+func TestSendCommandBreakerIgnoresCommandErrors(t *testing.T) {
+	server := NewMockTransServer()
+	defer server.Close()
+	server.SetHandler(func(input []byte) []byte {
+		if bytes.Contains(input, []byte("cmd:newad\n")) {
+			return []byte("blob:x:image\n" + EndMessage)
+		}
+		return []byte("status:TRANS_OK\nline:1\nline:2\n" + EndMessage)
+	})
+
+	conf := TransConf{
+		Host:            server.Address,
+		Timeout:         15,
+		AllowedCommands: test + "|newad",
+		Limits:          TransLimitsConf{Lines: 2},
+		Breaker: TransBreakerConf{
+			ErrorRatio:  1,
+			MinRequests: 1,
+			Window:      time.Minute,
+			OpenTimeout: time.Minute,
+		},
+	}
+	logger := MockLoggerInfrastructure{}
+	logger.On("Error").Twice()
+
+	transFactory := NewTextProtocolTransFactory(conf, &logger)
+	defer transFactory.Close()
+	_, err := transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: test})
+	assert.Equal(t, domain.ResponseLimitError{Limit: LimitLines, Max: 2}, err)
+	_, err = transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: "newad"})
+	assert.EqualError(t, err, `trans-proxy: cannot parse blob length: "blob:x:image\n"`)
+	// neither the response limit nor the invalid response open the breaker
+	stats := transFactory.Stats()[0]
+	assert.Equal(t, BreakerClosed, stats.Breaker.State)
+	assert.Equal(t, int64(2), stats.Pool.Dials)
+	logger.AssertExpectations(t)
+}
+
+func TestSendCommandBreakerIgnoresPoolWait(t *testing.T) {
+	release := make(chan struct{})
+	server := NewMockTransServer()
+	defer server.Close()
+	server.SetHandler(func(input []byte) []byte {
+		<-release
+		return []byte("status:TRANS_OK\n")
+	})
+
+	conf := TransConf{
+		Host:            server.Address,
+		Timeout:         15,
+		AllowedCommands: "slow|get_ad",
+		Pool:            TransPoolConf{MaxOpen: 1},
+		Commands: map[string]TransCommandConf{
+			"get_ad": {Timeout: Duration(50 * time.Millisecond)},
+		},
+		Breaker: TransBreakerConf{
+			ErrorRatio:  1,
+			MinRequests: 1,
+			Window:      time.Minute,
+			OpenTimeout: time.Minute,
+		},
+	}
+	logger := MockLoggerInfrastructure{}
+	logger.On("Error").Once()
+
+	transFactory := NewTextProtocolTransFactory(conf, &logger)
+	defer transFactory.Close()
+	slow := make(chan error)
+	go func() {
+		_, err := transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: "slow"})
+		slow <- err
+	}()
+	assert.Eventually(t, func() bool { return transFactory.Stats()[0].Pool.Open == 1 }, time.Second, time.Millisecond)
+
+	// the only connection is taken, the command times out waiting for it
+	_, err := transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: "get_ad"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, BreakerClosed, transFactory.Stats()[0].Breaker.State)
+
+	close(release)
+	assert.NoError(t, <-slow)
+	assert.Equal(t, BreakerClosed, transFactory.Stats()[0].Breaker.State)
+	logger.AssertExpectations(t)
+}
+
+func TestBreakerFailure(t *testing.T) {
+	assert.True(t, breakerFailure(errConnect))
+	assert.True(t, breakerFailure(errConnClosed))
+	assert.True(t, breakerFailure(domain.TimeoutError{Phase: PhaseRead, Timeout: time.Second}))
+	assert.True(t, breakerFailure(io.ErrUnexpectedEOF))
+	assert.True(t, breakerFailure(&net.OpError{Op: "read", Err: errors.New("connection reset by peer")}))
+	assert.False(t, breakerFailure(nil))
+	assert.False(t, breakerFailure(domain.ErrTransBusy))
+	assert.False(t, breakerFailure(domain.ResponseLimitError{Limit: LimitLines, Max: 2}))
+	assert.False(t, breakerFailure(fmt.Errorf("error parsing response: invalid line")))
+	assert.False(t, breakerFailure(domain.EncodingErrors{{Key: "name", Reason: "invalid"}}))
+}
+
+func TestSendCommandRetryReadTimeout(t *testing.T) {
+	var received int32
+	server := NewMockTransServer()
+	defer server.Close()
+	server.SetHandler(func(input []byte) []byte {
+		// only the first attempt takes too long
+		if atomic.AddInt32(&received, 1) == 1 {
+			time.Sleep(100 * time.Millisecond)
+		}
+		return []byte("status:TRANS_OK\n")
+	})
+
+	retry := &TransRetryConf{
+		Attempts: 2,
+		On:       FailureTimeout,
+	}
+	conf := TransConf{
+		Host:            server.Address,
+		Timeout:         15,
+		Timeouts:        TransTimeoutsConf{Read: Duration(20 * time.Millisecond)},
+		AllowedCommands: "get_ad|newad",
+		Commands: map[string]TransCommandConf{
+			"get_ad": {ReadOnly: true, Retry: retry},
+			"newad":  {Retry: retry},
+		},
+	}
+	logger := MockLoggerInfrastructure{}
+	logger.On("Warn").Once()
+	logger.On("Error").Once()
+
+	transFactory := NewTextProtocolTransFactory(conf, &logger)
+	defer transFactory.Close()
+	resp, err := transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: "get_ad"})
+	assert.NoError(t, err)
+	assert.Equal(t, domain.TransFields{{Key: "status", Value: usecases.TransOK}}, resp.Fields)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&received))
+
+	// a command that writes is never sent twice
+	atomic.StoreInt32(&received, 0)
+	_, err = transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: "newad"})
+	assert.Equal(t, domain.TimeoutError{Phase: PhaseRead, Timeout: 20 * time.Millisecond}, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&received))
+	logger.AssertExpectations(t)
+}
+
+func TestSendCommandClosedAfterWrite(t *testing.T) {
+	var received int32
+	server := NewMockTransServer()
+	defer server.Close()
+	// the first command of each round runs and the connection is dropped
+	// before replying
+	server.SetDisconnectingHandler(func(input []byte) ([]byte, error) {
+		if atomic.AddInt32(&received, 1) == 1 {
+			return nil, errors.New("dropped")
+		}
+		return []byte("status:TRANS_OK\n"), nil
+	})
+
+	retry := &TransRetryConf{Attempts: 2, On: FailureTimeout}
+	conf := TransConf{
+		Host:            server.Address,
+		Timeout:         15,
+		AllowedCommands: "get_ad|newad",
+		Commands: map[string]TransCommandConf{
+			"get_ad": {ReadOnly: true, Retry: retry},
+			"newad":  {Retry: retry},
+		},
+		Pool: TransPoolConf{MinIdle: 1, MaxIdleTime: time.Second},
+	}
+	logger := MockLoggerInfrastructure{}
+	logger.On("Warn").Once()
+	logger.On("Error").Once()
+
+	transFactory := NewTextProtocolTransFactory(conf, &logger)
+	defer transFactory.Close()
+	idle := func() bool { return transFactory.Stats()[0].Pool.Idle == 1 }
+
+	// trans may have run the command, so a write is not sent again
+	assert.Eventually(t, idle, 2*time.Second, 5*time.Millisecond)
+	_, err := transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: "newad"})
+	assert.Equal(t, errConnClosed, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&received))
+
+	// commands that can be applied twice are retried as timeouts
+	assert.Eventually(t, idle, 2*time.Second, 5*time.Millisecond)
+	atomic.StoreInt32(&received, 0)
+	resp, err := transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: "get_ad"})
+	assert.NoError(t, err)
+	assert.Equal(t, domain.TransFields{{Key: "status", Value: usecases.TransOK}}, resp.Fields)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&received))
+	logger.AssertExpectations(t)
+}
+
+func TestSendCommandPhaseTimeouts(t *testing.T) {
+	// a server that accepts connections but never greets them
+	silent := newLocalListener()
+	defer silent.Close() // nolint: errcheck
+	go func() {
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close() // nolint: errcheck
+		}
+	}()
+	server := NewMockTransServer()
+	defer server.Close()
+	server.SetHandler(func(input []byte) []byte {
+		time.Sleep(100 * time.Millisecond)
+		return []byte("status:TRANS_OK\n")
+	})
+
+	conf := TransConf{
+		Host:            silent.Addr().String(),
+		Timeout:         15,
+		Timeouts:        TransTimeoutsConf{Greeting: Duration(20 * time.Millisecond)},
+		AllowedCommands: "get_ad",
+		Commands: map[string]TransCommandConf{
+			"get_ad": {Timeouts: &TransTimeoutsConf{Read: Duration(30 * time.Millisecond)}},
+		},
+	}
+	logger := MockLoggerInfrastructure{}
+	logger.On("Error")
+
+	transFactory := NewTextProtocolTransFactory(conf, &logger)
+	defer transFactory.Close()
+	_, err := transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: "get_ad"})
+	assert.Equal(t, domain.TimeoutError{Phase: PhaseGreeting, Timeout: 20 * time.Millisecond}, err)
+	assert.Equal(t, int64(1), transFactory.Stats()[0].Timeouts[PhaseGreeting])
+
+	// the command overrides the read timeout
+	conf.Host = server.Address
+	transFactory = NewTextProtocolTransFactory(conf, &logger)
+	defer transFactory.Close()
+	_, err = transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: "get_ad"})
+	assert.Equal(t, domain.TimeoutError{Phase: PhaseRead, Timeout: 30 * time.Millisecond}, err)
+	stats := transFactory.Stats()[0]
+	assert.Equal(t, map[string]int64{PhaseConnect: 0, PhaseGreeting: 0, PhaseWrite: 0, PhaseRead: 1}, stats.Timeouts)
+	logger.AssertExpectations(t)
+}
+
+func TestSendCommandClientContextGreeting(t *testing.T) {
+	// a server that accepts connections but never greets them
+	silent := newLocalListener()
+	defer silent.Close() // nolint: errcheck
+	go func() {
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close() // nolint: errcheck
+		}
+	}()
+
+	conf := TransConf{
+		Host:            silent.Addr().String(),
+		Timeout:         15,
+		Timeouts:        TransTimeoutsConf{Greeting: Duration(3 * time.Second)},
+		AllowedCommands: test,
+		Breaker:         TransBreakerConf{ErrorRatio: 0.5, MinRequests: 1, Window: time.Minute, OpenTimeout: time.Minute},
+	}
+	logger := MockLoggerInfrastructure{}
+	logger.On("Error")
+	transFactory := NewTextProtocolTransFactory(conf, &logger)
+	defer transFactory.Close()
+
+	// the greeting gives up at the client deadline, shorter than its timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := transFactory.MakeTransHandler().SendCommand(ctx, domain.TransCommand{Command: test})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.True(t, time.Since(start) < time.Second)
+	stats := transFactory.Stats()[0]
+	assert.Equal(t, int64(0), stats.Timeouts[PhaseGreeting])
+	assert.Equal(t, BreakerClosed, stats.Breaker.State)
+	assert.Equal(t, 0, stats.Pool.Open)
+	logger.AssertExpectations(t)
+}
+
+func TestSendCommandRepeatedKeys(t *testing.T) {
+	server := NewMockTransServer()
+	defer server.Close()
+	server.SetHandler(func(input []byte) []byte {
+		return []byte("ad_id:2\nad_id:1\nstatus:TRANS_OK\nad_id:3\n")
+	})
+	conf := TransConf{
+		Host:            server.Address,
+		Timeout:         15,
+		AllowedCommands: test,
+	}
+	logger := MockLoggerInfrastructure{}
+
+	transFactory := NewTextProtocolTransFactory(conf, &logger)
+	defer transFactory.Close()
+	resp, err := transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: test})
+	expected := domain.TransFields{
+		{Key: "ad_id", Value: "2"},
+		{Key: "ad_id", Value: "1"},
+		{Key: "status", Value: usecases.TransOK},
+		{Key: "ad_id", Value: "3"},
+	}
+	assert.NoError(t, err)
+	assert.Equal(t, expected, resp.Fields)
+	logger.AssertExpectations(t)
+}
+
+func TestSendCommandBlobResponse(t *testing.T) {
+	image := []byte("\x89PNG\r\n\xff\xc1\nend\n\x00")
+	server := NewMockTransServer()
+	defer server.Close()
+	server.SetHandler(func(input []byte) []byte {
+		response := []byte("subject:avi\xf3n\n")
+		response = append(response, "blob:"+strconv.Itoa(len(image))+":image\n"...)
+		response = append(response, image...)
+		response = append(response, "\nblob:0:empty\n\nstatus:TRANS_OK\n"...)
+		return response
+	})
+	conf := TransConf{
+		Host:            server.Address,
+		Timeout:         15,
+		AllowedCommands: test,
+	}
+	logger := MockLoggerInfrastructure{}
+
+	transFactory := NewTextProtocolTransFactory(conf, &logger)
+	defer transFactory.Close()
+	resp, err := transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: test})
+	expected := domain.TransFields{
+		{Key: "subject", Value: "avión"},
+		{Key: "image", Blob: image},
+		{Key: "empty", Blob: []byte{}},
+		{Key: "status", Value: usecases.TransOK},
+	}
+	assert.NoError(t, err)
+	assert.Equal(t, expected, resp.Fields)
+	logger.AssertExpectations(t)
+}
+
+func TestSendCommandCharsets(t *testing.T) {
+	var received []byte
+	server := NewMockTransServer()
+	defer server.Close()
+	server.SetHandler(func(input []byte) []byte {
+		received = input
+		return []byte("subject:\x80 10\nstatus:TRANS_OK\n")
+	})
+	cases := map[string]struct {
+		charset  string
+		sent     string
+		response string
+	}{
+		CharsetISO88591:    {CharsetISO88591, "cmd:test\nsubject:avi\xf3n\ncommit:1\nend\n", "\u0080 10"},
+		CharsetWindows1252: {"Windows-1252", "cmd:test\nsubject:avi\xf3n\ncommit:1\nend\n", "€ 10"},
+	}
+	for name, c := range cases {
+		conf := TransConf{
+			Host:            server.Address,
+			Timeout:         15,
+			AllowedCommands: test,
+			Charset:         c.charset,
+		}
+		logger := MockLoggerInfrastructure{}
+		transFactory := NewTextProtocolTransFactory(conf, &logger)
+		params := []domain.TransParams{{Key: "subject", Value: "avión"}}
+		resp, err := transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: test, Params: params})
+		assert.NoError(t, err, name)
+		assert.Equal(t, c.sent, string(received), name)
+		assert.Equal(t, c.response, resp.Fields[0].Value, name)
+		assert.Empty(t, resp.Warnings, name)
+		logger.AssertExpectations(t)
+		transFactory.Close()
+	}
+}
+
+func TestSendCommandBackendCharset(t *testing.T) {
+	var received []byte
+	server := NewMockTransServer()
+	defer server.Close()
+	server.SetHandler(func(input []byte) []byte {
+		received = input
+		return []byte("subject:\xf0\x9f\x9a\xb2\nstatus:TRANS_OK\n")
+	})
+	conf := TransConf{
+		Host:            server.Address,
+		Timeout:         15,
+		AllowedCommands: test,
+		BackendCharsets: "other:20005=windows-1252, " + server.Address + "=utf-8",
+	}
+	logger := MockLoggerInfrastructure{}
+	transFactory := NewTextProtocolTransFactory(conf, &logger)
+	defer transFactory.Close()
+
+	params := []domain.TransParams{{Key: "subject", Value: "bike 🚲"}}
+	resp, err := transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: test, Params: params})
+	assert.NoError(t, err)
+	assert.Equal(t, "cmd:test\nsubject:bike 🚲\ncommit:1\nend\n", string(received))
+	assert.Equal(t, "🚲", resp.Fields[0].Value)
+	logger.AssertExpectations(t)
+}
+
+func TestSendCommandCharsetModes(t *testing.T) {
+	var received []byte
+	server := NewMockTransServer()
+	defer server.Close()
+	server.SetHandler(func(input []byte) []byte {
+		received = input
+		return []byte("subject:\x81\nstatus:TRANS_OK\n")
+	})
+	conf := TransConf{
+		Host:            server.Address,
+		Timeout:         15,
+		AllowedCommands: test,
+		Charset:         CharsetWindows1252,
+	}
+	params := []domain.TransParams{
+		{Key: "subject", Value: "bike 🚲"},
+		{Key: "body", Value: "中文"},
+	}
+
+	// strict
+	logger := MockLoggerInfrastructure{}
+	logger.On("Error").Once()
+	transFactory := NewTextProtocolTransFactory(conf, &logger)
+	_, err := transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: test, Params: params})
+	expectedErr := domain.EncodingErrors{
+		{Key: "subject", Reason: "character '🚲' can't be written in windows-1252"},
+		{Key: "body", Reason: "character '中' can't be written in windows-1252"},
+	}
+	assert.Equal(t, expectedErr, err)
+	assert.Nil(t, received)
+	logger.AssertExpectations(t)
+	transFactory.Close()
+
+	// lenient
+	conf.CharsetMode = CharsetLenient
+	logger = MockLoggerInfrastructure{}
+	logger.On("Warn").Once()
+	transFactory = NewTextProtocolTransFactory(conf, &logger)
+	defer transFactory.Close()
+	resp, err := transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: test, Params: params})
+	assert.NoError(t, err)
+	assert.Equal(t, "cmd:test\nsubject:bike ?\nbody:??\ncommit:1\nend\n", string(received))
+	expectedWarnings := []string{
+		"param subject: 1 characters can't be written in windows-1252 and were replaced",
+		"param body: 2 characters can't be written in windows-1252 and were replaced",
+		"response: 1 characters invalid in windows-1252 were replaced",
+	}
+	assert.Equal(t, expectedWarnings, resp.Warnings)
+	assert.Equal(t, "\ufffd", resp.Fields[0].Value)
+	logger.AssertExpectations(t)
+}
+
+func TestTransConfCheckCharsets(t *testing.T) {
+	conf := TransConf{Host: "trans1,trans2", Port: 20005, BackendCharsets: "trans2:20005=utf-8"}
+	assert.NoError(t, conf.CheckCharsets())
+	conf.BackendCharsets = "trans2:20005=ebcdic"
+	assert.Error(t, conf.CheckCharsets())
+	conf.BackendCharsets = ""
+	conf.CharsetMode = "loose"
+	assert.Error(t, conf.CheckCharsets())
+}
+
+func TestSendCommandProtocolInjection(t *testing.T) {
+	received := false
+	server := NewMockTransServer()
+	defer server.Close()
+	server.SetHandler(func(input []byte) []byte {
+		received = true
+		return []byte("status:TRANS_OK\n")
+	})
+	conf := TransConf{
+		Host:            server.Address,
+		Timeout:         15,
+		AllowedCommands: "get_ad|get_ad\ncmd:delete_ad",
+	}
+	logger := MockLoggerInfrastructure{}
+	logger.On("Error").Twice()
+	transFactory := NewTextProtocolTransFactory(conf, &logger)
+	defer transFactory.Close()
+
+	params := []domain.TransParams{
+		{Key: "ad_id:1\nad_id", Value: "2"},
+		{Key: "body", Value: "a\nb", Blob: true},
+		{Key: "subject", Value: "a\ncommit:1\nend"},
+	}
+	_, err := transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: "get_ad", Params: params})
+	expectedErr := domain.ParamErrors{
+		{Key: "ad_id:1\nad_id", Reason: "key has ':' or newlines"},
+		{Key: "subject", Reason: "value has newlines, send it as a blob"},
+	}
+	assert.Equal(t, expectedErr, err)
+
+	_, err = transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: "get_ad\ncmd:delete_ad"})
+	expectedErr = domain.ParamErrors{{Key: "cmd", Reason: "command name has invalid character '\\n'"}}
+	assert.Equal(t, expectedErr, err)
+	assert.False(t, received)
+	logger.AssertExpectations(t)
+}
+
+func TestAppendCmdReservedKeys(t *testing.T) {
+	charset, err := newTransCharset("utf-8", CharsetStrict)
+	assert.NoError(t, err)
+	for _, key := range []string{"cmd", "commit", "end", "blob", "blobs"} {
+		t.Run(key, func(t *testing.T) {
+			params := []domain.TransParams{{Key: "ad_id", Value: "1"}, {Key: key, Value: "1"}}
+			wire, _, err := appendCmd(nil, "get_ad", params, true, charset)
+			assert.Nil(t, wire)
+			assert.Equal(t, domain.ParamErrors{{Key: key, Reason: "reserved key"}}, err)
+		})
+	}
+	// the same words are fine as values
+	params := []domain.TransParams{{Key: "action", Value: "commit"}, {Key: "body", Value: "ZW5k", Blob: true}}
+	wire, _, err := appendCmd(nil, "get_ad", params, true, charset)
+	assert.NoError(t, err)
+	assert.Equal(t, "cmd:get_ad\naction:commit\nblob:3:body\nend\ncommit:1\nend\n", string(wire))
+}
+
+func TestSendCommandDryRun(t *testing.T) {
+	var received []byte
+	server := NewMockTransServer()
+	defer server.Close()
+	server.SetHandler(func(input []byte) []byte {
+		received = input
+		return []byte("status:TRANS_OK\n")
+	})
+	conf := TransConf{
+		Host:            server.Address,
+		Timeout:         15,
+		AllowedCommands: test,
+	}
+	logger := MockLoggerInfrastructure{}
+	transFactory := NewTextProtocolTransFactory(conf, &logger)
+	defer transFactory.Close()
+
+	params := []domain.TransParams{{Key: "ad_id", Value: "1"}}
+	_, err := transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: test, Params: params, DryRun: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "cmd:test\nad_id:1\ncommit:0\nend\n", string(received))
+
+	// a commit param can't turn the dry run into a committed command
+	received = nil
+	logger.On("Error").Once()
+	params = append(params, domain.TransParams{Key: "commit", Value: "1"})
+	_, err = transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: test, Params: params, DryRun: true})
+	assert.Equal(t, domain.ParamErrors{{Key: "commit", Reason: "reserved key"}}, err)
+	assert.Nil(t, received)
+	logger.AssertExpectations(t)
+}
+
+func TestSendCommandClientContext(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	server := NewMockTransServer()
+	defer server.Close()
+	server.SetHandler(func(input []byte) []byte {
+		<-release
+		return []byte("status:TRANS_OK\n")
+	})
+	conf := TransConf{
+		Host:            server.Address,
+		Timeout:         15,
+		AllowedCommands: test,
+		Breaker:         TransBreakerConf{ErrorRatio: 0.5, MinRequests: 1, Window: time.Minute, OpenTimeout: time.Minute},
+	}
+	logger := MockLoggerInfrastructure{}
+	logger.On("Error")
+	transFactory := NewTextProtocolTransFactory(conf, &logger)
+	defer transFactory.Close()
+
+	// the client deadline is shorter than the server timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := transFactory.MakeTransHandler().SendCommand(ctx, domain.TransCommand{Command: test})
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, time.Since(start) < time.Second)
+
+	// canceling closes the connection right away
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start = time.Now()
+	_, err = transFactory.MakeTransHandler().SendCommand(ctx, domain.TransCommand{Command: test})
+	assert.Equal(t, context.Canceled, err)
+	assert.True(t, time.Since(start) < time.Second)
+
+	// the clients going away is not the fault of the backend
+	stats := transFactory.Stats()
+	assert.Equal(t, BreakerClosed, stats[0].Breaker.State)
+
+	// a command whose client already left is never sent
+	_, err = transFactory.MakeTransHandler().SendCommand(ctx, domain.TransCommand{Command: test})
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestSendCommandUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trans-unix")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	server := NewMockTransUnixServer(filepath.Join(dir, "trans.sock"))
+	defer server.Close()
+	server.SetHandler(func(input []byte) []byte {
+		assert.Equal(t, "cmd:transinfo\ncommit:1\nend\n", string(input))
+		return []byte("status:TRANS_OK\n")
+	})
+	assert.Equal(t, "unix://"+filepath.Join(dir, "trans.sock"), server.Address)
+
+	conf := TransConf{
+		Host:            server.Address,
+		Port:            20005,
+		Timeout:         15,
+		AllowedCommands: "transinfo",
+	}
+	logger := MockLoggerInfrastructure{}
+	transFactory := NewTextProtocolTransFactory(conf, &logger)
+	defer transFactory.Close()
+	reply, err := transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: "transinfo"})
 	assert.NoError(t, err)
-	assert.Equal(t, map[string]string{}, resp)
+	assert.Equal(t, domain.TransFields{{Key: "status", Value: "TRANS_OK"}}, reply.Fields)
+	assert.Equal(t, server.Address, transFactory.Stats()[0].Address)
 	logger.AssertExpectations(t)
 }
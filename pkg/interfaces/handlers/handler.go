@@ -1,7 +1,12 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/Yapo/goutils"
 )
@@ -22,8 +27,9 @@ type Handler interface {
 	// to be filled with the user input for a request
 	Input(InputRequest) HandlerInput
 	// Execute is the actual handler code. The InputGetter can be used to retrieve
-	// the request's input at any time (or not at all).
-	Execute(InputGetter) *goutils.Response
+	// the request's input at any time (or not at all). The context is done
+	// when the client goes away or its deadline passes
+	Execute(context.Context, InputGetter) *goutils.Response
 }
 
 // InputHandler defines what methods an input handler should have
@@ -65,6 +71,41 @@ type RequestCacheHandler interface {
 	SetCache(input interface{}, response *goutils.Response) error
 }
 
+// BodyWithHeaders wraps the body of a goutils.Response adding the HTTP
+// headers that must be sent along with it. Only the body is encoded as json
+type BodyWithHeaders struct {
+	Body    interface{}
+	Headers map[string]string
+}
+
+// MarshalJSON encodes the wrapped body
+func (b BodyWithHeaders) MarshalJSON() ([]byte, error) {
+	return json.Marshal(b.Body)
+}
+
+// RequestTimeoutHeader header clients can tell how long they will wait for
+// the response with, as a duration like 1.5s or a number of seconds
+const RequestTimeoutHeader = "X-Request-Timeout"
+
+// requestContext returns the context of the request, with the deadline set
+// by the client in the RequestTimeoutHeader header, if any
+func requestContext(r *http.Request) (context.Context, context.CancelFunc, error) {
+	header := r.Header.Get(RequestTimeoutHeader)
+	if header == "" {
+		ctx, cancel := context.WithCancel(r.Context())
+		return ctx, cancel, nil
+	}
+	timeout, err := time.ParseDuration(header)
+	if seconds, errSeconds := strconv.ParseFloat(header, 64); err != nil && errSeconds == nil {
+		timeout, err = time.Duration(seconds*float64(time.Second)), nil
+	}
+	if err != nil || timeout <= 0 {
+		return nil, nil, fmt.Errorf("invalid %s header %q", RequestTimeoutHeader, header)
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	return ctx, cancel, nil
+}
+
 const CACHESET string = " (cache set)"
 const FROMCACHE string = " (from cache)"
 
@@ -136,6 +177,12 @@ func (jh *jsonHandler) run(w http.ResponseWriter, r *http.Request) {
 	jh.inputHandler.SetInputRequest(ri, input)
 	// Format the output and send it down the writer
 	outputWriter := func() {
+		if body, ok := response.Body.(BodyWithHeaders); ok {
+			for key, value := range body.Headers {
+				w.Header().Set(key, value)
+			}
+			response.Body = body.Body
+		}
 		goutils.CreateJSON(response)
 		goutils.WriteJSONResponse(w, response)
 	}
@@ -151,6 +198,21 @@ func (jh *jsonHandler) run(w http.ResponseWriter, r *http.Request) {
 
 	requestCacheStatus := ""
 
+	// the handler stops as soon as the client goes away, or its own
+	// deadline passes
+	ctx, cancel, err := requestContext(r)
+	if err != nil {
+		response = &goutils.Response{
+			Code: http.StatusBadRequest,
+			Body: &goutils.GenericError{
+				ErrorMessage: err.Error(),
+			},
+		}
+		jh.logger.LogRequestEnd(r, response, requestCacheStatus)
+		return
+	}
+	defer cancel()
+
 	if jh.cache.Validate(w, r) {
 		response = &goutils.Response{
 			Code: http.StatusNotModified,
@@ -158,6 +220,7 @@ func (jh *jsonHandler) run(w http.ResponseWriter, r *http.Request) {
 	} else {
 		// Do the Harlem Shake
 		response = jh.handler.Execute(
+			ctx,
 			jh.inputGetterCacheDecorator(jh.inputHandler.Input, &requestCacheStatus),
 		)
 		if err := jh.requestCache.SetCache(input, response); err == nil {
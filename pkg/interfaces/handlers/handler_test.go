@@ -1,11 +1,13 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -29,7 +31,7 @@ func (m *MockHandler) Input(ir InputRequest) HandlerInput {
 	return args.Get(0).(HandlerInput)
 }
 
-func (m *MockHandler) Execute(getter InputGetter) *goutils.Response {
+func (m *MockHandler) Execute(ctx context.Context, getter InputGetter) *goutils.Response {
 	args := m.Called(getter)
 	_, response := getter()
 	if response != nil {
@@ -64,7 +66,7 @@ func (m *MockPanicHandler) Input(ir InputRequest) HandlerInput {
 	args := m.Called(ir)
 	return args.Get(0).(HandlerInput)
 }
-func (m *MockPanicHandler) Execute(getter InputGetter) *goutils.Response {
+func (m *MockPanicHandler) Execute(ctx context.Context, getter InputGetter) *goutils.Response {
 	m.Called(getter)
 	panic("dead")
 }
@@ -444,3 +446,114 @@ func TestJsonHandlerFuncCache(t *testing.T) {
 	mCache.AssertExpectations(t)
 	mRequestCache.AssertExpectations(t)
 }
+
+func TestJsonHandlerFuncBodyWithHeaders(t *testing.T) {
+	h := MockHandler{}
+	ih := MockInputHandler{}
+	mMockInputRequest := MockInputRequest{}
+	l := MockLogger{}
+	input := &DummyInput{}
+	response := &goutils.Response{
+		Code: 503,
+		Body: BodyWithHeaders{
+			Body:    DummyOutput{"busy"},
+			Headers: map[string]string{"Retry-After": "5"},
+		},
+	}
+	getter := mock.AnythingOfType("handlers.InputGetter")
+	h.On("Execute", getter).Return(response).Once()
+	h.On("Input", mock.AnythingOfType("*handlers.MockInputRequest")).Return(input).Once()
+
+	ih.On("NewInputRequest", mock.AnythingOfType("*http.Request")).Return(&mMockInputRequest)
+	ih.On("Input").Return(input, response)
+	ih.On(
+		"SetInputRequest",
+		mock.AnythingOfType("*handlers.MockInputRequest"),
+		mock.AnythingOfType("*handlers.DummyInput"),
+	)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/someurl", strings.NewReader("{}"))
+
+	l.On("LogRequestStart", r)
+	l.On("LogRequestEnd", r, response, mock.AnythingOfType("string"))
+
+	mC := MockCors{}
+	mC.On("GetHeaders").Return(map[string]string{})
+	mCache := MockCache{}
+	mCache.On("Validate").Return(false)
+	mRequestCache := MockRequestCache{}
+	mRequestCache.On("GetCache", mock.AnythingOfType("*handlers.DummyInput")).Return(response, fmt.Errorf(""))
+	mRequestCache.On("SetCache", mock.AnythingOfType("*handlers.DummyInput"), response).Return(fmt.Errorf(""))
+	fn := MakeJSONHandlerFunc(&h, &l, &ih, &mC, &mCache, &mRequestCache)
+	fn(w, r)
+
+	expectedHeaders := http.Header{
+		"Retry-After":  []string{"5"},
+		"Content-Type": []string{"application/json"}}
+
+	assert.Equal(t, expectedHeaders, w.Result().Header)
+	assert.Equal(t, 503, w.Code)
+	assert.Equal(t, "{\"Y\":\"busy\"}\n", w.Body.String())
+	h.AssertExpectations(t)
+	ih.AssertExpectations(t)
+	l.AssertExpectations(t)
+}
+
+func TestRequestContext(t *testing.T) {
+	r := httptest.NewRequest("GET", "/someurl", nil)
+	ctx, cancel, err := requestContext(r)
+	assert.NoError(t, err)
+	_, ok := ctx.Deadline()
+	assert.False(t, ok)
+	cancel()
+	assert.Equal(t, context.Canceled, ctx.Err())
+
+	for _, header := range []string{"1.5s", "1.5"} {
+		r.Header.Set(RequestTimeoutHeader, header)
+		ctx, cancel, err = requestContext(r)
+		assert.NoError(t, err)
+		deadline, ok := ctx.Deadline()
+		assert.True(t, ok)
+		assert.WithinDuration(t, time.Now().Add(1500*time.Millisecond), deadline, 100*time.Millisecond)
+		cancel()
+	}
+
+	for _, header := range []string{"soon", "-1s", "0"} {
+		r.Header.Set(RequestTimeoutHeader, header)
+		_, _, err = requestContext(r)
+		assert.EqualError(t, err, fmt.Sprintf("invalid X-Request-Timeout header %q", header))
+	}
+}
+
+func TestJsonHandlerFuncInvalidRequestTimeout(t *testing.T) {
+	h := MockHandler{}
+	ih := MockInputHandler{}
+	mMockInputRequest := MockInputRequest{}
+	l := MockLogger{}
+	input := &DummyInput{}
+	h.On("Input", mock.AnythingOfType("*handlers.MockInputRequest")).Return(input).Once()
+	ih.On("NewInputRequest", mock.AnythingOfType("*http.Request")).Return(&mMockInputRequest)
+	ih.On(
+		"SetInputRequest",
+		mock.AnythingOfType("*handlers.MockInputRequest"),
+		mock.AnythingOfType("*handlers.DummyInput"),
+	)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/someurl", strings.NewReader("{}"))
+	r.Header.Set(RequestTimeoutHeader, "soon")
+
+	l.On("LogRequestStart", r)
+	l.On("LogRequestEnd", r, mock.AnythingOfType("*goutils.Response"), "")
+	mC := MockCors{}
+	mC.On("GetHeaders").Return(map[string]string{})
+	fn := MakeJSONHandlerFunc(&h, &l, &ih, &mC, &MockCache{}, &MockRequestCache{})
+	fn(w, r)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, `{"ErrorMessage":"invalid X-Request-Timeout header \"soon\""}`+"\n", w.Body.String())
+	h.AssertExpectations(t)
+	ih.AssertExpectations(t)
+	l.AssertExpectations(t)
+}
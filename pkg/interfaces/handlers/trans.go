@@ -1,19 +1,56 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/Yapo/goutils"
 	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/domain"
 	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/usecases"
 )
 
+const (
+	// ResponseFormatV1 encodes the response params as a flat object, where
+	// only the last value of a repeated key is kept
+	ResponseFormatV1 = "v1"
+	// ResponseFormatV2 encodes the response params keeping the order trans
+	// sent them, with the values of a repeated key as an array
+	ResponseFormatV2 = "v2"
+	// ResponseFormatGrouped encodes the response params as ResponseFormatV2
+	// does, folding indexed keys like ad.0.subject into nested objects
+	ResponseFormatGrouped = "grouped"
+)
+
+// DeprecationHeader header telling the clients the command was asked with
+// a deprecated alias
+const DeprecationHeader = "Deprecation"
+
+// TransBlobsKey key the blobs of a response are returned under, the same
+// the blobs of a request are accepted with
+const TransBlobsKey = "blobs"
+
 // TransHandler implements the handler interface and responds to /execute
 // requests with a message. Expected response format:
 // { status: string, response: json }
 type TransHandler struct {
 	Interactor                usecases.ExecuteTransUsecase
 	TokenValidationInteractor usecases.ValidateTokenInteractor
+	// BusyRetryAfter time clients are told to wait when trans is busy
+	BusyRetryAfter time.Duration
+	// Groupings how the keys of each command are grouped with the
+	// ResponseFormatGrouped format. Commands not found use the defaults
+	Groupings map[string]TransGrouping
+	// CaptureAuth validates the token sent on CaptureHeader. Nil refuses
+	// every capture asked with the header
+	CaptureAuth usecases.ValidateTokenInteractor
 }
 
 // TransHandlerInput struct that represents the input
@@ -21,18 +58,136 @@ type TransHandlerInput struct {
 	Token   string                 `headers:"Authorization"`
 	Command string                 `path:"command"`
 	Params  map[string]interface{} `json:"params"`
+	// Format of the response params, ResponseFormatV1 if empty
+	Format string `query:"format"`
+	// DryRun runs the command without committing it. It can be asked in
+	// the body or with the DryRunHeader header
+	DryRun       bool   `json:"dry_run"`
+	DryRunHeader string `headers:"X-Dry-Run"`
+	// Client who is sending the command, to tell if it may ask for dry runs
+	Client string `headers:"X-Client-Id"`
+	// CaptureHeader the capture token, to record the exchange with trans
+	CaptureHeader string `headers:"X-Trans-Capture"`
+}
+
+// dryRun tells if the command must run without being committed
+func (in *TransHandlerInput) dryRun() (bool, error) {
+	if in.DryRunHeader == "" {
+		return in.DryRun, nil
+	}
+	dryRun, err := strconv.ParseBool(in.DryRunHeader)
+	if err != nil {
+		return false, fmt.Errorf("invalid X-Dry-Run header %q", in.DryRunHeader)
+	}
+	return dryRun || in.DryRun, nil
 }
 
 // TransRequestOutput struct that represents the output
 type TransRequestOutput struct {
-	Status   string            `json:"status"`
-	Response map[string]string `json:"response"`
+	Status string `json:"status"`
+	// Response the params of the response, as a map[string]string or
+	// OrderedTransFields depending on the requested format
+	Response interface{} `json:"response"`
+	// Errors the params that couldn't be sent to trans, if any
+	Errors domain.ParamErrors `json:"errors,omitempty"`
+	// Warnings things that went wrong without failing the command, like
+	// characters that had to be replaced to be written in the trans charset
+	Warnings []string `json:"warnings,omitempty"`
+	// Committed false for dry runs, which trans doesn't commit. Not sent
+	// for the rest of commands
+	Committed *bool `json:"committed,omitempty"`
+}
+
+// DryRunError is the error answered to a dry run, telling the client its
+// changes were not committed
+type DryRunError struct {
+	ErrorMessage string
+	Committed    bool `json:"committed"`
+}
+
+// OrderedTransFields encodes the fields of a trans response as a json
+// object that keeps the order of the keys. The values of a repeated key
+// are encoded as an array, placed where the key first appeared. Blobs are
+// encoded apart, see transBlobs
+type OrderedTransFields domain.TransFields
+
+// MarshalJSON encodes the fields as an ordered json object
+func (f OrderedTransFields) MarshalJSON() ([]byte, error) {
+	var keys []string
+	values := make(map[string][]string)
+	for _, field := range f {
+		if field.IsBlob() {
+			continue
+		}
+		if _, ok := values[field.Key]; !ok {
+			keys = append(keys, field.Key)
+		}
+		values[field.Key] = append(values[field.Key], field.Value)
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		var value interface{} = values[key]
+		if len(values[key]) == 1 {
+			value = values[key][0]
+		}
+		encodedKey, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		encodedValue, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(encodedKey)
+		buf.WriteByte(':')
+		buf.Write(encodedValue)
+	}
+	buf.WriteByte('}')
+	return appendBlobs(buf.Bytes(), domain.TransFields(f))
+}
+
+// transBlobs returns the blobs of the response encoded as base64, each one
+// in an object with its key, in the order trans sent them
+func transBlobs(fields domain.TransFields) []map[string]string {
+	var blobs []map[string]string
+	for _, field := range fields {
+		if field.IsBlob() {
+			blobs = append(blobs, map[string]string{
+				field.Key: base64.StdEncoding.EncodeToString(field.Blob),
+			})
+		}
+	}
+	return blobs
+}
+
+// appendBlobs adds the blobs of the response, if any, to the json object
+// under TransBlobsKey
+func appendBlobs(object []byte, fields domain.TransFields) ([]byte, error) {
+	blobs := transBlobs(fields)
+	if len(blobs) == 0 {
+		return object, nil
+	}
+	encoded, err := json.Marshal(map[string]interface{}{TransBlobsKey: blobs})
+	if err != nil {
+		return nil, err
+	}
+	// merge both objects, dropping the closing brace of the first one and
+	// the opening brace of the second
+	object = object[:len(object)-1]
+	if len(object) > 1 {
+		object = append(object, ',')
+	}
+	return append(object, encoded[1:]...), nil
 }
 
 // Input returns a fresh, empty instance of transHandlerInput
 func (t *TransHandler) Input(ir InputRequest) HandlerInput {
 	input := TransHandlerInput{}
-	ir.Set(&input).FromHeaders().FromJSONBody().FromPath()
+	ir.Set(&input).FromHeaders().FromJSONBody().FromPath().FromQuery()
 	return &input
 }
 
@@ -40,7 +195,7 @@ func (t *TransHandler) Input(ir InputRequest) HandlerInput {
 // of the execution.
 // Expected response format:
 //   { Status: string - "TRANS_OK" or error }
-func (t *TransHandler) Execute(ig InputGetter) *goutils.Response {
+func (t *TransHandler) Execute(ctx context.Context, ig InputGetter) *goutils.Response {
 	input, response := ig()
 	if response != nil {
 		return response
@@ -57,9 +212,180 @@ func (t *TransHandler) Execute(ig InputGetter) *goutils.Response {
 		}
 	}
 
+	command, response := t.command(in)
+	if response != nil {
+		return response
+	}
+	val, err := t.Interactor.ExecuteCommand(ctx, command)
+	return t.commandResponse(in, command, val, err)
+}
+
+// command builds the command asked in the input, or returns the error
+// response if the input is not valid
+func (t *TransHandler) command(in *TransHandlerInput) (domain.TransCommand, *goutils.Response) {
+	switch in.Format {
+	case "", ResponseFormatV1, ResponseFormatV2, ResponseFormatGrouped:
+	default:
+		return domain.TransCommand{}, &goutils.Response{
+			Code: http.StatusBadRequest,
+			Body: &goutils.GenericError{
+				ErrorMessage: fmt.Sprintf("unknown response format %q", in.Format),
+			},
+		}
+	}
+
+	dryRun, err := in.dryRun()
+	if err != nil {
+		return domain.TransCommand{}, &goutils.Response{
+			Code: http.StatusBadRequest,
+			Body: &goutils.GenericError{
+				ErrorMessage: err.Error(),
+			},
+		}
+	}
+
+	// only the holders of the capture token may see what goes on the wire
+	if in.CaptureHeader != "" &&
+		(t.CaptureAuth == nil || t.CaptureAuth.CleanAndMatchToken(in.CaptureHeader) != nil) {
+		return domain.TransCommand{}, &goutils.Response{
+			Code: http.StatusForbidden,
+			Body: &goutils.GenericError{
+				ErrorMessage: fmt.Sprintf("invalid %s header", CaptureHeader),
+			},
+		}
+	}
+
 	command := BuildCommand(in)
-	var val domain.TransResponse
-	val, err := t.Interactor.ExecuteCommand(command)
+	command.DryRun = dryRun
+	command.Client = in.Client
+	command.Capture = in.CaptureHeader != ""
+	return command, nil
+}
+
+// commandResponse returns the response to the execution of the command,
+// telling the client if the alias it used is deprecated. Every response to
+// a dry run, the failed ones included, is marked as not committed
+func (t *TransHandler) commandResponse(
+	in *TransHandlerInput, command domain.TransCommand, val domain.TransResponse, err error,
+) *goutils.Response {
+	response := t.executionResponse(in, command, val, err)
+	if command.DryRun {
+		response.Body = notCommitted(response.Body)
+	}
+	if !val.Deprecated {
+		return response
+	}
+	body, ok := response.Body.(BodyWithHeaders)
+	if !ok {
+		body = BodyWithHeaders{Body: response.Body, Headers: make(map[string]string)}
+	}
+	body.Headers[DeprecationHeader] = "true"
+	response.Body = body
+	return response
+}
+
+// notCommitted returns the body marked as the response to a dry run
+func notCommitted(body interface{}) interface{} {
+	switch b := body.(type) {
+	case TransRequestOutput:
+		b.Committed = new(bool)
+		return b
+	case *goutils.GenericError:
+		return DryRunError{ErrorMessage: b.ErrorMessage}
+	case BodyWithHeaders:
+		b.Body = notCommitted(b.Body)
+		return b
+	}
+	return body
+}
+
+// executionResponse returns the response to the execution of the command
+func (t *TransHandler) executionResponse(
+	in *TransHandlerInput, command domain.TransCommand, val domain.TransResponse, err error,
+) *goutils.Response {
+	var response *goutils.Response
+	// trans is too busy or can't be reached, the client may try again later
+	if errors.Is(err, domain.ErrTransBusy) || errors.Is(err, domain.ErrTransUnavailable) {
+		return &goutils.Response{
+			Code: http.StatusServiceUnavailable,
+			Body: BodyWithHeaders{
+				Body: &goutils.GenericError{
+					ErrorMessage: err.Error(),
+				},
+				Headers: map[string]string{
+					"Retry-After": strconv.Itoa(int(math.Ceil(t.BusyRetryAfter.Seconds()))),
+				},
+			},
+		}
+	}
+	// trans took too long in some phase, the message tells which one, or
+	// the command was abandoned before trans answered, because the request
+	// was canceled or its deadline passed
+	var timeout domain.TimeoutError
+	if errors.As(err, &timeout) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return &goutils.Response{
+			Code: http.StatusGatewayTimeout,
+			Body: &goutils.GenericError{
+				ErrorMessage: err.Error(),
+			},
+		}
+	}
+	// trans answered more than the command may read, it's not the client
+	// fault
+	var limit domain.ResponseLimitError
+	if errors.As(err, &limit) {
+		return &goutils.Response{
+			Code: http.StatusBadGateway,
+			Body: &goutils.GenericError{
+				ErrorMessage: err.Error(),
+			},
+		}
+	}
+	// the command or the client can't use dry runs
+	if errors.Is(err, domain.ErrDryRunNotAllowed) {
+		return &goutils.Response{
+			Code: http.StatusForbidden,
+			Body: &goutils.GenericError{
+				ErrorMessage: err.Error(),
+			},
+		}
+	}
+	// the command was not sent, tell the client which params are wrong
+	var paramErrors domain.ParamErrors
+	if errors.As(err, &paramErrors) {
+		return &goutils.Response{
+			Code: http.StatusBadRequest,
+			Body: TransRequestOutput{
+				Status:   val.Status,
+				Response: t.responseParams(in, val),
+				Errors:   paramErrors,
+			},
+		}
+	}
+	// the params can't be written in the charset of trans
+	var encodingErrors domain.EncodingErrors
+	if errors.As(err, &encodingErrors) {
+		return &goutils.Response{
+			Code: http.StatusUnprocessableEntity,
+			Body: TransRequestOutput{
+				Status:   val.Status,
+				Response: t.responseParams(in, val),
+				Errors:   domain.ParamErrors(encodingErrors),
+			},
+		}
+	}
+	// the params don't match the schema of the command
+	var schemaErrors domain.SchemaErrors
+	if errors.As(err, &schemaErrors) {
+		return &goutils.Response{
+			Code: http.StatusUnprocessableEntity,
+			Body: TransRequestOutput{
+				Status:   val.Status,
+				Response: t.responseParams(in, val),
+				Errors:   domain.ParamErrors(schemaErrors),
+			},
+		}
+	}
 	// handle trans-proxy errors, database errors, or general reported errors by trans-proxy
 	if _, ok := val.Params["error"]; ok ||
 		val.Status == usecases.TransError ||
@@ -68,7 +394,8 @@ func (t *TransHandler) Execute(ig InputGetter) *goutils.Response {
 			Code: http.StatusBadRequest,
 			Body: TransRequestOutput{
 				Status:   val.Status,
-				Response: val.Params,
+				Response: t.responseParams(in, val),
+				Warnings: val.Warnings,
 			},
 		}
 		return response
@@ -89,12 +416,43 @@ func (t *TransHandler) Execute(ig InputGetter) *goutils.Response {
 		Code: http.StatusOK,
 		Body: TransRequestOutput{
 			Status:   val.Status,
-			Response: val.Params,
+			Response: t.responseParams(in, val),
+			Warnings: val.Warnings,
 		},
 	}
 	return response
 }
 
+// responseParams returns the params of the response in the requested format
+func (t *TransHandler) responseParams(in *TransHandlerInput, response domain.TransResponse) interface{} {
+	switch in.Format {
+	case ResponseFormatV2:
+		return OrderedTransFields(response.Fields)
+	case ResponseFormatGrouped:
+		return GroupedTransFields{
+			Fields:   response.Fields,
+			Grouping: t.Groupings[in.Command],
+		}
+	}
+	blobs := transBlobs(response.Fields)
+	if len(blobs) == 0 {
+		return response.Params
+	}
+	// the blobs are taken out of the flat params so their raw bytes are
+	// never encoded as text
+	params := make(map[string]interface{}, len(response.Params))
+	for key, value := range response.Params {
+		params[key] = value
+	}
+	for _, field := range response.Fields {
+		if field.IsBlob() {
+			delete(params, field.Key)
+		}
+	}
+	params[TransBlobsKey] = blobs
+	return params
+}
+
 func BuildCommand(input *TransHandlerInput) domain.TransCommand {
 	command := domain.TransCommand{
 		Command: input.Command,
@@ -113,7 +471,7 @@ func BuildCommand(input *TransHandlerInput) domain.TransCommand {
 						}
 						params = append(params, param)
 					}
-				} else if _, ok := val.(string); ok {
+				} else {
 					param := domain.TransParams{
 						Key:   key,
 						Value: val,
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/Yapo/goutils"
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/domain"
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/usecases"
+)
+
+// TransBatchHandler implements the handler interface and responds to
+// /batch requests, running several commands in a single call. Each command
+// gets the response it would have got from TransHandler, so a failing
+// command doesn't fail the batch. Expected response format:
+// { results: [{ code: int, body: json }] }
+type TransBatchHandler struct {
+	// Trans validates the token and builds the commands and their responses
+	Trans      *TransHandler
+	Interactor usecases.ExecuteTransBatchUsecase
+	// MaxCommands max number of commands of a batch, unlimited if not
+	// positive
+	MaxCommands int
+}
+
+// TransBatchHandlerInput struct that represents the input
+type TransBatchHandlerInput struct {
+	Token    string              `headers:"Authorization"`
+	Commands []TransBatchCommand `json:"commands"`
+	// Format of the response params of every command, ResponseFormatV1 if
+	// empty
+	Format string `query:"format"`
+	// DryRunHeader runs every command without committing it
+	DryRunHeader string `headers:"X-Dry-Run"`
+	Client       string `headers:"X-Client-Id"`
+	// CaptureHeader records the exchange with trans of every command
+	CaptureHeader string `headers:"X-Trans-Capture"`
+}
+
+// TransBatchCommand a command of the batch
+type TransBatchCommand struct {
+	Command string                 `json:"command"`
+	Params  map[string]interface{} `json:"params"`
+	DryRun  bool                   `json:"dry_run"`
+}
+
+// TransBatchOutput struct that represents the output
+type TransBatchOutput struct {
+	// Results the result of each command, in the order they were sent
+	Results []TransBatchResultOutput `json:"results"`
+}
+
+// TransBatchResultOutput the result of a single command: the status code
+// and body TransHandler would have responded with
+type TransBatchResultOutput struct {
+	Code int         `json:"code"`
+	Body interface{} `json:"body"`
+}
+
+// Input returns a fresh, empty instance of TransBatchHandlerInput
+func (t *TransBatchHandler) Input(ir InputRequest) HandlerInput {
+	input := TransBatchHandlerInput{}
+	ir.Set(&input).FromHeaders().FromJSONBody().FromQuery()
+	return &input
+}
+
+// Execute executes the commands of the batch and returns the result of
+// each one
+func (t *TransBatchHandler) Execute(ctx context.Context, ig InputGetter) *goutils.Response {
+	input, response := ig()
+	if response != nil {
+		return response
+	}
+	in := input.(*TransBatchHandlerInput)
+
+	// auth token validation
+	if err := t.Trans.TokenValidationInteractor.CleanAndMatchToken(in.Token); err != nil {
+		return &goutils.Response{
+			Code: http.StatusUnauthorized,
+			Body: &goutils.GenericError{
+				ErrorMessage: err.Error(),
+			},
+		}
+	}
+
+	if err := t.validate(in); err != nil {
+		return &goutils.Response{
+			Code: http.StatusBadRequest,
+			Body: &goutils.GenericError{
+				ErrorMessage: err.Error(),
+			},
+		}
+	}
+
+	inputs := make([]*TransHandlerInput, len(in.Commands))
+	commands := make([]domain.TransCommand, len(in.Commands))
+	for i, batchCommand := range in.Commands {
+		inputs[i] = &TransHandlerInput{
+			Command:       batchCommand.Command,
+			Params:        batchCommand.Params,
+			Format:        in.Format,
+			DryRun:        batchCommand.DryRun,
+			DryRunHeader:  in.DryRunHeader,
+			Client:        in.Client,
+			CaptureHeader: in.CaptureHeader,
+		}
+		command, response := t.Trans.command(inputs[i])
+		// the format, dry run and capture headers are shared, so they fail the batch
+		if response != nil {
+			return response
+		}
+		commands[i] = command
+	}
+
+	results := t.Interactor.ExecuteBatch(ctx, commands)
+	output := TransBatchOutput{
+		Results: make([]TransBatchResultOutput, len(results)),
+	}
+	for i, result := range results {
+		response := t.Trans.commandResponse(inputs[i], commands[i], result.Response, result.Err)
+		body := response.Body
+		if withHeaders, ok := body.(BodyWithHeaders); ok {
+			body = withHeaders.Body
+		}
+		output.Results[i] = TransBatchResultOutput{
+			Code: response.Code,
+			Body: body,
+		}
+	}
+	return &goutils.Response{
+		Code: http.StatusOK,
+		Body: output,
+	}
+}
+
+// validate tells if the batch can be executed
+func (t *TransBatchHandler) validate(in *TransBatchHandlerInput) error {
+	if len(in.Commands) == 0 {
+		return fmt.Errorf("the batch has no commands")
+	}
+	if t.MaxCommands > 0 && len(in.Commands) > t.MaxCommands {
+		return fmt.Errorf("the batch has %d commands, at most %d are allowed", len(in.Commands), t.MaxCommands)
+	}
+	for i, command := range in.Commands {
+		if command.Command == "" {
+			return fmt.Errorf("command %d has no name", i)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/Yapo/goutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/domain"
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/usecases"
+)
+
+type MockTransBatchInteractor struct {
+	mock.Mock
+}
+
+func (m *MockTransBatchInteractor) ExecuteBatch(ctx context.Context, commands []domain.TransCommand) []usecases.TransBatchResult {
+	ret := m.Called(commands)
+	return ret.Get(0).([]usecases.TransBatchResult)
+}
+
+func TestTransBatchHandlerInput(t *testing.T) {
+	mInputRequest := MockInputRequest{}
+	mTargetRequest := MockTargetRequest{}
+	mInputRequest.On("Set", mock.Anything).Return(&mTargetRequest)
+	mTargetRequest.On("FromHeaders").Return()
+	mTargetRequest.On("FromJSONBody").Return()
+	mTargetRequest.On("FromQuery").Return()
+
+	h := TransBatchHandler{}
+	input := h.Input(&mInputRequest)
+	var expected *TransBatchHandlerInput
+	assert.IsType(t, expected, input)
+}
+
+func TestTransBatchHandlerExecute(t *testing.T) {
+	m := MockTransBatchInteractor{}
+	commands := []domain.TransCommand{
+		{Command: "get_ad", Params: []domain.TransParams{{Key: "ad_id", Value: "1"}}},
+		{Command: "get_account", Params: []domain.TransParams{}},
+		{Command: "transinfo", Params: []domain.TransParams{}},
+	}
+	m.On("ExecuteBatch", commands).Return([]usecases.TransBatchResult{
+		{Response: domain.TransResponse{Status: usecases.TransOK, Params: map[string]string{"subject": "car"}}},
+		{Response: domain.TransResponse{}, Err: domain.ErrTransBusy},
+		{Response: domain.TransResponse{Status: usecases.TransError}, Err: errors.New("error during execution")},
+	}).Once()
+
+	mTokenVal := MockTokenValidator{}
+	mTokenVal.On("CleanAndMatchToken", "").Return(nil).Once()
+
+	h := TransBatchHandler{
+		Trans:      &TransHandler{TokenValidationInteractor: &mTokenVal},
+		Interactor: &m,
+	}
+	input := TransBatchHandlerInput{
+		Commands: []TransBatchCommand{
+			{Command: "get_ad", Params: map[string]interface{}{"ad_id": "1"}},
+			{Command: "get_account"},
+			{Command: "transinfo"},
+		},
+	}
+	getter := MakeMockInputTransGetter(&input, nil)
+	r := h.Execute(context.Background(), getter)
+	assert.Equal(t, http.StatusOK, r.Code)
+	encoded, err := json.Marshal(r.Body)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"results": [
+		{"code": 200, "body": {"status": "TRANS_OK", "response": {"subject": "car"}}},
+		{"code": 503, "body": {"ErrorMessage": "trans server is busy"}},
+		{"code": 400, "body": {"status": "TRANS_ERROR", "response": null}}
+	]}`, string(encoded))
+	m.AssertExpectations(t)
+	mTokenVal.AssertExpectations(t)
+}
+
+func TestTransBatchHandlerInvalidBatch(t *testing.T) {
+	inputs := map[string]TransBatchHandlerInput{
+		"the batch has no commands": {},
+		"the batch has 3 commands, at most 2 are allowed": {
+			Commands: []TransBatchCommand{{Command: "a"}, {Command: "b"}, {Command: "c"}},
+		},
+		"command 1 has no name": {
+			Commands: []TransBatchCommand{{Command: "a"}, {}},
+		},
+	}
+	for message, input := range inputs {
+		m := MockTransBatchInteractor{}
+		mTokenVal := MockTokenValidator{}
+		mTokenVal.On("CleanAndMatchToken", "").Return(nil).Once()
+		h := TransBatchHandler{
+			Trans:       &TransHandler{TokenValidationInteractor: &mTokenVal},
+			Interactor:  &m,
+			MaxCommands: 2,
+		}
+		in := input
+		getter := MakeMockInputTransGetter(&in, nil)
+		r := h.Execute(context.Background(), getter)
+		expectedResponse := &goutils.Response{
+			Code: http.StatusBadRequest,
+			Body: &goutils.GenericError{
+				ErrorMessage: message,
+			},
+		}
+		assert.Equal(t, expectedResponse, r)
+		m.AssertExpectations(t)
+		mTokenVal.AssertExpectations(t)
+	}
+}
+
+func TestTransBatchHandlerUnauthorized(t *testing.T) {
+	m := MockTransBatchInteractor{}
+	mTokenVal := MockTokenValidator{}
+	mTokenVal.On("CleanAndMatchToken", "bad").Return(errors.New("invalid token")).Once()
+	h := TransBatchHandler{
+		Trans:      &TransHandler{TokenValidationInteractor: &mTokenVal},
+		Interactor: &m,
+	}
+	input := TransBatchHandlerInput{Token: "bad", Commands: []TransBatchCommand{{Command: "a"}}}
+	getter := MakeMockInputTransGetter(&input, nil)
+	r := h.Execute(context.Background(), getter)
+	assert.Equal(t, http.StatusUnauthorized, r.Code)
+	m.AssertExpectations(t)
+	mTokenVal.AssertExpectations(t)
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/Yapo/goutils"
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/usecases"
+)
+
+// CaptureHeader header that asks to capture a command, its value must be
+// the capture token
+const CaptureHeader = "X-Trans-Capture"
+
+// CaptureEncodingBase64 encoding of the captures whose request or response
+// are not valid UTF-8, as they were written in a charset of trans
+const CaptureEncodingBase64 = "base64"
+
+// TransCapture is an exchange with trans as it was on the wire, with the
+// sensitive params masked
+type TransCapture struct {
+	Time    time.Time `json:"time"`
+	Command string    `json:"command"`
+	// Backend the address of the trans the command was sent to
+	Backend string `json:"backend"`
+	// Duration time from writing the command to reading its response
+	Duration string `json:"duration"`
+	// Request the text written to trans
+	Request string `json:"request"`
+	// Response the text read from trans, without the end line
+	Response string `json:"response"`
+	// Error why the exchange failed, if it did
+	Error string `json:"error,omitempty"`
+	// Encoding CaptureEncodingBase64 if the request and the response are
+	// encoded in base64, empty if they are written as they are
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// TransCaptureReader gives the captures kept in memory, the oldest first
+type TransCaptureReader interface {
+	Captures() []TransCapture
+}
+
+// TransCapturesHandler implements the handler interface and responds to
+// /admin/captures requests with the captured commands. Expected response
+// format: { captures: [TransCapture] }
+type TransCapturesHandler struct {
+	Captures                  TransCaptureReader
+	TokenValidationInteractor usecases.ValidateTokenInteractor
+}
+
+// TransCapturesHandlerInput struct that represents the input
+type TransCapturesHandlerInput struct {
+	Token string `headers:"Authorization"`
+	// Command only returns the captures of this command, if set
+	Command string `query:"command"`
+}
+
+// TransCapturesOutput struct that represents the output
+type TransCapturesOutput struct {
+	Captures []TransCapture `json:"captures"`
+}
+
+// Input returns a fresh, empty instance of TransCapturesHandlerInput
+func (t *TransCapturesHandler) Input(ir InputRequest) HandlerInput {
+	input := TransCapturesHandlerInput{}
+	ir.Set(&input).FromHeaders().FromQuery()
+	return &input
+}
+
+// Execute returns the captures kept in memory, the oldest first
+func (t *TransCapturesHandler) Execute(ctx context.Context, ig InputGetter) *goutils.Response {
+	input, response := ig()
+	if response != nil {
+		return response
+	}
+	in := input.(*TransCapturesHandlerInput)
+
+	if err := t.TokenValidationInteractor.CleanAndMatchToken(in.Token); err != nil {
+		return &goutils.Response{
+			Code: http.StatusUnauthorized,
+			Body: &goutils.GenericError{
+				ErrorMessage: err.Error(),
+			},
+		}
+	}
+
+	captures := make([]TransCapture, 0)
+	for _, capture := range t.Captures.Captures() {
+		if in.Command == "" || capture.Command == in.Command {
+			captures = append(captures, capture)
+		}
+	}
+	return &goutils.Response{
+		Code: http.StatusOK,
+		Body: TransCapturesOutput{Captures: captures},
+	}
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockTransCaptureReader struct {
+	mock.Mock
+}
+
+func (m *MockTransCaptureReader) Captures() []TransCapture {
+	ret := m.Called()
+	return ret.Get(0).([]TransCapture)
+}
+
+func TestTransCapturesHandlerInput(t *testing.T) {
+	mInputRequest := MockInputRequest{}
+	mTargetRequest := MockTargetRequest{}
+	mInputRequest.On("Set", mock.Anything).Return(&mTargetRequest)
+	mTargetRequest.On("FromHeaders").Return()
+	mTargetRequest.On("FromQuery").Return()
+
+	h := TransCapturesHandler{}
+	input := h.Input(&mInputRequest)
+	var expected *TransCapturesHandlerInput
+	assert.IsType(t, expected, input)
+}
+
+func TestTransCapturesHandlerExecute(t *testing.T) {
+	m := MockTransCaptureReader{}
+	m.On("Captures").Return([]TransCapture{
+		{Command: "get_ad", Request: "cmd:get_ad\ncommit:1\nend\n"},
+		{Command: "newad", Request: "cmd:newad\ncommit:1\nend\n"},
+	}).Twice()
+	mTokenVal := MockTokenValidator{}
+	mTokenVal.On("CleanAndMatchToken", "admin").Return(nil).Twice()
+	h := TransCapturesHandler{Captures: &m, TokenValidationInteractor: &mTokenVal}
+
+	input := TransCapturesHandlerInput{Token: "admin"}
+	r := h.Execute(context.Background(), MakeMockInputTransGetter(&input, nil))
+	assert.Equal(t, http.StatusOK, r.Code)
+	assert.Len(t, r.Body.(TransCapturesOutput).Captures, 2)
+
+	// only the captures of the command
+	input = TransCapturesHandlerInput{Token: "admin", Command: "newad"}
+	r = h.Execute(context.Background(), MakeMockInputTransGetter(&input, nil))
+	expected := TransCapturesOutput{Captures: []TransCapture{
+		{Command: "newad", Request: "cmd:newad\ncommit:1\nend\n"},
+	}}
+	assert.Equal(t, expected, r.Body)
+	m.AssertExpectations(t)
+	mTokenVal.AssertExpectations(t)
+}
+
+func TestTransCapturesHandlerUnauthorized(t *testing.T) {
+	m := MockTransCaptureReader{}
+	mTokenVal := MockTokenValidator{}
+	mTokenVal.On("CleanAndMatchToken", "").Return(errors.New("invalid token")).Once()
+	h := TransCapturesHandler{Captures: &m, TokenValidationInteractor: &mTokenVal}
+
+	input := TransCapturesHandlerInput{}
+	r := h.Execute(context.Background(), MakeMockInputTransGetter(&input, nil))
+	assert.Equal(t, http.StatusUnauthorized, r.Code)
+	m.AssertExpectations(t)
+	mTokenVal.AssertExpectations(t)
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/domain"
+)
+
+// DefaultGroupSeparator separator used to split the keys of the commands
+// that don't set one
+const DefaultGroupSeparator = "."
+
+// TransGrouping tells how the flat keys of a command response, like
+// ad.0.subject, are folded into nested objects
+type TransGrouping struct {
+	// Separator splits the keys into the path of the value,
+	// DefaultGroupSeparator if empty
+	Separator string `json:"separator"`
+	// Prefixes if set, only the keys whose first part is one of these are
+	// grouped, the rest are left flat
+	Prefixes []string `json:"prefixes"`
+}
+
+// separator returns the separator to use
+func (g TransGrouping) separator() string {
+	if g.Separator == "" {
+		return DefaultGroupSeparator
+	}
+	return g.Separator
+}
+
+// path splits a key into its parts, telling if it can be grouped. Keys
+// with empty parts, or filtered out by Prefixes, are left flat
+func (g TransGrouping) path(key string) ([]string, bool) {
+	path := strings.Split(key, g.separator())
+	if len(path) < 2 {
+		return nil, false
+	}
+	for _, part := range path {
+		if part == "" {
+			return nil, false
+		}
+	}
+	if len(g.Prefixes) == 0 {
+		return path, true
+	}
+	for _, prefix := range g.Prefixes {
+		if path[0] == prefix {
+			return path, true
+		}
+	}
+	return nil, false
+}
+
+// GroupedTransFields encodes the fields of a trans response as nested json
+// objects, splitting their keys with the grouping separator. Objects whose
+// keys are all indexes are encoded as arrays sorted by index. Keys
+// are kept in the order trans sent them and repeated ones are encoded
+// as an array, as OrderedTransFields does. A key that is also the prefix
+// of another, like ad and ad.0.subject, can't be both a value and an
+// object, so the longer one is left flat. Blobs are encoded apart, see
+// transBlobs
+type GroupedTransFields struct {
+	Fields   domain.TransFields
+	Grouping TransGrouping
+}
+
+// transGroupNode is a node of the tree built from the grouped keys. Leaves
+// have values, the rest have children
+type transGroupNode struct {
+	keys     []string
+	children map[string]*transGroupNode
+	values   []string
+}
+
+// child returns the child with the given key, adding it if missing
+func (n *transGroupNode) child(key string) *transGroupNode {
+	if n.children == nil {
+		n.children = make(map[string]*transGroupNode)
+	}
+	child, ok := n.children[key]
+	if !ok {
+		child = &transGroupNode{}
+		n.children[key] = child
+		n.keys = append(n.keys, key)
+	}
+	return child
+}
+
+// MarshalJSON encodes the fields as nested json objects
+func (g GroupedTransFields) MarshalJSON() ([]byte, error) {
+	flat := make(map[string]bool, len(g.Fields))
+	for _, field := range g.Fields {
+		if !field.IsBlob() {
+			flat[field.Key] = true
+		}
+	}
+	root := &transGroupNode{}
+	for _, field := range g.Fields {
+		if field.IsBlob() {
+			continue
+		}
+		path, ok := g.Grouping.path(field.Key)
+		for i := 1; ok && i < len(path); i++ {
+			ok = !flat[strings.Join(path[:i], g.Grouping.separator())]
+		}
+		if !ok {
+			path = []string{field.Key}
+		}
+		node := root
+		for _, part := range path {
+			node = node.child(part)
+		}
+		node.values = append(node.values, field.Value)
+	}
+	var buf bytes.Buffer
+	// the root is always an object, even when every key is an index
+	if err := root.encodeObject(&buf); err != nil {
+		return nil, err
+	}
+	return appendBlobs(buf.Bytes(), g.Fields)
+}
+
+// encode writes the node as json: a value, an array or an object
+func (n *transGroupNode) encode(buf *bytes.Buffer) error {
+	if n.children == nil {
+		var value interface{} = n.values
+		if len(n.values) == 1 {
+			value = n.values[0]
+		}
+		encoded, err := json.Marshal(value)
+		buf.Write(encoded)
+		return err
+	}
+	if indexes, ok := n.indexes(); ok {
+		buf.WriteByte('[')
+		for i, index := range indexes {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := n.children[index].encode(buf); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+		return nil
+	}
+	return n.encodeObject(buf)
+}
+
+// encodeObject writes the children of the node as a json object
+func (n *transGroupNode) encodeObject(buf *bytes.Buffer) error {
+	buf.WriteByte('{')
+	for i, key := range n.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		encodedKey, err := json.Marshal(key)
+		if err != nil {
+			return err
+		}
+		buf.Write(encodedKey)
+		buf.WriteByte(':')
+		if err := n.children[key].encode(buf); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+// indexes returns the keys of the children sorted by index, if all of
+// them are non negative integers
+func (n *transGroupNode) indexes() ([]string, bool) {
+	numbers := make(map[string]int, len(n.keys))
+	for _, key := range n.keys {
+		number, err := strconv.Atoi(key)
+		if err != nil || number < 0 || strconv.Itoa(number) != key {
+			return nil, false
+		}
+		numbers[key] = number
+	}
+	indexes := append([]string(nil), n.keys...)
+	sort.SliceStable(indexes, func(i, j int) bool {
+		return numbers[indexes[i]] < numbers[indexes[j]]
+	})
+	return indexes, true
+}
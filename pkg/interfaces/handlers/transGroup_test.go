@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/domain"
+)
+
+func TestGroupedTransFieldsMarshalJSON(t *testing.T) {
+	fields := GroupedTransFields{
+		Fields: domain.TransFields{
+			{Key: "total", Value: "2"},
+			{Key: "ad.1.subject", Value: "bike"},
+			{Key: "ad.1.image", Value: "1.jpg"},
+			{Key: "ad.1.image", Value: "2.jpg"},
+			{Key: "ad.0.subject", Value: "car"},
+			{Key: "ad.0.price", Value: "100"},
+			{Key: "user.name", Value: "edgar"},
+		},
+	}
+	body, err := json.Marshal(fields)
+	assert.NoError(t, err)
+	expected := `{"total":"2",` +
+		`"ad":[{"subject":"car","price":"100"},{"subject":"bike","image":["1.jpg","2.jpg"]}],` +
+		`"user":{"name":"edgar"}}`
+	assert.Equal(t, expected, string(body))
+}
+
+func TestGroupedTransFieldsSeparatorAndPrefixes(t *testing.T) {
+	fields := GroupedTransFields{
+		Fields: domain.TransFields{
+			{Key: "ad_0_subject", Value: "car"},
+			{Key: "list_id", Value: "1"},
+			{Key: "ad.0.price", Value: "100"},
+		},
+		Grouping: TransGrouping{
+			Separator: "_",
+			Prefixes:  []string{"ad"},
+		},
+	}
+	body, err := json.Marshal(fields)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"ad":[{"subject":"car"}],"list_id":"1","ad.0.price":"100"}`, string(body))
+}
+
+func TestGroupedTransFieldsConflicts(t *testing.T) {
+	fields := GroupedTransFields{
+		Fields: domain.TransFields{
+			{Key: "ad.0.subject", Value: "car"},
+			{Key: "ad", Value: "1"},
+			{Key: "0.name", Value: "first"},
+			{Key: "ad..price", Value: "100"},
+			{Key: "region.01", Value: "RM"},
+		},
+	}
+	body, err := json.Marshal(fields)
+	assert.NoError(t, err)
+	expected := `{"ad.0.subject":"car","ad":"1","0":{"name":"first"},` +
+		`"ad..price":"100","region":{"01":"RM"}}`
+	assert.Equal(t, expected, string(body))
+
+	body, err = json.Marshal(GroupedTransFields{})
+	assert.NoError(t, err)
+	assert.Equal(t, `{}`, string(body))
+}
+
+func TestGroupedTransFieldsBlobs(t *testing.T) {
+	fields := GroupedTransFields{
+		Fields: domain.TransFields{
+			{Key: "ad.0.image", Blob: []byte{0xff, 0x00}},
+			{Key: "ad.0.subject", Value: "car"},
+		},
+	}
+	body, err := json.Marshal(fields)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"ad":[{"subject":"car"}],"blobs":[{"ad.0.image":"/wA="}]}`, string(body))
+}
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/Yapo/goutils"
+)
+
+// TransMockExpectation is a response scripted on the trans mock for the
+// commands with its name and params
+type TransMockExpectation struct {
+	Command string `json:"command"`
+	// Params regular expressions the whole value of each param must match.
+	// Params not listed can have any value
+	Params map[string]string `json:"params,omitempty"`
+	// Response the text written to answer the command, without the end line
+	Response string `json:"response"`
+	// Latency time to wait before answering, as 100ms or 2s
+	Latency string `json:"latency,omitempty"`
+	// Disconnect closes the connection without answering the command
+	Disconnect bool `json:"disconnect,omitempty"`
+	// Times the expectation answers before the next one matching takes its
+	// place, zero for always
+	Times int `json:"times,omitempty"`
+}
+
+// TransMockFaults are the faults the trans mock injects in every command
+type TransMockFaults struct {
+	// Busy greets the new connections with 521 Busy
+	Busy bool `json:"busy"`
+	// Latency added to every command, as 100ms or 2s
+	Latency string `json:"latency,omitempty"`
+	// DisconnectRate chance, between 0 and 1, a command gets its connection
+	// closed without an answer
+	DisconnectRate float64 `json:"disconnect_rate,omitempty"`
+}
+
+// TransMockCall is a command received by the trans mock
+type TransMockCall struct {
+	Time    time.Time        `json:"time"`
+	Command string           `json:"command"`
+	Params  []TransMockParam `json:"params"`
+	// DryRun tells the command was sent with commit:0
+	DryRun bool `json:"dry_run,omitempty"`
+	// Expected tells an expectation answered the command
+	Expected bool `json:"expected"`
+}
+
+// TransMockParam is a param of a received command. The values of the blobs
+// are encoded in base64
+type TransMockParam struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Blob  bool   `json:"blob,omitempty"`
+}
+
+// TransMockControl scripts the trans mock and tells what it received
+type TransMockControl interface {
+	// Expect adds the expectations after the ones already set, the first
+	// one matching a command answers it
+	Expect([]TransMockExpectation) error
+	// SetFaults replaces the faults injected in every command
+	SetFaults(TransMockFaults) error
+	// Calls gives the commands received, the oldest first
+	Calls() []TransMockCall
+	// Reset removes the expectations, the faults and the calls
+	Reset()
+}
+
+// TransMockExpectHandler implements the handler interface and responds to
+// /expectations requests adding the expectations to the trans mock.
+// Expected response format: { expectations: int }
+type TransMockExpectHandler struct {
+	Mock TransMockControl
+}
+
+// TransMockExpectHandlerInput struct that represents the input
+type TransMockExpectHandlerInput struct {
+	Expectations []TransMockExpectation `json:"expectations"`
+}
+
+// TransMockExpectOutput struct that represents the output
+type TransMockExpectOutput struct {
+	// Expectations number of expectations added
+	Expectations int `json:"expectations"`
+}
+
+// Input returns a fresh, empty instance of TransMockExpectHandlerInput
+func (t *TransMockExpectHandler) Input(ir InputRequest) HandlerInput {
+	input := TransMockExpectHandlerInput{}
+	ir.Set(&input).FromJSONBody()
+	return &input
+}
+
+// Execute adds the expectations, or none if any of them is invalid
+func (t *TransMockExpectHandler) Execute(ctx context.Context, ig InputGetter) *goutils.Response {
+	input, response := ig()
+	if response != nil {
+		return response
+	}
+	in := input.(*TransMockExpectHandlerInput)
+	if err := t.Mock.Expect(in.Expectations); err != nil {
+		return &goutils.Response{
+			Code: http.StatusBadRequest,
+			Body: &goutils.GenericError{
+				ErrorMessage: err.Error(),
+			},
+		}
+	}
+	return &goutils.Response{
+		Code: http.StatusOK,
+		Body: TransMockExpectOutput{Expectations: len(in.Expectations)},
+	}
+}
+
+// TransMockFaultsHandler implements the handler interface and responds to
+// /faults requests replacing the faults of the trans mock. Expected
+// response format: TransMockFaults
+type TransMockFaultsHandler struct {
+	Mock TransMockControl
+}
+
+// Input returns a fresh, empty instance of TransMockFaults
+func (t *TransMockFaultsHandler) Input(ir InputRequest) HandlerInput {
+	input := TransMockFaults{}
+	ir.Set(&input).FromJSONBody()
+	return &input
+}
+
+// Execute replaces the faults, returning the ones set
+func (t *TransMockFaultsHandler) Execute(ctx context.Context, ig InputGetter) *goutils.Response {
+	input, response := ig()
+	if response != nil {
+		return response
+	}
+	in := input.(*TransMockFaults)
+	if err := t.Mock.SetFaults(*in); err != nil {
+		return &goutils.Response{
+			Code: http.StatusBadRequest,
+			Body: &goutils.GenericError{
+				ErrorMessage: err.Error(),
+			},
+		}
+	}
+	return &goutils.Response{
+		Code: http.StatusOK,
+		Body: *in,
+	}
+}
+
+// TransMockCallsHandler implements the handler interface and responds to
+// /calls requests with the commands received by the trans mock. Expected
+// response format: { calls: [TransMockCall] }
+type TransMockCallsHandler struct {
+	Mock TransMockControl
+}
+
+// TransMockCallsHandlerInput struct that represents the input
+type TransMockCallsHandlerInput struct {
+	// Command only returns the calls of this command, if set
+	Command string `query:"command"`
+}
+
+// TransMockCallsOutput struct that represents the output
+type TransMockCallsOutput struct {
+	Calls []TransMockCall `json:"calls"`
+}
+
+// Input returns a fresh, empty instance of TransMockCallsHandlerInput
+func (t *TransMockCallsHandler) Input(ir InputRequest) HandlerInput {
+	input := TransMockCallsHandlerInput{}
+	ir.Set(&input).FromQuery()
+	return &input
+}
+
+// Execute returns the commands received, the oldest first
+func (t *TransMockCallsHandler) Execute(ctx context.Context, ig InputGetter) *goutils.Response {
+	input, response := ig()
+	if response != nil {
+		return response
+	}
+	in := input.(*TransMockCallsHandlerInput)
+	calls := make([]TransMockCall, 0)
+	for _, call := range t.Mock.Calls() {
+		if in.Command == "" || call.Command == in.Command {
+			calls = append(calls, call)
+		}
+	}
+	return &goutils.Response{
+		Code: http.StatusOK,
+		Body: TransMockCallsOutput{Calls: calls},
+	}
+}
+
+// TransMockResetHandler implements the handler interface and responds to
+// /reset requests leaving the trans mock as it started. Expected response
+// format: { status: "OK" }
+type TransMockResetHandler struct {
+	Mock TransMockControl
+}
+
+// Input returns a fresh, empty instance of healthHandlerInput
+func (t *TransMockResetHandler) Input(ir InputRequest) HandlerInput {
+	return &healthHandlerInput{}
+}
+
+// Execute removes the expectations, the faults and the calls
+func (t *TransMockResetHandler) Execute(ctx context.Context, ig InputGetter) *goutils.Response {
+	t.Mock.Reset()
+	return &goutils.Response{
+		Code: http.StatusOK,
+		Body: healthRequestOutput{
+			Status: "OK",
+		},
+	}
+}
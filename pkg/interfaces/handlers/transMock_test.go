@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/Yapo/goutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockTransMockControl struct {
+	mock.Mock
+}
+
+func (m *MockTransMockControl) Expect(expectations []TransMockExpectation) error {
+	ret := m.Called(expectations)
+	return ret.Error(0)
+}
+
+func (m *MockTransMockControl) SetFaults(faults TransMockFaults) error {
+	ret := m.Called(faults)
+	return ret.Error(0)
+}
+
+func (m *MockTransMockControl) Calls() []TransMockCall {
+	ret := m.Called()
+	return ret.Get(0).([]TransMockCall)
+}
+
+func (m *MockTransMockControl) Reset() {
+	m.Called()
+}
+
+func TestTransMockHandlersInput(t *testing.T) {
+	mInputRequest := MockInputRequest{}
+	mTargetRequest := MockTargetRequest{}
+	mInputRequest.On("Set", mock.Anything).Return(&mTargetRequest)
+	mTargetRequest.On("FromJSONBody").Return().Twice()
+	mTargetRequest.On("FromQuery").Return().Once()
+
+	var expect *TransMockExpectHandlerInput
+	assert.IsType(t, expect, (&TransMockExpectHandler{}).Input(&mInputRequest))
+	var faults *TransMockFaults
+	assert.IsType(t, faults, (&TransMockFaultsHandler{}).Input(&mInputRequest))
+	var calls *TransMockCallsHandlerInput
+	assert.IsType(t, calls, (&TransMockCallsHandler{}).Input(&mInputRequest))
+	var reset *healthHandlerInput
+	assert.IsType(t, reset, (&TransMockResetHandler{}).Input(&mInputRequest))
+	mTargetRequest.AssertExpectations(t)
+}
+
+func TestTransMockExpectHandlerExecute(t *testing.T) {
+	expectations := []TransMockExpectation{
+		{Command: "get_ad", Params: map[string]string{"ad_id": "[0-9]+"}, Response: "status:TRANS_OK\n"},
+	}
+	m := MockTransMockControl{}
+	m.On("Expect", expectations).Return(nil).Once()
+	m.On("Expect", []TransMockExpectation(nil)).Return(errors.New("invalid")).Once()
+	h := TransMockExpectHandler{Mock: &m}
+
+	input := TransMockExpectHandlerInput{Expectations: expectations}
+	r := h.Execute(context.Background(), MakeMockInputTransGetter(&input, nil))
+	assert.Equal(t, &goutils.Response{Code: http.StatusOK, Body: TransMockExpectOutput{Expectations: 1}}, r)
+
+	input = TransMockExpectHandlerInput{}
+	r = h.Execute(context.Background(), MakeMockInputTransGetter(&input, nil))
+	assert.Equal(t, http.StatusBadRequest, r.Code)
+	assert.Equal(t, &goutils.GenericError{ErrorMessage: "invalid"}, r.Body)
+	m.AssertExpectations(t)
+}
+
+func TestTransMockFaultsHandlerExecute(t *testing.T) {
+	faults := TransMockFaults{Busy: true, Latency: "10ms"}
+	m := MockTransMockControl{}
+	m.On("SetFaults", faults).Return(nil).Once()
+	m.On("SetFaults", TransMockFaults{Latency: "soon"}).Return(errors.New(`invalid latency "soon"`)).Once()
+	h := TransMockFaultsHandler{Mock: &m}
+
+	r := h.Execute(context.Background(), MakeMockInputTransGetter(&faults, nil))
+	assert.Equal(t, &goutils.Response{Code: http.StatusOK, Body: faults}, r)
+
+	r = h.Execute(context.Background(), MakeMockInputTransGetter(&TransMockFaults{Latency: "soon"}, nil))
+	assert.Equal(t, http.StatusBadRequest, r.Code)
+	m.AssertExpectations(t)
+}
+
+func TestTransMockCallsHandlerExecute(t *testing.T) {
+	m := MockTransMockControl{}
+	m.On("Calls").Return([]TransMockCall{
+		{Command: "get_ad", Expected: true},
+		{Command: "newad"},
+	}).Twice()
+	h := TransMockCallsHandler{Mock: &m}
+
+	input := TransMockCallsHandlerInput{}
+	r := h.Execute(context.Background(), MakeMockInputTransGetter(&input, nil))
+	assert.Equal(t, http.StatusOK, r.Code)
+	assert.Len(t, r.Body.(TransMockCallsOutput).Calls, 2)
+
+	// only the calls of the command
+	input = TransMockCallsHandlerInput{Command: "newad"}
+	r = h.Execute(context.Background(), MakeMockInputTransGetter(&input, nil))
+	assert.Equal(t, TransMockCallsOutput{Calls: []TransMockCall{{Command: "newad"}}}, r.Body)
+	m.AssertExpectations(t)
+}
+
+func TestTransMockResetHandlerExecute(t *testing.T) {
+	m := MockTransMockControl{}
+	m.On("Reset").Once()
+	h := TransMockResetHandler{Mock: &m}
+
+	r := h.Execute(context.Background(), MakeMockInputTransGetter(&healthHandlerInput{}, nil))
+	assert.Equal(t, &goutils.Response{Code: http.StatusOK, Body: healthRequestOutput{Status: "OK"}}, r)
+	m.AssertExpectations(t)
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Yapo/goutils"
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/usecases"
+)
+
+// TransPipelineHandler implements the handler interface and responds to
+// /pipeline requests, running several commands in order where each one
+// may use the response params of the earlier ones. Expected response format:
+// { completed: int, total: int, stopped_at: int, steps: [{ name: string, code: int, body: json }] }
+type TransPipelineHandler struct {
+	// Trans validates the token and builds the commands and their responses
+	Trans      *TransHandler
+	Interactor usecases.ExecuteTransPipelineUsecase
+	// MaxSteps max number of steps of a pipeline, unlimited if not positive
+	MaxSteps int
+}
+
+// TransPipelineHandlerInput struct that represents the input
+type TransPipelineHandlerInput struct {
+	Token string              `headers:"Authorization"`
+	Steps []TransPipelineStep `json:"steps"`
+	// OnError what to do when a step fails: usecases.PipelineStop or
+	// usecases.PipelineContinue
+	OnError string `json:"on_error"`
+	// Format of the response params of every step, ResponseFormatV1 if
+	// empty
+	Format string `query:"format"`
+	// DryRunHeader runs every step without committing it
+	DryRunHeader string `headers:"X-Dry-Run"`
+	Client       string `headers:"X-Client-Id"`
+	// CaptureHeader records the exchange with trans of every step
+	CaptureHeader string `headers:"X-Trans-Capture"`
+}
+
+// TransPipelineStep a step of the pipeline. Its string params may reference
+// the response params of an earlier step as ${step.key}, where step is the
+// name or the index of that step
+type TransPipelineStep struct {
+	Name    string                 `json:"name"`
+	Command string                 `json:"command"`
+	Params  map[string]interface{} `json:"params"`
+	DryRun  bool                   `json:"dry_run"`
+	// OnError overrides the OnError policy of the pipeline for this step
+	OnError string `json:"on_error"`
+}
+
+// TransPipelineOutput struct that represents the output
+type TransPipelineOutput struct {
+	// Completed number of steps that succeeded
+	Completed int `json:"completed"`
+	// Total number of steps of the pipeline
+	Total int `json:"total"`
+	// StoppedAt index of the step that stopped the pipeline, if any
+	StoppedAt *int `json:"stopped_at,omitempty"`
+	// Steps the result of each step that was run, in order
+	Steps []TransPipelineStepOutput `json:"steps"`
+}
+
+// TransPipelineStepOutput the result of a single step: the status code and
+// body TransHandler would have responded with
+type TransPipelineStepOutput struct {
+	Name string      `json:"name,omitempty"`
+	Code int         `json:"code"`
+	Body interface{} `json:"body"`
+}
+
+// Input returns a fresh, empty instance of TransPipelineHandlerInput
+func (t *TransPipelineHandler) Input(ir InputRequest) HandlerInput {
+	input := TransPipelineHandlerInput{}
+	ir.Set(&input).FromHeaders().FromJSONBody().FromQuery()
+	return &input
+}
+
+// Execute runs the steps of the pipeline and reports how far it got
+func (t *TransPipelineHandler) Execute(ctx context.Context, ig InputGetter) *goutils.Response {
+	input, response := ig()
+	if response != nil {
+		return response
+	}
+	in := input.(*TransPipelineHandlerInput)
+
+	// auth token validation
+	if err := t.Trans.TokenValidationInteractor.CleanAndMatchToken(in.Token); err != nil {
+		return &goutils.Response{
+			Code: http.StatusUnauthorized,
+			Body: &goutils.GenericError{
+				ErrorMessage: err.Error(),
+			},
+		}
+	}
+
+	if err := t.validate(in); err != nil {
+		return &goutils.Response{
+			Code: http.StatusBadRequest,
+			Body: &goutils.GenericError{
+				ErrorMessage: err.Error(),
+			},
+		}
+	}
+
+	inputs := make([]*TransHandlerInput, len(in.Steps))
+	pipeline := usecases.TransPipeline{
+		Steps:   make([]usecases.TransPipelineStep, len(in.Steps)),
+		OnError: in.OnError,
+	}
+	for i, step := range in.Steps {
+		inputs[i] = &TransHandlerInput{
+			Command:       step.Command,
+			Params:        step.Params,
+			Format:        in.Format,
+			DryRun:        step.DryRun,
+			DryRunHeader:  in.DryRunHeader,
+			Client:        in.Client,
+			CaptureHeader: in.CaptureHeader,
+		}
+		command, response := t.Trans.command(inputs[i])
+		// the format, dry run and capture headers are shared, so they fail the pipeline
+		if response != nil {
+			return response
+		}
+		pipeline.Steps[i] = usecases.TransPipelineStep{
+			Name:    step.Name,
+			Command: command,
+			OnError: step.OnError,
+		}
+	}
+
+	result := t.Interactor.ExecutePipeline(ctx, pipeline)
+	output := TransPipelineOutput{
+		Completed: result.Completed,
+		Total:     len(in.Steps),
+		Steps:     make([]TransPipelineStepOutput, len(result.Steps)),
+	}
+	if result.StoppedAt >= 0 {
+		output.StoppedAt = &result.StoppedAt
+	}
+	for i, stepResult := range result.Steps {
+		step := pipeline.Steps[i]
+		response := t.Trans.commandResponse(inputs[i], step.Command, stepResult.Response, stepResult.Err)
+		body := response.Body
+		if withHeaders, ok := body.(BodyWithHeaders); ok {
+			body = withHeaders.Body
+		}
+		output.Steps[i] = TransPipelineStepOutput{
+			Name: step.Name,
+			Code: response.Code,
+			Body: body,
+		}
+	}
+	return &goutils.Response{
+		Code: http.StatusOK,
+		Body: output,
+	}
+}
+
+// validate tells if the pipeline can be executed
+func (t *TransPipelineHandler) validate(in *TransPipelineHandlerInput) error {
+	if len(in.Steps) == 0 {
+		return fmt.Errorf("the pipeline has no steps")
+	}
+	if t.MaxSteps > 0 && len(in.Steps) > t.MaxSteps {
+		return fmt.Errorf("the pipeline has %d steps, at most %d are allowed", len(in.Steps), t.MaxSteps)
+	}
+	if err := checkOnError(in.OnError); err != nil {
+		return err
+	}
+	names := make(map[string]bool, len(in.Steps))
+	for i, step := range in.Steps {
+		if step.Command == "" {
+			return fmt.Errorf("step %d has no command", i)
+		}
+		if err := checkOnError(step.OnError); err != nil {
+			return fmt.Errorf("step %d: %s", i, err)
+		}
+		if step.Name == "" {
+			continue
+		}
+		// numbers are the indexes of the steps
+		if _, err := strconv.Atoi(step.Name); err == nil {
+			return fmt.Errorf("step name %q can't be a number", step.Name)
+		}
+		if names[step.Name] {
+			return fmt.Errorf("step name %q is repeated", step.Name)
+		}
+		names[step.Name] = true
+	}
+	return nil
+}
+
+// checkOnError tells if onError is a known on error policy
+func checkOnError(onError string) error {
+	switch onError {
+	case "", usecases.PipelineStop, usecases.PipelineContinue:
+		return nil
+	}
+	return fmt.Errorf("unknown on_error policy %q", onError)
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/Yapo/goutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/domain"
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/usecases"
+)
+
+type MockTransPipelineInteractor struct {
+	mock.Mock
+}
+
+func (m *MockTransPipelineInteractor) ExecutePipeline(ctx context.Context, pipeline usecases.TransPipeline) usecases.TransPipelineResult {
+	ret := m.Called(pipeline)
+	return ret.Get(0).(usecases.TransPipelineResult)
+}
+
+func TestTransPipelineHandlerInput(t *testing.T) {
+	mInputRequest := MockInputRequest{}
+	mTargetRequest := MockTargetRequest{}
+	mInputRequest.On("Set", mock.Anything).Return(&mTargetRequest)
+	mTargetRequest.On("FromHeaders").Return()
+	mTargetRequest.On("FromJSONBody").Return()
+	mTargetRequest.On("FromQuery").Return()
+
+	h := TransPipelineHandler{}
+	input := h.Input(&mInputRequest)
+	var expected *TransPipelineHandlerInput
+	assert.IsType(t, expected, input)
+}
+
+func TestTransPipelineHandlerExecute(t *testing.T) {
+	m := MockTransPipelineInteractor{}
+	pipeline := usecases.TransPipeline{
+		OnError: usecases.PipelineStop,
+		Steps: []usecases.TransPipelineStep{
+			{
+				Name: "create",
+				Command: domain.TransCommand{
+					Command: "newad",
+					Params:  []domain.TransParams{{Key: "subject", Value: "car"}},
+				},
+			},
+			{
+				Command: domain.TransCommand{
+					Command: "add_image",
+					Params:  []domain.TransParams{{Key: "ad_id", Value: "${create.ad_id}"}},
+				},
+			},
+			{
+				Command: domain.TransCommand{Command: "transinfo", Params: []domain.TransParams{}},
+			},
+		},
+	}
+	paramErrors := domain.ParamErrors{{Key: "ad_id", Reason: "reference ${create.ad_id} not found in the earlier steps"}}
+	m.On("ExecutePipeline", pipeline).Return(usecases.TransPipelineResult{
+		Steps: []usecases.TransBatchResult{
+			{Response: domain.TransResponse{Status: usecases.TransOK, Params: map[string]string{"ad_id": "10"}}},
+			{
+				Response: domain.TransResponse{
+					Status: usecases.TransError,
+					Params: map[string]string{"error": paramErrors.Error()},
+				},
+				Err: paramErrors,
+			},
+		},
+		Completed: 1,
+		StoppedAt: 1,
+	}).Once()
+
+	mTokenVal := MockTokenValidator{}
+	mTokenVal.On("CleanAndMatchToken", "").Return(nil).Once()
+
+	h := TransPipelineHandler{
+		Trans:      &TransHandler{TokenValidationInteractor: &mTokenVal},
+		Interactor: &m,
+	}
+	input := TransPipelineHandlerInput{
+		OnError: usecases.PipelineStop,
+		Steps: []TransPipelineStep{
+			{Name: "create", Command: "newad", Params: map[string]interface{}{"subject": "car"}},
+			{Command: "add_image", Params: map[string]interface{}{"ad_id": "${create.ad_id}"}},
+			{Command: "transinfo"},
+		},
+	}
+	getter := MakeMockInputTransGetter(&input, nil)
+	r := h.Execute(context.Background(), getter)
+	assert.Equal(t, http.StatusOK, r.Code)
+	encoded, err := json.Marshal(r.Body)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"completed": 1,
+		"total": 3,
+		"stopped_at": 1,
+		"steps": [
+			{"name": "create", "code": 200, "body": {"status": "TRANS_OK", "response": {"ad_id": "10"}}},
+			{"code": 400, "body": {
+				"status": "TRANS_ERROR",
+				"response": {"error": "invalid params: param ad_id: reference ${create.ad_id} not found in the earlier steps"},
+				"errors": [{"key": "ad_id", "reason": "reference ${create.ad_id} not found in the earlier steps"}]
+			}}
+		]
+	}`, string(encoded))
+	m.AssertExpectations(t)
+	mTokenVal.AssertExpectations(t)
+}
+
+func TestTransPipelineHandlerInvalidPipeline(t *testing.T) {
+	inputs := map[string]TransPipelineHandlerInput{
+		"the pipeline has no steps": {},
+		"the pipeline has 3 steps, at most 2 are allowed": {
+			Steps: []TransPipelineStep{{Command: "a"}, {Command: "b"}, {Command: "c"}},
+		},
+		"step 1 has no command": {
+			Steps: []TransPipelineStep{{Command: "a"}, {}},
+		},
+		`unknown on_error policy "retry"`: {
+			OnError: "retry",
+			Steps:   []TransPipelineStep{{Command: "a"}},
+		},
+		`step 0: unknown on_error policy "ignore"`: {
+			Steps: []TransPipelineStep{{Command: "a", OnError: "ignore"}},
+		},
+		`step name "a" is repeated`: {
+			Steps: []TransPipelineStep{{Name: "a", Command: "a"}, {Name: "a", Command: "b"}},
+		},
+		`step name "1" can't be a number`: {
+			Steps: []TransPipelineStep{{Name: "1", Command: "a"}},
+		},
+	}
+	for message, input := range inputs {
+		m := MockTransPipelineInteractor{}
+		mTokenVal := MockTokenValidator{}
+		mTokenVal.On("CleanAndMatchToken", "").Return(nil).Once()
+		h := TransPipelineHandler{
+			Trans:      &TransHandler{TokenValidationInteractor: &mTokenVal},
+			Interactor: &m,
+			MaxSteps:   2,
+		}
+		in := input
+		getter := MakeMockInputTransGetter(&in, nil)
+		r := h.Execute(context.Background(), getter)
+		expectedResponse := &goutils.Response{
+			Code: http.StatusBadRequest,
+			Body: &goutils.GenericError{
+				ErrorMessage: message,
+			},
+		}
+		assert.Equal(t, expectedResponse, r)
+		m.AssertExpectations(t)
+		mTokenVal.AssertExpectations(t)
+	}
+}
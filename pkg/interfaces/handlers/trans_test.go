@@ -1,9 +1,13 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/Yapo/goutils"
 	"github.com/stretchr/testify/assert"
@@ -20,7 +24,7 @@ type MockTransInteractor struct {
 	mock.Mock
 }
 
-func (m *MockTransInteractor) ExecuteCommand(command domain.TransCommand) (domain.TransResponse, error) {
+func (m *MockTransInteractor) ExecuteCommand(ctx context.Context, command domain.TransCommand) (domain.TransResponse, error) {
 	ret := m.Called(command)
 	return ret.Get(0).(domain.TransResponse), ret.Error(1)
 }
@@ -48,6 +52,7 @@ func TestTransHandlerInput(t *testing.T) {
 	mTargetRequest.On("FromHeaders").Return()
 	mTargetRequest.On("FromPath").Return()
 	mTargetRequest.On("FromJSONBody").Return()
+	mTargetRequest.On("FromQuery").Return()
 
 	h := TransHandler{Interactor: &m}
 	input := h.Input(&mInputRequest)
@@ -76,12 +81,13 @@ func TestTransHandlerExecuteOK(t *testing.T) {
 	expectedResponse := &goutils.Response{
 		Code: http.StatusOK,
 		Body: TransRequestOutput{
-			Status: usecases.TransOK,
+			Status:   usecases.TransOK,
+			Response: map[string]string(nil),
 		},
 	}
 
 	getter := MakeMockInputTransGetter(&input, nil)
-	r := h.Execute(getter)
+	r := h.Execute(context.Background(), getter)
 	assert.Equal(t, expectedResponse, r)
 
 	m.AssertExpectations(t)
@@ -118,19 +124,20 @@ func TestTransHandlerParseInput(t *testing.T) {
 	h := TransHandler{Interactor: &m, TokenValidationInteractor: &mTokenVal}
 
 	requestOutput := TransRequestOutput{
-		Status:   usecases.TransOK,
-		Response: make(map[string]string),
+		Status: usecases.TransOK,
+		Response: map[string]string{
+			"account_id": "1",
+			"email":      fakeEmail,
+			"is_company": "true",
+		},
 	}
-	requestOutput.Response["account_id"] = "1"
-	requestOutput.Response["email"] = fakeEmail
-	requestOutput.Response["is_company"] = "true"
 	expectedResponse := &goutils.Response{
 		Code: http.StatusOK,
 		Body: requestOutput,
 	}
 
 	getter := MakeMockInputTransGetter(&input, nil)
-	r := h.Execute(getter)
+	r := h.Execute(context.Background(), getter)
 	assert.Equal(t, expectedResponse, r)
 
 	m.AssertExpectations(t)
@@ -155,12 +162,13 @@ func TestTransHandlerExecuteError(t *testing.T) {
 	expectedResponse := &goutils.Response{
 		Code: http.StatusBadRequest,
 		Body: TransRequestOutput{
-			Status: usecases.TransError,
+			Status:   usecases.TransError,
+			Response: map[string]string(nil),
 		},
 	}
 
 	getter := MakeMockInputTransGetter(&input, nil)
-	r := h.Execute(getter)
+	r := h.Execute(context.Background(), getter)
 	assert.Equal(t, expectedResponse, r)
 
 	m.AssertExpectations(t)
@@ -188,7 +196,7 @@ func TestTransHandlerExecuteInternalError(t *testing.T) {
 	}
 
 	getter := MakeMockInputTransGetter(&input, nil)
-	r := h.Execute(getter)
+	r := h.Execute(context.Background(), getter)
 	assert.Equal(t, expectedResponse, r)
 
 	m.AssertExpectations(t)
@@ -211,7 +219,7 @@ func TestTransHandlerExecuteUnauthorized(t *testing.T) {
 	}
 
 	getter := MakeMockInputTransGetter(&input, nil)
-	r := h.Execute(getter)
+	r := h.Execute(context.Background(), getter)
 	assert.Equal(t, expectedResponse, r)
 	mTokenVal.AssertExpectations(t)
 	m.AssertExpectations(t)
@@ -227,7 +235,7 @@ func TestTransHandlerInputError(t *testing.T) {
 	}
 
 	getter := MakeMockInputTransGetter(nil, expectedResponse)
-	r := h.Execute(getter)
+	r := h.Execute(context.Background(), getter)
 	assert.Equal(t, expectedResponse, r)
 
 	m.AssertExpectations(t)
@@ -268,3 +276,687 @@ func TestBuildCommand(t *testing.T) {
 
 	assert.Equal(t, command, r)
 }
+
+func TestTransHandlerExecuteBusy(t *testing.T) {
+	m := MockTransInteractor{}
+	input := TransHandlerInput{Command: "get_account"}
+	command := domain.TransCommand{
+		Command: "get_account",
+		Params:  make([]domain.TransParams, 0),
+	}
+	response := domain.TransResponse{
+		Status: usecases.TransBusy,
+		Params: map[string]string{"error": domain.ErrTransBusy.Error()},
+	}
+	m.On("ExecuteCommand", command).Return(response, domain.ErrTransBusy).Once()
+	mTokenVal := MockTokenValidator{}
+	mTokenVal.On("CleanAndMatchToken", "").Return(nil).Once()
+
+	h := TransHandler{
+		Interactor:                &m,
+		TokenValidationInteractor: &mTokenVal,
+		BusyRetryAfter:            1500 * time.Millisecond,
+	}
+
+	expectedResponse := &goutils.Response{
+		Code: http.StatusServiceUnavailable,
+		Body: BodyWithHeaders{
+			Body: &goutils.GenericError{
+				ErrorMessage: domain.ErrTransBusy.Error(),
+			},
+			Headers: map[string]string{"Retry-After": "2"},
+		},
+	}
+
+	getter := MakeMockInputTransGetter(&input, nil)
+	r := h.Execute(context.Background(), getter)
+	assert.Equal(t, expectedResponse, r)
+
+	m.AssertExpectations(t)
+}
+
+func TestTransHandlerExecuteUnavailable(t *testing.T) {
+	m := MockTransInteractor{}
+	input := TransHandlerInput{Command: "get_account"}
+	command := domain.TransCommand{
+		Command: "get_account",
+		Params:  make([]domain.TransParams, 0),
+	}
+	err := fmt.Errorf("circuit breaker is open: %w", domain.ErrTransUnavailable)
+	response := domain.TransResponse{
+		Status: usecases.TransError,
+		Params: map[string]string{"error": err.Error()},
+	}
+	m.On("ExecuteCommand", command).Return(response, err).Once()
+	mTokenVal := MockTokenValidator{}
+	mTokenVal.On("CleanAndMatchToken", "").Return(nil).Once()
+
+	h := TransHandler{
+		Interactor:                &m,
+		TokenValidationInteractor: &mTokenVal,
+		BusyRetryAfter:            time.Second,
+	}
+
+	// trans can't be reached, it's not the client fault
+	expectedResponse := &goutils.Response{
+		Code: http.StatusServiceUnavailable,
+		Body: BodyWithHeaders{
+			Body: &goutils.GenericError{
+				ErrorMessage: err.Error(),
+			},
+			Headers: map[string]string{"Retry-After": "1"},
+		},
+	}
+
+	getter := MakeMockInputTransGetter(&input, nil)
+	r := h.Execute(context.Background(), getter)
+	assert.Equal(t, expectedResponse, r)
+
+	m.AssertExpectations(t)
+}
+
+func TestTransHandlerExecuteTimeout(t *testing.T) {
+	m := MockTransInteractor{}
+	input := TransHandlerInput{Command: "get_account"}
+	command := domain.TransCommand{
+		Command: "get_account",
+		Params:  make([]domain.TransParams, 0),
+	}
+	err := domain.TimeoutError{Phase: "read", Timeout: 2 * time.Second}
+	response := domain.TransResponse{
+		Status: usecases.TransError,
+		Params: map[string]string{"error": err.Error()},
+	}
+	m.On("ExecuteCommand", command).Return(response, err).Once()
+	mTokenVal := MockTokenValidator{}
+	mTokenVal.On("CleanAndMatchToken", "").Return(nil).Once()
+
+	h := TransHandler{
+		Interactor:                &m,
+		TokenValidationInteractor: &mTokenVal,
+	}
+
+	expectedResponse := &goutils.Response{
+		Code: http.StatusGatewayTimeout,
+		Body: &goutils.GenericError{
+			ErrorMessage: "trans timeout in the read phase after 2s",
+		},
+	}
+
+	getter := MakeMockInputTransGetter(&input, nil)
+	r := h.Execute(context.Background(), getter)
+	assert.Equal(t, expectedResponse, r)
+
+	m.AssertExpectations(t)
+}
+
+func TestTransHandlerExecuteDeadlineExceeded(t *testing.T) {
+	m := MockTransInteractor{}
+	input := TransHandlerInput{Command: "get_account"}
+	command := domain.TransCommand{
+		Command: "get_account",
+		Params:  make([]domain.TransParams, 0),
+	}
+	m.On("ExecuteCommand", command).Return(domain.TransResponse{Status: usecases.TransError}, context.DeadlineExceeded).Once()
+	mTokenVal := MockTokenValidator{}
+	mTokenVal.On("CleanAndMatchToken", "").Return(nil).Once()
+
+	h := TransHandler{
+		Interactor:                &m,
+		TokenValidationInteractor: &mTokenVal,
+	}
+
+	expectedResponse := &goutils.Response{
+		Code: http.StatusGatewayTimeout,
+		Body: &goutils.GenericError{
+			ErrorMessage: "context deadline exceeded",
+		},
+	}
+
+	getter := MakeMockInputTransGetter(&input, nil)
+	r := h.Execute(context.Background(), getter)
+	assert.Equal(t, expectedResponse, r)
+
+	m.AssertExpectations(t)
+}
+
+func TestTransHandlerExecuteResponseLimit(t *testing.T) {
+	m := MockTransInteractor{}
+	input := TransHandlerInput{Command: "list_ads"}
+	command := domain.TransCommand{
+		Command: "list_ads",
+		Params:  make([]domain.TransParams, 0),
+	}
+	err := domain.ResponseLimitError{Limit: "response_size", Max: 1024}
+	m.On("ExecuteCommand", command).Return(domain.TransResponse{Status: usecases.TransError}, err).Once()
+	mTokenVal := MockTokenValidator{}
+	mTokenVal.On("CleanAndMatchToken", "").Return(nil).Once()
+
+	h := TransHandler{
+		Interactor:                &m,
+		TokenValidationInteractor: &mTokenVal,
+	}
+
+	expectedResponse := &goutils.Response{
+		Code: http.StatusBadGateway,
+		Body: &goutils.GenericError{
+			ErrorMessage: "trans response exceeds the response_size limit of 1024",
+		},
+	}
+
+	getter := MakeMockInputTransGetter(&input, nil)
+	r := h.Execute(context.Background(), getter)
+	assert.Equal(t, expectedResponse, r)
+
+	m.AssertExpectations(t)
+}
+
+func TestTransHandlerExecuteFormatV2(t *testing.T) {
+	m := MockTransInteractor{}
+	input := TransHandlerInput{Command: "list_ads", Format: ResponseFormatV2}
+	command := domain.TransCommand{
+		Command: "list_ads",
+		Params:  make([]domain.TransParams, 0),
+	}
+	fields := domain.TransFields{
+		{Key: "total", Value: "2"},
+		{Key: "ad_id", Value: "2"},
+		{Key: "ad_id", Value: "1"},
+	}
+	response := domain.TransResponse{
+		Status: usecases.TransOK,
+		Params: fields.Map(),
+		Fields: fields,
+	}
+	m.On("ExecuteCommand", command).Return(response, nil).Once()
+	mTokenVal := MockTokenValidator{}
+	mTokenVal.On("CleanAndMatchToken", "").Return(nil).Once()
+
+	h := TransHandler{Interactor: &m, TokenValidationInteractor: &mTokenVal}
+
+	expectedResponse := &goutils.Response{
+		Code: http.StatusOK,
+		Body: TransRequestOutput{
+			Status:   usecases.TransOK,
+			Response: OrderedTransFields(fields),
+		},
+	}
+
+	getter := MakeMockInputTransGetter(&input, nil)
+	r := h.Execute(context.Background(), getter)
+	assert.Equal(t, expectedResponse, r)
+	body, err := json.Marshal(r.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"status":"TRANS_OK","response":{"total":"2","ad_id":["2","1"]}}`, string(body))
+
+	m.AssertExpectations(t)
+}
+
+func TestTransHandlerExecuteUnknownFormat(t *testing.T) {
+	m := MockTransInteractor{}
+	input := TransHandlerInput{Command: "list_ads", Format: "v3"}
+	mTokenVal := MockTokenValidator{}
+	mTokenVal.On("CleanAndMatchToken", "").Return(nil).Once()
+
+	h := TransHandler{Interactor: &m, TokenValidationInteractor: &mTokenVal}
+
+	expectedResponse := &goutils.Response{
+		Code: http.StatusBadRequest,
+		Body: &goutils.GenericError{
+			ErrorMessage: `unknown response format "v3"`,
+		},
+	}
+
+	getter := MakeMockInputTransGetter(&input, nil)
+	r := h.Execute(context.Background(), getter)
+	assert.Equal(t, expectedResponse, r)
+
+	m.AssertExpectations(t)
+}
+
+func TestOrderedTransFieldsMarshalJSON(t *testing.T) {
+	fields := OrderedTransFields{
+		{Key: "b", Value: "1"},
+		{Key: "a", Value: "\"quoted\""},
+		{Key: "b", Value: "2"},
+	}
+	body, err := json.Marshal(fields)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"b":["1","2"],"a":"\"quoted\""}`, string(body))
+
+	body, err = json.Marshal(OrderedTransFields(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, `{}`, string(body))
+}
+
+func TestTransHandlerExecuteFormatGrouped(t *testing.T) {
+	m := MockTransInteractor{}
+	input := TransHandlerInput{Command: "list_ads", Format: ResponseFormatGrouped}
+	command := domain.TransCommand{
+		Command: "list_ads",
+		Params:  make([]domain.TransParams, 0),
+	}
+	fields := domain.TransFields{
+		{Key: "ad/0/subject", Value: "car"},
+		{Key: "ad/1/subject", Value: "bike"},
+	}
+	response := domain.TransResponse{
+		Status: usecases.TransOK,
+		Params: fields.Map(),
+		Fields: fields,
+	}
+	m.On("ExecuteCommand", command).Return(response, nil).Once()
+	mTokenVal := MockTokenValidator{}
+	mTokenVal.On("CleanAndMatchToken", "").Return(nil).Once()
+
+	h := TransHandler{
+		Interactor:                &m,
+		TokenValidationInteractor: &mTokenVal,
+		Groupings: map[string]TransGrouping{
+			"list_ads": {Separator: "/"},
+		},
+	}
+
+	getter := MakeMockInputTransGetter(&input, nil)
+	r := h.Execute(context.Background(), getter)
+	assert.Equal(t, http.StatusOK, r.Code)
+	body, err := json.Marshal(r.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"status":"TRANS_OK","response":{"ad":[{"subject":"car"},{"subject":"bike"}]}}`, string(body))
+
+	m.AssertExpectations(t)
+}
+
+func TestTransHandlerExecuteBlobs(t *testing.T) {
+	fields := domain.TransFields{
+		{Key: "image", Blob: []byte{0xff, 0x00}},
+		{Key: "subject", Value: "car"},
+		{Key: "image", Blob: []byte("edgar")},
+	}
+	response := domain.TransResponse{
+		Status: usecases.TransOK,
+		Params: fields.Map(),
+		Fields: fields,
+	}
+	cases := map[string]string{
+		ResponseFormatV1: `{"status":"TRANS_OK","response":` +
+			`{"blobs":[{"image":"/wA="},{"image":"ZWRnYXI="}],"subject":"car"}}`,
+		ResponseFormatV2: `{"status":"TRANS_OK","response":` +
+			`{"subject":"car","blobs":[{"image":"/wA="},{"image":"ZWRnYXI="}]}}`,
+	}
+	for format, expected := range cases {
+		m := MockTransInteractor{}
+		input := TransHandlerInput{Command: "get_ad", Format: format}
+		command := domain.TransCommand{
+			Command: "get_ad",
+			Params:  make([]domain.TransParams, 0),
+		}
+		m.On("ExecuteCommand", command).Return(response, nil).Once()
+		mTokenVal := MockTokenValidator{}
+		mTokenVal.On("CleanAndMatchToken", "").Return(nil).Once()
+
+		h := TransHandler{Interactor: &m, TokenValidationInteractor: &mTokenVal}
+		r := h.Execute(context.Background(), MakeMockInputTransGetter(&input, nil))
+		assert.Equal(t, http.StatusOK, r.Code)
+		body, err := json.Marshal(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(body), format)
+		m.AssertExpectations(t)
+	}
+}
+
+func TestOrderedTransFieldsOnlyBlobs(t *testing.T) {
+	body, err := json.Marshal(OrderedTransFields{{Key: "image", Blob: []byte{}}})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"blobs":[{"image":""}]}`, string(body))
+}
+
+func TestBuildCommandScalarArray(t *testing.T) {
+	input := TransHandlerInput{
+		Command: "get_ads",
+		Params: map[string]interface{}{
+			"ad_id": []interface{}{float64(1), true, nil},
+		},
+	}
+	command := domain.TransCommand{
+		Command: "get_ads",
+		Params: []domain.TransParams{
+			{Key: "ad_id", Value: float64(1)},
+			{Key: "ad_id", Value: true},
+			{Key: "ad_id", Value: nil},
+		},
+	}
+
+	r := BuildCommand(&input)
+
+	assert.Equal(t, command, r)
+}
+
+func TestTransHandlerExecuteInvalidParams(t *testing.T) {
+	m := MockTransInteractor{}
+	input := TransHandlerInput{
+		Command: "get_ad",
+		Params:  map[string]interface{}{"ad_id": map[string]interface{}{}},
+	}
+	command := BuildCommand(&input)
+	paramErrors := domain.ParamErrors{{Key: "ad_id", Reason: "unsupported type map[string]interface {}"}}
+	response := domain.TransResponse{
+		Status: usecases.TransError,
+		Params: map[string]string{"error": paramErrors.Error()},
+	}
+	m.On("ExecuteCommand", command).Return(response, paramErrors).Once()
+	mTokenVal := MockTokenValidator{}
+	mTokenVal.On("CleanAndMatchToken", "").Return(nil).Once()
+
+	h := TransHandler{Interactor: &m, TokenValidationInteractor: &mTokenVal}
+
+	expectedResponse := &goutils.Response{
+		Code: http.StatusBadRequest,
+		Body: TransRequestOutput{
+			Status:   usecases.TransError,
+			Response: response.Params,
+			Errors:   paramErrors,
+		},
+	}
+
+	r := h.Execute(context.Background(), MakeMockInputTransGetter(&input, nil))
+	assert.Equal(t, expectedResponse, r)
+	body, err := json.Marshal(r.Body)
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), `"errors":[{"key":"ad_id","reason":"unsupported type map[string]interface {}"}]`)
+	m.AssertExpectations(t)
+}
+
+func TestTransHandlerExecuteEncodingErrors(t *testing.T) {
+	m := MockTransInteractor{}
+	input := TransHandlerInput{
+		Command: "newad",
+		Params:  map[string]interface{}{"subject": "bike 🚲"},
+	}
+	command := BuildCommand(&input)
+	encodingErrors := domain.EncodingErrors{{Key: "subject", Reason: "character '🚲' can't be written in iso-8859-1"}}
+	response := domain.TransResponse{
+		Status: usecases.TransError,
+		Params: map[string]string{"error": encodingErrors.Error()},
+	}
+	m.On("ExecuteCommand", command).Return(response, encodingErrors).Once()
+	mTokenVal := MockTokenValidator{}
+	mTokenVal.On("CleanAndMatchToken", "").Return(nil).Once()
+
+	h := TransHandler{Interactor: &m, TokenValidationInteractor: &mTokenVal}
+
+	expectedResponse := &goutils.Response{
+		Code: http.StatusUnprocessableEntity,
+		Body: TransRequestOutput{
+			Status:   usecases.TransError,
+			Response: response.Params,
+			Errors:   domain.ParamErrors(encodingErrors),
+		},
+	}
+
+	r := h.Execute(context.Background(), MakeMockInputTransGetter(&input, nil))
+	assert.Equal(t, expectedResponse, r)
+	m.AssertExpectations(t)
+}
+
+func TestTransHandlerExecuteDeprecated(t *testing.T) {
+	m := MockTransInteractor{}
+	input := TransHandlerInput{Command: "ad@v1"}
+	command := BuildCommand(&input)
+	response := domain.TransResponse{
+		Status:     usecases.TransOK,
+		Params:     map[string]string{"ad_id": "1"},
+		Warnings:   []string{"command ad@v1 is deprecated"},
+		Deprecated: true,
+	}
+	m.On("ExecuteCommand", command).Return(response, nil).Once()
+	m.On("ExecuteCommand", command).Return(response, domain.ErrTransBusy).Once()
+	mTokenVal := MockTokenValidator{}
+	mTokenVal.On("CleanAndMatchToken", "").Return(nil).Twice()
+
+	h := TransHandler{Interactor: &m, TokenValidationInteractor: &mTokenVal, BusyRetryAfter: time.Second}
+
+	expectedResponse := &goutils.Response{
+		Code: http.StatusOK,
+		Body: BodyWithHeaders{
+			Body: TransRequestOutput{
+				Status:   usecases.TransOK,
+				Response: response.Params,
+				Warnings: response.Warnings,
+			},
+			Headers: map[string]string{DeprecationHeader: "true"},
+		},
+	}
+	r := h.Execute(context.Background(), MakeMockInputTransGetter(&input, nil))
+	assert.Equal(t, expectedResponse, r)
+
+	// the header is added to the ones of the response
+	expectedResponse = &goutils.Response{
+		Code: http.StatusServiceUnavailable,
+		Body: BodyWithHeaders{
+			Body: &goutils.GenericError{
+				ErrorMessage: domain.ErrTransBusy.Error(),
+			},
+			Headers: map[string]string{"Retry-After": "1", DeprecationHeader: "true"},
+		},
+	}
+	r = h.Execute(context.Background(), MakeMockInputTransGetter(&input, nil))
+	assert.Equal(t, expectedResponse, r)
+	m.AssertExpectations(t)
+}
+
+func TestTransHandlerExecuteSchemaErrors(t *testing.T) {
+	m := MockTransInteractor{}
+	input := TransHandlerInput{
+		Command: "get_ad",
+		Params:  map[string]interface{}{"ad_id": "one"},
+	}
+	command := BuildCommand(&input)
+	schemaErrors := domain.SchemaErrors{
+		{Key: "ad_id", Reason: "must be an integer"},
+		{Key: "lang", Reason: "required"},
+	}
+	response := domain.TransResponse{
+		Status: usecases.TransError,
+		Params: map[string]string{"error": schemaErrors.Error()},
+	}
+	m.On("ExecuteCommand", command).Return(response, schemaErrors).Once()
+	mTokenVal := MockTokenValidator{}
+	mTokenVal.On("CleanAndMatchToken", "").Return(nil).Once()
+
+	h := TransHandler{Interactor: &m, TokenValidationInteractor: &mTokenVal}
+
+	expectedResponse := &goutils.Response{
+		Code: http.StatusUnprocessableEntity,
+		Body: TransRequestOutput{
+			Status:   usecases.TransError,
+			Response: response.Params,
+			Errors:   domain.ParamErrors(schemaErrors),
+		},
+	}
+
+	r := h.Execute(context.Background(), MakeMockInputTransGetter(&input, nil))
+	assert.Equal(t, expectedResponse, r)
+	m.AssertExpectations(t)
+}
+
+func TestTransHandlerExecuteWarnings(t *testing.T) {
+	m := MockTransInteractor{}
+	input := TransHandlerInput{Command: "transinfo"}
+	command := BuildCommand(&input)
+	response := domain.TransResponse{
+		Status:   usecases.TransOK,
+		Params:   map[string]string{},
+		Warnings: []string{"response: 1 characters invalid in iso-8859-1 were replaced"},
+	}
+	m.On("ExecuteCommand", command).Return(response, nil).Once()
+	mTokenVal := MockTokenValidator{}
+	mTokenVal.On("CleanAndMatchToken", "").Return(nil).Once()
+
+	h := TransHandler{Interactor: &m, TokenValidationInteractor: &mTokenVal}
+
+	r := h.Execute(context.Background(), MakeMockInputTransGetter(&input, nil))
+	body, err := json.Marshal(r.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"status":"TRANS_OK","response":{},`+
+		`"warnings":["response: 1 characters invalid in iso-8859-1 were replaced"]}`, string(body))
+	m.AssertExpectations(t)
+}
+
+func TestTransHandlerExecuteDryRun(t *testing.T) {
+	inputs := []TransHandlerInput{
+		{Command: "newad", DryRun: true, Client: "backoffice"},
+		{Command: "newad", DryRunHeader: "true", Client: "backoffice"},
+	}
+	for _, input := range inputs {
+		m := MockTransInteractor{}
+		command := domain.TransCommand{
+			Command: "newad",
+			Params:  make([]domain.TransParams, 0),
+			DryRun:  true,
+			Client:  "backoffice",
+		}
+		response := domain.TransResponse{
+			Status: usecases.TransOK,
+		}
+		m.On("ExecuteCommand", command).Return(response, nil).Once()
+
+		mTokenVal := MockTokenValidator{}
+		mTokenVal.On("CleanAndMatchToken", "").Return(nil).Once()
+
+		h := TransHandler{Interactor: &m, TokenValidationInteractor: &mTokenVal}
+		in := input
+		getter := MakeMockInputTransGetter(&in, nil)
+		r := h.Execute(context.Background(), getter)
+		assert.Equal(t, http.StatusOK, r.Code)
+		encoded, err := json.Marshal(r.Body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"status":"TRANS_OK","response":null,"committed":false}`, string(encoded))
+		m.AssertExpectations(t)
+		mTokenVal.AssertExpectations(t)
+	}
+}
+
+func TestTransHandlerExecuteInvalidDryRunHeader(t *testing.T) {
+	m := MockTransInteractor{}
+	mTokenVal := MockTokenValidator{}
+	mTokenVal.On("CleanAndMatchToken", "").Return(nil).Once()
+
+	h := TransHandler{Interactor: &m, TokenValidationInteractor: &mTokenVal}
+	input := TransHandlerInput{Command: "newad", DryRunHeader: "maybe"}
+	getter := MakeMockInputTransGetter(&input, nil)
+	r := h.Execute(context.Background(), getter)
+	assert.Equal(t, http.StatusBadRequest, r.Code)
+	m.AssertExpectations(t)
+	mTokenVal.AssertExpectations(t)
+}
+
+func TestTransHandlerExecuteDryRunNotAllowed(t *testing.T) {
+	m := MockTransInteractor{}
+	input := TransHandlerInput{Command: "newad", DryRun: true}
+	command := domain.TransCommand{
+		Command: "newad",
+		Params:  make([]domain.TransParams, 0),
+		DryRun:  true,
+	}
+	m.On("ExecuteCommand", command).Return(domain.TransResponse{}, domain.ErrDryRunNotAllowed).Once()
+
+	mTokenVal := MockTokenValidator{}
+	mTokenVal.On("CleanAndMatchToken", "").Return(nil).Once()
+
+	h := TransHandler{Interactor: &m, TokenValidationInteractor: &mTokenVal}
+	getter := MakeMockInputTransGetter(&input, nil)
+	r := h.Execute(context.Background(), getter)
+	expectedResponse := &goutils.Response{
+		Code: http.StatusForbidden,
+		Body: DryRunError{
+			ErrorMessage: domain.ErrDryRunNotAllowed.Error(),
+		},
+	}
+	assert.Equal(t, expectedResponse, r)
+	encoded, err := json.Marshal(r.Body)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"ErrorMessage":"dry run is not allowed","committed":false}`, string(encoded))
+	m.AssertExpectations(t)
+	mTokenVal.AssertExpectations(t)
+}
+
+func TestTransHandlerExecuteDryRunFailed(t *testing.T) {
+	m := MockTransInteractor{}
+	input := TransHandlerInput{Command: "newad", DryRun: true, Client: "backoffice"}
+	command := domain.TransCommand{
+		Command: "newad",
+		Params:  make([]domain.TransParams, 0),
+		DryRun:  true,
+		Client:  "backoffice",
+	}
+	// trans refusing the command is what dry runs are mostly used for
+	response := domain.TransResponse{
+		Status: usecases.TransDatabaseError,
+		Params: map[string]string{"error": "duplicate email"},
+	}
+	m.On("ExecuteCommand", command).Return(response, errors.New("duplicate email")).Once()
+
+	mTokenVal := MockTokenValidator{}
+	mTokenVal.On("CleanAndMatchToken", "").Return(nil).Once()
+
+	h := TransHandler{Interactor: &m, TokenValidationInteractor: &mTokenVal}
+	getter := MakeMockInputTransGetter(&input, nil)
+	r := h.Execute(context.Background(), getter)
+	assert.Equal(t, http.StatusBadRequest, r.Code)
+	encoded, err := json.Marshal(r.Body)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"status":"TRANS_DATABASE_ERROR","response":{"error":"duplicate email"},"committed":false}`, string(encoded))
+	m.AssertExpectations(t)
+	mTokenVal.AssertExpectations(t)
+}
+
+func TestTransHandlerExecuteCapture(t *testing.T) {
+	m := MockTransInteractor{}
+	input := TransHandlerInput{Command: "get_ad", CaptureHeader: "capture-token"}
+	command := domain.TransCommand{
+		Command: "get_ad",
+		Params:  make([]domain.TransParams, 0),
+		Capture: true,
+	}
+	m.On("ExecuteCommand", command).Return(domain.TransResponse{Status: usecases.TransOK}, nil).Once()
+
+	mTokenVal := MockTokenValidator{}
+	mTokenVal.On("CleanAndMatchToken", "").Return(nil).Once()
+	mCaptureAuth := MockTokenValidator{}
+	mCaptureAuth.On("CleanAndMatchToken", "capture-token").Return(nil).Once()
+
+	h := TransHandler{Interactor: &m, TokenValidationInteractor: &mTokenVal, CaptureAuth: &mCaptureAuth}
+	getter := MakeMockInputTransGetter(&input, nil)
+	r := h.Execute(context.Background(), getter)
+	assert.Equal(t, http.StatusOK, r.Code)
+	m.AssertExpectations(t)
+	mTokenVal.AssertExpectations(t)
+	mCaptureAuth.AssertExpectations(t)
+}
+
+func TestTransHandlerExecuteCaptureForbidden(t *testing.T) {
+	mCaptureAuth := MockTokenValidator{}
+	mCaptureAuth.On("CleanAndMatchToken", "bad").Return(errors.New("invalid token")).Once()
+	// without CaptureAuth no capture can be asked
+	for _, captureAuth := range []usecases.ValidateTokenInteractor{nil, &mCaptureAuth} {
+		m := MockTransInteractor{}
+		mTokenVal := MockTokenValidator{}
+		mTokenVal.On("CleanAndMatchToken", "").Return(nil).Once()
+
+		h := TransHandler{Interactor: &m, TokenValidationInteractor: &mTokenVal, CaptureAuth: captureAuth}
+		input := TransHandlerInput{Command: "get_ad", CaptureHeader: "bad"}
+		getter := MakeMockInputTransGetter(&input, nil)
+		r := h.Execute(context.Background(), getter)
+		expectedResponse := &goutils.Response{
+			Code: http.StatusForbidden,
+			Body: &goutils.GenericError{
+				ErrorMessage: "invalid X-Trans-Capture header",
+			},
+		}
+		assert.Equal(t, expectedResponse, r)
+		m.AssertExpectations(t)
+		mTokenVal.AssertExpectations(t)
+	}
+	mCaptureAuth.AssertExpectations(t)
+}
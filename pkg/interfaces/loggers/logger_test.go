@@ -13,20 +13,20 @@ type loggerMock struct {
 }
 
 func (m *loggerMock) Debug(format string, params ...interface{}) {
-	fmt.Sprintf(format, params...) // nolint: vet,megacheck
+	_ = fmt.Sprintf(format, params...)
 }
 func (m *loggerMock) Info(format string, params ...interface{}) {
-	fmt.Sprintf(format, params...) // nolint: vet,megacheck
+	_ = fmt.Sprintf(format, params...)
 }
 func (m *loggerMock) Warn(format string, params ...interface{}) {
-	fmt.Sprintf(format, params...) // nolint: vet,megacheck
+	_ = fmt.Sprintf(format, params...)
 }
 func (m *loggerMock) Error(format string, params ...interface{}) {
-	fmt.Sprintf(format, params...) // nolint: vet,megacheck
+	_ = fmt.Sprintf(format, params...)
 }
 func (m *loggerMock) Crit(format string, params ...interface{}) {
-	fmt.Sprintf(format, params...) // nolint: vet,megacheck
+	_ = fmt.Sprintf(format, params...)
 }
 func (m *loggerMock) Success(format string, params ...interface{}) {
-	fmt.Sprintf(format, params...) // nolint: vet,megacheck
+	_ = fmt.Sprintf(format, params...)
 }
@@ -20,6 +20,42 @@ func (t *TransInteractorDefaultLogger) LogRepositoryError(command domain.TransCo
 	t.logger.Error("Error executing trans-proxy command %+v: %s", command, err)
 }
 
+// LogTransBusy logs that trans was too busy to execute a command
+func (t *TransInteractorDefaultLogger) LogTransBusy(command domain.TransCommand) {
+	t.logger.Warn("Trans busy executing trans-proxy command %+v", command)
+}
+
+// LogProtocolInjection logs a command rejected for trying to alter the
+// trans protocol. Being a warning, it's exported to prometheus as a
+// protocol_injection event. The input is quoted so it can't forge log lines
+func (t *TransInteractorDefaultLogger) LogProtocolInjection(command domain.TransCommand, err error) {
+	t.logger.Warn("Protocol injection attempt in trans-proxy command %q: %q", command.Command, err.Error())
+}
+
+// LogDryRunNotAllowed logs a dry run asked for a command, or by a client,
+// that can't use it
+func (t *TransInteractorDefaultLogger) LogDryRunNotAllowed(command domain.TransCommand) {
+	t.logger.Warn("Dry run not allowed for trans-proxy command %q by client %q", command.Command, command.Client)
+}
+
+// LogTransTimeout logs a command that timed out. Being a warning, it's
+// exported to prometheus as an event, the phase is in the log line
+func (t *TransInteractorDefaultLogger) LogTransTimeout(command domain.TransCommand, err domain.TimeoutError) {
+	t.logger.Warn("Timeout in the %s phase executing trans-proxy command %q after %s", err.Phase, command.Command, err.Timeout)
+}
+
+// LogTransResponseLimit logs a command whose response exceeded a limit.
+// Being an error, it's exported to prometheus as an event
+func (t *TransInteractorDefaultLogger) LogTransResponseLimit(command domain.TransCommand, err domain.ResponseLimitError) {
+	t.logger.Error("Response of trans-proxy command %q exceeds the %s limit of %d", command.Command, err.Limit, err.Max)
+}
+
+// LogDeprecatedAlias logs a command asked with a deprecated alias. Being a
+// warning, it's exported to prometheus as an event
+func (t *TransInteractorDefaultLogger) LogDeprecatedAlias(alias domain.CommandAlias, command domain.TransCommand) {
+	t.logger.Warn("Deprecated alias %q of trans-proxy command %q asked by client %q", alias.Name, alias.Command, command.Client)
+}
+
 // MakeTransInteractorLogger sets up a TransInteractorLogger instrumented
 // via the provided logger
 func MakeTransInteractorLogger(logger Logger) usecases.TransInteractorLogger {
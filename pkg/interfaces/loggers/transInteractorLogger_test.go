@@ -18,4 +18,10 @@ func TestTransInteractorDefaultLogger(t *testing.T) {
 	}
 	l.LogBadInput(input)
 	l.LogRepositoryError(input, nil)
+	l.LogTransBusy(input)
+	l.LogProtocolInjection(input, domain.ParamErrors{})
+	l.LogDryRunNotAllowed(input)
+	l.LogTransTimeout(input, domain.TimeoutError{Phase: "read"})
+	l.LogTransResponseLimit(input, domain.ResponseLimitError{Limit: "lines"})
+	l.LogDeprecatedAlias(domain.CommandAlias{Name: "ad@v1", Command: "get_ad"}, input)
 }
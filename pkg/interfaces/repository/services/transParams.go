@@ -0,0 +1,124 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/domain"
+)
+
+const (
+	// BoolsAsNumbers writes booleans as 1 and 0
+	BoolsAsNumbers = "numbers"
+	// BoolsAsWords writes booleans as true and false
+	BoolsAsWords = "words"
+	// NullsOmit leaves the null params out of the command
+	NullsOmit = "omit"
+	// NullsReject fails the command when a param is null
+	NullsReject = "reject"
+)
+
+// ParamsEncoding holds the rules used to write the param values, which
+// may be any json scalar, as trans text:
+//   - strings are written as they are, blobs must be base64 strings
+//   - integers are written without decimal point, other numbers with the
+//     shortest decimal representation and never with an exponent
+//   - booleans as 1/0 or true/false, see Bools
+//   - nulls are omitted or rejected, see Nulls
+//
+// Any other type, like objects, is rejected
+type ParamsEncoding struct {
+	// Bools BoolsAsNumbers or BoolsAsWords, BoolsAsNumbers if empty
+	Bools string
+	// Nulls NullsOmit or NullsReject, NullsOmit if empty
+	Nulls string
+}
+
+// Encode returns a copy of the params with their values as strings. If any
+// value can't be encoded the error is a domain.ParamErrors with all of them
+func (e ParamsEncoding) Encode(params []domain.TransParams) ([]domain.TransParams, error) {
+	encoded := make([]domain.TransParams, 0, len(params))
+	var errs domain.ParamErrors
+	for _, param := range params {
+		if param.Value == nil {
+			if e.Nulls == NullsReject {
+				errs = append(errs, domain.ParamError{Key: param.Key, Reason: "null values are not allowed"})
+			}
+			continue
+		}
+		value, err := e.encode(param)
+		if err != nil {
+			errs = append(errs, domain.ParamError{Key: param.Key, Reason: err.Error()})
+			continue
+		}
+		param.Value = value
+		encoded = append(encoded, param)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return encoded, nil
+}
+
+// encode returns the value of a not null param as a string
+func (e ParamsEncoding) encode(param domain.TransParams) (string, error) {
+	if param.Blob {
+		value, ok := param.Value.(string)
+		if !ok {
+			return "", fmt.Errorf("blobs must be base64 strings, got %T", param.Value)
+		}
+		if _, err := base64.StdEncoding.DecodeString(value); err != nil {
+			return "", fmt.Errorf("invalid base64 blob: %s", err)
+		}
+		return value, nil
+	}
+	switch value := param.Value.(type) {
+	case string:
+		return value, nil
+	case bool:
+		if e.Bools == BoolsAsWords {
+			return strconv.FormatBool(value), nil
+		}
+		if value {
+			return "1", nil
+		}
+		return "0", nil
+	case json.Number:
+		if integer, err := value.Int64(); err == nil {
+			return strconv.FormatInt(integer, 10), nil
+		}
+		number, err := value.Float64()
+		if err != nil {
+			return "", fmt.Errorf("invalid number %s", value)
+		}
+		return encodeFloat(number, 64)
+	case float32:
+		return encodeFloat(float64(value), 32)
+	case float64:
+		return encodeFloat(value, 64)
+	}
+	switch value := reflect.ValueOf(param.Value); value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(value.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(value.Uint(), 10), nil
+	}
+	return "", fmt.Errorf("unsupported type %T", param.Value)
+}
+
+// encodeFloat writes a number without exponent, so integers are written
+// without decimal point. bitSize is the size of the original float
+func encodeFloat(number float64, bitSize int) (string, error) {
+	if math.IsInf(number, 0) || math.IsNaN(number) {
+		return "", fmt.Errorf("invalid number %v", number)
+	}
+	if number == 0 {
+		// avoid writing -0
+		return "0", nil
+	}
+	return strconv.FormatFloat(number, 'f', -1, bitSize), nil
+}
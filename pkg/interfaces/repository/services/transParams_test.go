@@ -0,0 +1,83 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/domain"
+)
+
+func TestParamsEncodingEncode(t *testing.T) {
+	params := []domain.TransParams{
+		{Key: "name", Value: "edgar"},
+		{Key: "price", Value: float64(1980)},
+		{Key: "big", Value: float64(1e21)},
+		{Key: "ratio", Value: 0.25},
+		{Key: "small", Value: float32(0.1)},
+		{Key: "negative", Value: -3},
+		{Key: "zero", Value: float64(-0.0)},
+		{Key: "unsigned", Value: uint8(7)},
+		{Key: "number", Value: json.Number("12345678901234567890")},
+		{Key: "id", Value: json.Number("9007199254740993")},
+		{Key: "company", Value: true},
+		{Key: "private", Value: false},
+		{Key: "missing", Value: nil},
+		{Key: "body", Value: "ZWRnYXI=", Blob: true},
+	}
+	expected := []domain.TransParams{
+		{Key: "name", Value: "edgar"},
+		{Key: "price", Value: "1980"},
+		{Key: "big", Value: "1000000000000000000000"},
+		{Key: "ratio", Value: "0.25"},
+		{Key: "small", Value: "0.1"},
+		{Key: "negative", Value: "-3"},
+		{Key: "zero", Value: "0"},
+		{Key: "unsigned", Value: "7"},
+		{Key: "number", Value: "12345678901234567000"},
+		{Key: "id", Value: "9007199254740993"},
+		{Key: "company", Value: "1"},
+		{Key: "private", Value: "0"},
+		{Key: "body", Value: "ZWRnYXI=", Blob: true},
+	}
+	encoded, err := ParamsEncoding{}.Encode(params)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, encoded)
+	// the original params are not modified
+	assert.Equal(t, float64(1980), params[1].Value)
+}
+
+func TestParamsEncodingBoolsAsWords(t *testing.T) {
+	params := []domain.TransParams{
+		{Key: "company", Value: true},
+		{Key: "private", Value: false},
+	}
+	expected := []domain.TransParams{
+		{Key: "company", Value: "true"},
+		{Key: "private", Value: "false"},
+	}
+	encoded, err := ParamsEncoding{Bools: BoolsAsWords}.Encode(params)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, encoded)
+}
+
+func TestParamsEncodingErrors(t *testing.T) {
+	params := []domain.TransParams{
+		{Key: "name", Value: "edgar"},
+		{Key: "missing", Value: nil},
+		{Key: "ad", Value: map[string]interface{}{"id": "1"}},
+		{Key: "ids", Value: []interface{}{"1"}},
+		{Key: "body", Value: float64(1), Blob: true},
+		{Key: "image", Value: "not base64!", Blob: true},
+	}
+	expected := domain.ParamErrors{
+		{Key: "missing", Reason: "null values are not allowed"},
+		{Key: "ad", Reason: "unsupported type map[string]interface {}"},
+		{Key: "ids", Reason: "unsupported type []interface {}"},
+		{Key: "body", Reason: "blobs must be base64 strings, got float64"},
+		{Key: "image", Reason: "invalid base64 blob: illegal base64 data at input byte 3"},
+	}
+	encoded, err := ParamsEncoding{Nulls: NullsReject}.Encode(params)
+	assert.Nil(t, encoded)
+	assert.Equal(t, expected, err)
+}
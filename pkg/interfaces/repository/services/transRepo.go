@@ -1,15 +1,24 @@
 package services
 
 import (
-	"reflect"
-	"strconv"
-
+	"context"
 	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/domain"
 )
 
+// TransReply is what trans answered to a command
+type TransReply struct {
+	// Fields the key-value pairs of the response, in order
+	Fields domain.TransFields
+	// Warnings things that went wrong without failing the command
+	Warnings []string
+}
+
 // TransHandler is an interface to use Trans functions
 type TransHandler interface {
-	SendCommand(string, []domain.TransParams) (map[string]string, error)
+	// SendCommand sends the command, with its params already written as
+	// strings, and returns what trans answered. The connection is closed
+	// as soon as ctx is done
+	SendCommand(context.Context, domain.TransCommand) (TransReply, error)
 }
 
 // TransFactory is an interface that abstracts the Factory Pattern for creating TransHandler objects
@@ -20,41 +29,49 @@ type TransFactory interface {
 // TransRepo struct definition
 type TransRepo struct {
 	transFactory TransFactory
+	encoding     ParamsEncoding
 }
 
-// NewTransRepo instance TransRepo and set handler
-func NewTransRepo(transFactory TransFactory) *TransRepo {
+// NewTransRepo instance TransRepo and set handler. The params of the
+// commands are written following the rules of encoding
+func NewTransRepo(transFactory TransFactory, encoding ParamsEncoding) *TransRepo {
 	return &TransRepo{
 		transFactory: transFactory,
+		encoding:     encoding,
 	}
 }
 
 // Execute executes the specified trans command
-func (repo *TransRepo) Execute(command domain.TransCommand) (domain.TransResponse, error) {
+func (repo *TransRepo) Execute(ctx context.Context, command domain.TransCommand) (domain.TransResponse, error) {
 	response := domain.TransResponse{
 		Params: make(map[string]string),
 	}
-	resp, err := repo.transaction(command.Command, command.Params)
+	reply, err := repo.transaction(ctx, command)
 	if err != nil {
 		response.Params["error"] = err.Error()
+		response.Fields = domain.TransFields{{Key: "error", Value: err.Error()}}
 		return response, err
 	}
-	if status, ok := resp["status"]; ok {
-		response.Status = status
-		delete(resp, "status")
+	response.Warnings = reply.Warnings
+	for _, field := range reply.Fields {
+		if field.Key == "status" && response.Status == "" {
+			response.Status = field.Value
+			continue
+		}
+		response.Fields = append(response.Fields, field)
 	}
-	for key, val := range resp {
-		response.Params[key] = val
+	for key, value := range response.Fields.Map() {
+		response.Params[key] = value
 	}
 	return response, nil
 }
 
-func (repo *TransRepo) transaction(method string, transParams []domain.TransParams) (map[string]string, error) {
-	trans := repo.transFactory.MakeTransHandler()
-	for _, transParam := range transParams {
-		if reflect.TypeOf(transParam.Value).Kind() == reflect.Int {
-			transParam.Value = strconv.Itoa(transParam.Value.(int))
-		}
+func (repo *TransRepo) transaction(ctx context.Context, command domain.TransCommand) (TransReply, error) {
+	params, err := repo.encoding.Encode(command.Params)
+	if err != nil {
+		return TransReply{}, err
 	}
-	return trans.SendCommand(method, transParams)
+	command.Params = params
+	trans := repo.transFactory.MakeTransHandler()
+	return trans.SendCommand(ctx, command)
 }
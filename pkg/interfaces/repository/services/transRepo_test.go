@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"errors"
 	"testing"
 
@@ -19,9 +20,9 @@ type MockTransHandler struct {
 	mock.Mock
 }
 
-func (m *MockTransHandler) SendCommand(command string, params []domain.TransParams) (map[string]string, error) {
-	ret := m.Called(command, params)
-	return ret.Get(0).(map[string]string), ret.Error(1)
+func (m *MockTransHandler) SendCommand(ctx context.Context, command domain.TransCommand) (TransReply, error) {
+	ret := m.Called(command.Command, command.Params)
+	return ret.Get(0).(TransReply), ret.Error(1)
 }
 
 type MockTransFactory struct {
@@ -35,7 +36,7 @@ func (m *MockTransFactory) MakeTransHandler() TransHandler {
 
 func TestNewTransRepo(t *testing.T) {
 	factory := MockTransFactory{}
-	repo := NewTransRepo(&factory)
+	repo := NewTransRepo(&factory, ParamsEncoding{})
 
 	expectedRepo := &TransRepo{
 		transFactory: &factory,
@@ -48,7 +49,7 @@ func TestExecuteError(t *testing.T) {
 	cmd := command1
 	params := []domain.TransParams{}
 	expectedErr := errors.New("trans error")
-	responseParams := make(map[string]string)
+	var responseParams domain.TransFields
 
 	command := domain.TransCommand{
 		Command: cmd,
@@ -56,18 +57,19 @@ func TestExecuteError(t *testing.T) {
 	}
 
 	handler := MockTransHandler{}
-	handler.On("SendCommand", cmd, params).Return(responseParams, expectedErr).Once()
+	handler.On("SendCommand", cmd, params).Return(TransReply{Fields: responseParams}, expectedErr).Once()
 
 	factory := MockTransFactory{}
 	factory.On("MakeTransHandler").Return(&handler)
 
-	repo := NewTransRepo(&factory)
+	repo := NewTransRepo(&factory, ParamsEncoding{})
 
-	response, err := repo.Execute(command)
+	response, err := repo.Execute(context.Background(), command)
 	expectedResponse := domain.TransResponse{
 		Params: make(map[string]string),
 	}
 	expectedResponse.Params["error"] = "trans error"
+	expectedResponse.Fields = domain.TransFields{{Key: "error", Value: "trans error"}}
 	assert.Equal(t, expectedErr, err)
 	assert.Equal(t, expectedResponse, response)
 	factory.AssertExpectations(t)
@@ -81,28 +83,30 @@ func TestExecuteOK(t *testing.T) {
 		{Key: "param 2", Value: "value 2"},
 	}
 
-	responseParams := make(map[string]string)
-	responseParams["status"] = usecases.TransOK
-	responseParams[response1] = response1
+	responseParams := domain.TransFields{
+		{Key: "status", Value: usecases.TransOK},
+		{Key: response1, Value: response1},
+	}
 	command := domain.TransCommand{
 		Command: cmd,
 		Params:  params,
 	}
 
 	handler := MockTransHandler{}
-	handler.On("SendCommand", cmd, params).Return(responseParams, nil).Once()
+	handler.On("SendCommand", cmd, params).Return(TransReply{Fields: responseParams}, nil).Once()
 
 	factory := MockTransFactory{}
 	factory.On("MakeTransHandler").Return(&handler).Once()
 
-	repo := NewTransRepo(&factory)
+	repo := NewTransRepo(&factory, ParamsEncoding{})
 
-	response, err := repo.Execute(command)
+	response, err := repo.Execute(context.Background(), command)
 	expectedResponse := domain.TransResponse{
 		Status: usecases.TransOK,
 		Params: make(map[string]string),
 	}
 	expectedResponse.Params[response1] = response1
+	expectedResponse.Fields = domain.TransFields{{Key: response1, Value: response1}}
 	assert.NoError(t, err)
 	assert.Equal(t, expectedResponse, response)
 	factory.AssertExpectations(t)
@@ -112,12 +116,13 @@ func TestExecuteOK(t *testing.T) {
 func TestExecuteOKNumbers(t *testing.T) {
 	cmd := command1
 	params := []domain.TransParams{
-		{Key: "param 1", Value: 1980},
+		{Key: "param 1", Value: "1980"},
 	}
 
-	responseParams := make(map[string]string)
-	responseParams["status"] = usecases.TransOK
-	responseParams[response1] = response1
+	responseParams := domain.TransFields{
+		{Key: "status", Value: usecases.TransOK},
+		{Key: response1, Value: response1},
+	}
 	command := domain.TransCommand{
 		Command: cmd,
 		Params:  make([]domain.TransParams, 0),
@@ -131,21 +136,112 @@ func TestExecuteOKNumbers(t *testing.T) {
 	)
 
 	handler := MockTransHandler{}
-	handler.On("SendCommand", cmd, params).Return(responseParams, nil).Once()
+	handler.On("SendCommand", cmd, params).Return(TransReply{Fields: responseParams}, nil).Once()
 
 	factory := MockTransFactory{}
 	factory.On("MakeTransHandler").Return(&handler).Once()
 
-	repo := NewTransRepo(&factory)
+	repo := NewTransRepo(&factory, ParamsEncoding{})
 
-	response, err := repo.Execute(command)
+	response, err := repo.Execute(context.Background(), command)
 	expectedResponse := domain.TransResponse{
 		Status: usecases.TransOK,
 		Params: make(map[string]string),
 	}
 	expectedResponse.Params[response1] = response1
+	expectedResponse.Fields = domain.TransFields{{Key: response1, Value: response1}}
 	assert.NoError(t, err)
 	assert.Equal(t, expectedResponse, response)
 	factory.AssertExpectations(t)
 	handler.AssertExpectations(t)
 }
+
+func TestExecuteBusy(t *testing.T) {
+	command := domain.TransCommand{
+		Command: command1,
+		Params:  make([]domain.TransParams, 0),
+	}
+
+	handler := MockTransHandler{}
+	handler.On("SendCommand", command1, command.Params).Return(TransReply{}, domain.ErrTransBusy).Once()
+
+	factory := MockTransFactory{}
+	factory.On("MakeTransHandler").Return(&handler).Once()
+
+	repo := NewTransRepo(&factory, ParamsEncoding{})
+
+	response, err := repo.Execute(context.Background(), command)
+	assert.Equal(t, domain.ErrTransBusy, err)
+	assert.Equal(t, domain.ErrTransBusy.Error(), response.Params["error"])
+	factory.AssertExpectations(t)
+	handler.AssertExpectations(t)
+}
+
+func TestExecuteRepeatedKeys(t *testing.T) {
+	command := domain.TransCommand{
+		Command: command1,
+		Params:  make([]domain.TransParams, 0),
+	}
+	responseParams := domain.TransFields{
+		{Key: "ad_id", Value: "2"},
+		{Key: "status", Value: usecases.TransOK},
+		{Key: "ad_id", Value: "1"},
+	}
+	handler := MockTransHandler{}
+	handler.On("SendCommand", command1, command.Params).Return(TransReply{Fields: responseParams}, nil).Once()
+	factory := MockTransFactory{}
+	factory.On("MakeTransHandler").Return(&handler).Once()
+	repo := NewTransRepo(&factory, ParamsEncoding{})
+
+	response, err := repo.Execute(context.Background(), command)
+	expectedResponse := domain.TransResponse{
+		Status: usecases.TransOK,
+		Params: map[string]string{"ad_id": "1"},
+		Fields: domain.TransFields{
+			{Key: "ad_id", Value: "2"},
+			{Key: "ad_id", Value: "1"},
+		},
+	}
+	assert.NoError(t, err)
+	assert.Equal(t, expectedResponse, response)
+	factory.AssertExpectations(t)
+	handler.AssertExpectations(t)
+}
+
+func TestExecuteInvalidParams(t *testing.T) {
+	command := domain.TransCommand{
+		Command: command1,
+		Params:  []domain.TransParams{{Key: "param 1", Value: nil}},
+	}
+	factory := MockTransFactory{}
+	repo := NewTransRepo(&factory, ParamsEncoding{Nulls: NullsReject})
+
+	response, err := repo.Execute(context.Background(), command)
+	expectedErr := domain.ParamErrors{{Key: "param 1", Reason: "null values are not allowed"}}
+	assert.Equal(t, expectedErr, err)
+	assert.Equal(t, expectedErr.Error(), response.Params["error"])
+	factory.AssertExpectations(t)
+}
+
+func TestExecuteWarnings(t *testing.T) {
+	command := domain.TransCommand{
+		Command: command1,
+		Params:  make([]domain.TransParams, 0),
+	}
+	reply := TransReply{
+		Fields:   domain.TransFields{{Key: "status", Value: usecases.TransOK}},
+		Warnings: []string{"param 1 was replaced"},
+	}
+	handler := MockTransHandler{}
+	handler.On("SendCommand", command1, command.Params).Return(reply, nil).Once()
+	factory := MockTransFactory{}
+	factory.On("MakeTransHandler").Return(&handler).Once()
+	repo := NewTransRepo(&factory, ParamsEncoding{})
+
+	response, err := repo.Execute(context.Background(), command)
+	assert.NoError(t, err)
+	assert.Equal(t, usecases.TransOK, response.Status)
+	assert.Equal(t, reply.Warnings, response.Warnings)
+	factory.AssertExpectations(t)
+	handler.AssertExpectations(t)
+}
@@ -0,0 +1,69 @@
+package usecases
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/domain"
+)
+
+// ExecuteTransBatchUsecase states:
+// As a User, I would like to execute several TransCommands at once and get
+// the response of each one, even if some of them fail
+type ExecuteTransBatchUsecase interface {
+	ExecuteBatch(ctx context.Context, commands []domain.TransCommand) []TransBatchResult
+}
+
+// TransBatchResult is the outcome of a single command of a batch
+type TransBatchResult struct {
+	Response domain.TransResponse
+	Err      error
+}
+
+// TransBatchInteractor implements ExecuteTransBatchUsecase by running the
+// commands concurrently through Interactor
+type TransBatchInteractor struct {
+	Interactor ExecuteTransUsecase
+	// Parallelism max number of commands of a batch run at the same time,
+	// 1 if not positive
+	Parallelism int
+}
+
+// ExecuteBatch executes the given commands and returns their results in
+// the same order. A failing command doesn't stop the rest
+func (interactor TransBatchInteractor) ExecuteBatch(ctx context.Context, commands []domain.TransCommand) []TransBatchResult {
+	parallelism := interactor.Parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	results := make([]TransBatchResult, len(commands))
+	slots := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i := range commands {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			results[i] = interactor.execute(ctx, commands[i])
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
+
+// execute runs a single command, turning a panic into its error so it
+// doesn't take the whole batch down
+func (interactor TransBatchInteractor) execute(ctx context.Context, command domain.TransCommand) (result TransBatchResult) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = TransBatchResult{
+				Response: domain.TransResponse{Status: TransError},
+				Err:      fmt.Errorf("error during execution: %v", r),
+			}
+		}
+	}()
+	response, err := interactor.Interactor.ExecuteCommand(ctx, command)
+	return TransBatchResult{Response: response, Err: err}
+}
@@ -0,0 +1,87 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/domain"
+)
+
+type MockTransUsecase struct {
+	mock.Mock
+}
+
+func (m *MockTransUsecase) ExecuteCommand(ctx context.Context, command domain.TransCommand) (domain.TransResponse, error) {
+	ret := m.Called(command)
+	return ret.Get(0).(domain.TransResponse), ret.Error(1)
+}
+
+func TestTransBatchInteractorPartialFailure(t *testing.T) {
+	commands := []domain.TransCommand{
+		{Command: "get_ad"},
+		{Command: "get_account"},
+		{Command: "transinfo"},
+	}
+	err := errors.New("error during execution")
+	m := &MockTransUsecase{}
+	m.On("ExecuteCommand", commands[0]).Return(domain.TransResponse{Status: TransOK}, nil).Once()
+	m.On("ExecuteCommand", commands[1]).Return(domain.TransResponse{Status: TransError}, err).Once()
+	m.On("ExecuteCommand", commands[2]).Return(domain.TransResponse{Status: TransOK}, nil).Once()
+	interactor := TransBatchInteractor{Interactor: m, Parallelism: 2}
+
+	results := interactor.ExecuteBatch(context.Background(), commands)
+	expected := []TransBatchResult{
+		{Response: domain.TransResponse{Status: TransOK}},
+		{Response: domain.TransResponse{Status: TransError}, Err: err},
+		{Response: domain.TransResponse{Status: TransOK}},
+	}
+	assert.Equal(t, expected, results)
+	m.AssertExpectations(t)
+}
+
+func TestTransBatchInteractorPanic(t *testing.T) {
+	command := domain.TransCommand{Command: "get_ad"}
+	m := &MockTransUsecase{}
+	m.On("ExecuteCommand", command).Run(func(mock.Arguments) { panic("boom") })
+	interactor := TransBatchInteractor{Interactor: m}
+
+	results := interactor.ExecuteBatch(context.Background(), []domain.TransCommand{command})
+	assert.Len(t, results, 1)
+	assert.Equal(t, TransError, results[0].Response.Status)
+	assert.EqualError(t, results[0].Err, "error during execution: boom")
+}
+
+// slowTransUsecase tracks how many commands run at the same time
+type slowTransUsecase struct {
+	running int32
+	max     int32
+	mutex   sync.Mutex
+}
+
+func (u *slowTransUsecase) ExecuteCommand(ctx context.Context, command domain.TransCommand) (domain.TransResponse, error) {
+	running := atomic.AddInt32(&u.running, 1)
+	u.mutex.Lock()
+	if running > u.max {
+		u.max = running
+	}
+	u.mutex.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	atomic.AddInt32(&u.running, -1)
+	return domain.TransResponse{Status: TransOK}, nil
+}
+
+func TestTransBatchInteractorParallelism(t *testing.T) {
+	usecase := &slowTransUsecase{}
+	interactor := TransBatchInteractor{Interactor: usecase, Parallelism: 3}
+	commands := make([]domain.TransCommand, 10)
+	results := interactor.ExecuteBatch(context.Background(), commands)
+	assert.Len(t, results, 10)
+	assert.True(t, usecase.max > 1)
+	assert.True(t, usecase.max <= 3)
+}
@@ -1,6 +1,8 @@
 package usecases
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strings"
 
@@ -16,14 +18,18 @@ const TransError = "TRANS_ERROR"
 // TransDatabaseError Error while executing a database request inside a trans
 const TransDatabaseError = "TRANS_DATABASE_ERROR"
 
+// TransBusy Status given when the trans server is too busy to execute the command
+const TransBusy = "TRANS_BUSY"
+
 // TransNoCommand Error when the provided command doesn't exists
-const TransNoCommand = "TRANS_ERROR_NO_SUCH_COMMAND:Err no such command"
+const TransNoCommand = domain.TransNoCommand
 
 // ExecuteTransUsecase states:
 // As a User, I would like to execute my TransCommand on a Trans server and get the corresponding response
 // ExecuteTrans should return a response, or an appropriate error if there was a problem.
+// The command is abandoned once ctx is done.
 type ExecuteTransUsecase interface {
-	ExecuteCommand(command domain.TransCommand) (domain.TransResponse, error)
+	ExecuteCommand(ctx context.Context, command domain.TransCommand) (domain.TransResponse, error)
 }
 
 // TransInteractorLogger defines all the events a TransInteractor may
@@ -31,6 +37,12 @@ type ExecuteTransUsecase interface {
 type TransInteractorLogger interface {
 	LogBadInput(domain.TransCommand)
 	LogRepositoryError(domain.TransCommand, error)
+	LogTransBusy(domain.TransCommand)
+	LogProtocolInjection(domain.TransCommand, error)
+	LogDryRunNotAllowed(domain.TransCommand)
+	LogTransTimeout(domain.TransCommand, domain.TimeoutError)
+	LogTransResponseLimit(domain.TransCommand, domain.ResponseLimitError)
+	LogDeprecatedAlias(domain.CommandAlias, domain.TransCommand)
 }
 
 // TransInteractor implements ExecuteTransUsecase by using Repository
@@ -38,10 +50,62 @@ type TransInteractorLogger interface {
 type TransInteractor struct {
 	Logger     TransInteractorLogger
 	Repository domain.TransRepository
+	// DryRun which commands and clients may ask for dry runs
+	DryRun DryRunPolicy
+	// Schemas the params each command accepts. The commands without schema
+	// accept any param
+	Schemas map[string]domain.CommandSchema
+	// Aliases the public names the commands may be asked with. The
+	// commands without alias are sent as they are
+	Aliases map[string]domain.CommandAlias
+}
+
+// DryRunPolicy tells who may run a command without committing it. Both the
+// command and the client must be allowed
+type DryRunPolicy struct {
+	// Commands the commands that can be run without committing
+	Commands map[string]bool
+	// Clients the clients that may ask for dry runs. The client "*" allows
+	// any of them
+	Clients map[string]bool
+}
+
+// Allows tells if the client may run the command without committing it
+func (p DryRunPolicy) Allows(command, client string) bool {
+	return p.Commands[command] && (p.Clients["*"] || client != "" && p.Clients[client])
 }
 
 // ExecuteCommand executes the given TransCommand and returns the corresponding TransResponse.
+// Commands asked with an alias are sent as the trans command it stands for.
 func (interactor TransInteractor) ExecuteCommand(
+	ctx context.Context,
+	command domain.TransCommand,
+) (domain.TransResponse, error) {
+	alias, ok := interactor.Aliases[command.Command]
+	if !ok {
+		return interactor.execute(ctx, command)
+	}
+	if alias.Deprecated {
+		interactor.Logger.LogDeprecatedAlias(alias, command)
+	}
+	response, err := interactor.execute(ctx, alias.Resolve(command))
+	// the client is told about the params it sent, not the trans ones
+	var errs domain.SchemaErrors
+	if errors.As(err, &errs) {
+		err = alias.PublicErrors(command, errs)
+		response.Params["error"] = err.Error()
+		response.Fields = domain.TransFields{{Key: "error", Value: err.Error()}}
+	}
+	if alias.Deprecated {
+		response.Deprecated = true
+		response.Warnings = append(response.Warnings, fmt.Sprintf("command %s is deprecated", alias.Name))
+	}
+	return response, err
+}
+
+// execute executes the trans command
+func (interactor TransInteractor) execute(
+	ctx context.Context,
 	command domain.TransCommand,
 ) (domain.TransResponse, error) {
 	response := domain.TransResponse{
@@ -54,8 +118,79 @@ func (interactor TransInteractor) ExecuteCommand(
 		return response, fmt.Errorf("invalid command %+v", command)
 	}
 
+	// Refuse the commands that would alter the text sent to trans
+	if errs := command.CheckProtocol(); len(errs) > 0 {
+		interactor.Logger.LogProtocolInjection(command, errs)
+		response.Params["error"] = errs.Error()
+		response.Fields = domain.TransFields{{Key: "error", Value: errs.Error()}}
+		return response, errs
+	}
+
+	if command.DryRun && !interactor.DryRun.Allows(command.Command, command.Client) {
+		interactor.Logger.LogDryRunNotAllowed(command)
+		err := domain.ErrDryRunNotAllowed
+		response.Params["error"] = err.Error()
+		response.Fields = domain.TransFields{{Key: "error", Value: err.Error()}}
+		return response, err
+	}
+
+	// Refuse the params the command doesn't accept before dialing trans
+	if schema, ok := interactor.Schemas[command.Command]; ok {
+		if errs := schema.Validate(command.Params); len(errs) > 0 {
+			interactor.Logger.LogBadInput(command)
+			response.Params["error"] = errs.Error()
+			response.Fields = domain.TransFields{{Key: "error", Value: errs.Error()}}
+			return response, errs
+		}
+		command.Params = schema.Normalize(command.Params)
+	}
+
 	// Execute the command and retrieve the response
-	response, err := interactor.Repository.Execute(command)
+	response, err := interactor.Repository.Execute(ctx, command)
+	// the command may be sent again later, keep the error as is so the
+	// caller can tell
+	if errors.Is(err, domain.ErrTransBusy) {
+		interactor.Logger.LogTransBusy(command)
+		response.Status = TransBusy
+		return response, err
+	}
+	// trans can't be reached, the command may be sent again later too
+	if errors.Is(err, domain.ErrTransUnavailable) {
+		interactor.Logger.LogRepositoryError(command, err)
+		response.Status = TransError
+		return response, err
+	}
+	// a phase of the command took too long, the error tells which one
+	var timeout domain.TimeoutError
+	if errors.As(err, &timeout) {
+		interactor.Logger.LogTransTimeout(command, timeout)
+		response.Status = TransError
+		return response, err
+	}
+	// the command was abandoned, the client went away or its deadline
+	// passed. It's not an error of trans
+	if err != nil && (ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+		if ctx.Err() != nil && !errors.Is(err, ctx.Err()) {
+			err = fmt.Errorf("%w: %s", ctx.Err(), err)
+		}
+		response.Status = TransError
+		return response, err
+	}
+	// the response was too large to be read, the error tells which limit
+	var limit domain.ResponseLimitError
+	if errors.As(err, &limit) {
+		interactor.Logger.LogTransResponseLimit(command, limit)
+		response.Status = TransError
+		return response, err
+	}
+	// the params can't be sent, the error tells the caller which ones
+	var paramErrors domain.ParamErrors
+	var encodingErrors domain.EncodingErrors
+	if errors.As(err, &paramErrors) || errors.As(err, &encodingErrors) {
+		interactor.Logger.LogBadInput(command)
+		response.Status = TransError
+		return response, err
+	}
 	if err != nil {
 		// Report the error
 		interactor.Logger.LogRepositoryError(command, err)
@@ -70,6 +205,7 @@ func (interactor TransInteractor) ExecuteCommand(
 		err = fmt.Errorf("error command doesn't exists")
 		response.Status = TransError
 		response.Params["error"] = err.Error()
+		response.Fields = append(response.Fields, domain.TransField{Key: "error", Value: err.Error()})
 	}
 	// if the error is a database error
 	if strings.Contains(response.Status, TransDatabaseError) {
@@ -80,6 +216,7 @@ func (interactor TransInteractor) ExecuteCommand(
 		interactor.Logger.LogRepositoryError(command, err)
 		response.Status = TransDatabaseError
 		response.Params["error"] = err.Error()
+		response.Fields = append(response.Fields, domain.TransField{Key: "error", Value: err.Error()})
 	}
 
 	return response, err
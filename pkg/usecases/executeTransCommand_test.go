@@ -1,9 +1,12 @@
 package usecases
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -14,7 +17,7 @@ type MockTransRepository struct {
 	mock.Mock
 }
 
-func (m *MockTransRepository) Execute(command domain.TransCommand) (domain.TransResponse, error) {
+func (m *MockTransRepository) Execute(ctx context.Context, command domain.TransCommand) (domain.TransResponse, error) {
 	ret := m.Called(command)
 	return ret.Get(0).(domain.TransResponse), ret.Error(1)
 }
@@ -31,6 +34,30 @@ func (m *MockTransInteractorLogger) LogRepositoryError(c domain.TransCommand, er
 	m.Called(c, err)
 }
 
+func (m *MockTransInteractorLogger) LogTransBusy(c domain.TransCommand) {
+	m.Called(c)
+}
+
+func (m *MockTransInteractorLogger) LogProtocolInjection(c domain.TransCommand, err error) {
+	m.Called(c, err)
+}
+
+func (m *MockTransInteractorLogger) LogDryRunNotAllowed(c domain.TransCommand) {
+	m.Called(c)
+}
+
+func (m *MockTransInteractorLogger) LogTransTimeout(c domain.TransCommand, err domain.TimeoutError) {
+	m.Called(c, err)
+}
+
+func (m *MockTransInteractorLogger) LogTransResponseLimit(c domain.TransCommand, err domain.ResponseLimitError) {
+	m.Called(c, err)
+}
+
+func (m *MockTransInteractorLogger) LogDeprecatedAlias(alias domain.CommandAlias, c domain.TransCommand) {
+	m.Called(alias, c)
+}
+
 func TestTransInteractorInvalidCommand(t *testing.T) {
 	logger := &MockTransInteractorLogger{}
 	repo := &MockTransRepository{}
@@ -41,7 +68,7 @@ func TestTransInteractorInvalidCommand(t *testing.T) {
 	command := domain.TransCommand{}
 	logger.On("LogBadInput", command)
 
-	_, err := interactor.ExecuteCommand(command)
+	_, err := interactor.ExecuteCommand(context.Background(), command)
 	assert.Error(t, err)
 	repo.AssertExpectations(t)
 	logger.AssertExpectations(t)
@@ -49,7 +76,7 @@ func TestTransInteractorInvalidCommand(t *testing.T) {
 
 func TestTransInteractorRepositoryError(t *testing.T) {
 	command := domain.TransCommand{
-		Command: "command 1",
+		Command: "command_1",
 	}
 	response := domain.TransResponse{}
 	err := errors.New("error")
@@ -62,7 +89,7 @@ func TestTransInteractorRepositoryError(t *testing.T) {
 	}
 	logger.On("LogRepositoryError", command, err).Once()
 	expectedErr := fmt.Errorf("error during execution")
-	returnResp, returnErr := interactor.ExecuteCommand(command)
+	returnResp, returnErr := interactor.ExecuteCommand(context.Background(), command)
 	assert.Error(t, returnErr)
 	assert.Equal(t, expectedErr, returnErr)
 	assert.Equal(t, response, returnResp)
@@ -72,7 +99,7 @@ func TestTransInteractorRepositoryError(t *testing.T) {
 
 func TestTransInteractorTransNoCommand(t *testing.T) {
 	command := domain.TransCommand{
-		Command: "command 1",
+		Command: "command_1",
 	}
 	err := errors.New("error command doesn't exists")
 	response := domain.TransResponse{
@@ -94,7 +121,8 @@ func TestTransInteractorTransNoCommand(t *testing.T) {
 		Params: make(map[string]string),
 	}
 	expectedResponse.Params["error"] = expectedErr.Error()
-	returnResp, returnErr := interactor.ExecuteCommand(command)
+	expectedResponse.Fields = domain.TransFields{{Key: "error", Value: expectedErr.Error()}}
+	returnResp, returnErr := interactor.ExecuteCommand(context.Background(), command)
 	assert.Error(t, returnErr)
 	assert.Equal(t, expectedErr, returnErr)
 	assert.Equal(t, expectedResponse, returnResp)
@@ -104,7 +132,7 @@ func TestTransInteractorTransNoCommand(t *testing.T) {
 
 func TestTransInteractorTransDatabaseError(t *testing.T) {
 	command := domain.TransCommand{
-		Command: "command 1",
+		Command: "command_1",
 	}
 	errorStringDB := "ERROR EXECUTING QUERY"
 	errorString := "Trans Database error"
@@ -130,7 +158,8 @@ func TestTransInteractorTransDatabaseError(t *testing.T) {
 		Params: make(map[string]string),
 	}
 	expectedResponse.Params["error"] = errorStringDB
-	returnResp, returnErr := interactor.ExecuteCommand(command)
+	expectedResponse.Fields = domain.TransFields{{Key: "error", Value: errorStringDB}}
+	returnResp, returnErr := interactor.ExecuteCommand(context.Background(), command)
 
 	assert.Error(t, returnErr)
 	assert.Equal(t, errDB, returnErr)
@@ -141,7 +170,7 @@ func TestTransInteractorTransDatabaseError(t *testing.T) {
 
 func TestTransInteractorExecuteCommandOK(t *testing.T) {
 	command := domain.TransCommand{
-		Command: "command 1",
+		Command: "command_1",
 	}
 	response := domain.TransResponse{
 		Status: TransOK,
@@ -153,9 +182,510 @@ func TestTransInteractorExecuteCommandOK(t *testing.T) {
 		Logger:     logger,
 		Repository: repo,
 	}
-	returnResp, returnErr := interactor.ExecuteCommand(command)
+	returnResp, returnErr := interactor.ExecuteCommand(context.Background(), command)
 	assert.NoError(t, returnErr)
 	assert.Equal(t, response, returnResp)
 	repo.AssertExpectations(t)
 	logger.AssertExpectations(t)
 }
+
+func TestTransInteractorTransBusy(t *testing.T) {
+	command := domain.TransCommand{
+		Command: "command_1",
+	}
+	response := domain.TransResponse{
+		Params: map[string]string{"error": domain.ErrTransBusy.Error()},
+	}
+	logger := &MockTransInteractorLogger{}
+	repo := &MockTransRepository{}
+	repo.On("Execute", command).Return(response, domain.ErrTransBusy).Once()
+	interactor := TransInteractor{
+		Logger:     logger,
+		Repository: repo,
+	}
+	logger.On("LogTransBusy", command).Once()
+	expectedResponse := domain.TransResponse{
+		Status: TransBusy,
+		Params: map[string]string{"error": domain.ErrTransBusy.Error()},
+	}
+	returnResp, returnErr := interactor.ExecuteCommand(context.Background(), command)
+	assert.Equal(t, domain.ErrTransBusy, returnErr)
+	assert.Equal(t, expectedResponse, returnResp)
+	repo.AssertExpectations(t)
+	logger.AssertExpectations(t)
+}
+
+func TestTransInteractorTransUnavailable(t *testing.T) {
+	command := domain.TransCommand{
+		Command: "command_1",
+	}
+	err := fmt.Errorf("circuit breaker is open: %w", domain.ErrTransUnavailable)
+	response := domain.TransResponse{
+		Params: map[string]string{"error": err.Error()},
+	}
+	logger := &MockTransInteractorLogger{}
+	repo := &MockTransRepository{}
+	repo.On("Execute", command).Return(response, err).Once()
+	interactor := TransInteractor{
+		Logger:     logger,
+		Repository: repo,
+	}
+	logger.On("LogRepositoryError", command, err).Once()
+	expectedResponse := domain.TransResponse{
+		Status: TransError,
+		Params: map[string]string{"error": err.Error()},
+	}
+	returnResp, returnErr := interactor.ExecuteCommand(context.Background(), command)
+	assert.Equal(t, err, returnErr)
+	assert.Equal(t, expectedResponse, returnResp)
+	repo.AssertExpectations(t)
+	logger.AssertExpectations(t)
+}
+
+func TestTransInteractorTimeout(t *testing.T) {
+	command := domain.TransCommand{
+		Command: "command_1",
+	}
+	err := domain.TimeoutError{Phase: "greeting", Timeout: time.Second}
+	response := domain.TransResponse{
+		Params: map[string]string{"error": err.Error()},
+	}
+	logger := &MockTransInteractorLogger{}
+	repo := &MockTransRepository{}
+	repo.On("Execute", command).Return(response, err).Once()
+	interactor := TransInteractor{
+		Logger:     logger,
+		Repository: repo,
+	}
+	logger.On("LogTransTimeout", command, err).Once()
+	expectedResponse := domain.TransResponse{
+		Status: TransError,
+		Params: map[string]string{"error": "trans timeout in the greeting phase after 1s"},
+	}
+	returnResp, returnErr := interactor.ExecuteCommand(context.Background(), command)
+	assert.Equal(t, err, returnErr)
+	assert.Equal(t, expectedResponse, returnResp)
+	repo.AssertExpectations(t)
+	logger.AssertExpectations(t)
+}
+
+func TestTransInteractorCanceled(t *testing.T) {
+	command := domain.TransCommand{
+		Command: "command_1",
+	}
+	logger := &MockTransInteractorLogger{}
+	repo := &MockTransRepository{}
+	repo.On("Execute", command).Return(domain.TransResponse{}, errors.New("use of closed network connection")).Once()
+	interactor := TransInteractor{
+		Logger:     logger,
+		Repository: repo,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	returnResp, returnErr := interactor.ExecuteCommand(ctx, command)
+	assert.ErrorIs(t, returnErr, context.Canceled)
+	assert.Equal(t, "context canceled: use of closed network connection", returnErr.Error())
+	assert.Equal(t, TransError, returnResp.Status)
+	repo.AssertExpectations(t)
+	logger.AssertExpectations(t)
+}
+
+func TestTransInteractorDeadlineExceeded(t *testing.T) {
+	command := domain.TransCommand{
+		Command: "command_1",
+	}
+	logger := &MockTransInteractorLogger{}
+	repo := &MockTransRepository{}
+	repo.On("Execute", command).Return(domain.TransResponse{}, context.DeadlineExceeded).Once()
+	interactor := TransInteractor{
+		Logger:     logger,
+		Repository: repo,
+	}
+	returnResp, returnErr := interactor.ExecuteCommand(context.Background(), command)
+	assert.Equal(t, context.DeadlineExceeded, returnErr)
+	assert.Equal(t, TransError, returnResp.Status)
+	repo.AssertExpectations(t)
+	logger.AssertExpectations(t)
+}
+
+func TestTransInteractorResponseLimit(t *testing.T) {
+	command := domain.TransCommand{
+		Command: "command_1",
+	}
+	err := domain.ResponseLimitError{Limit: "lines", Max: 10}
+	logger := &MockTransInteractorLogger{}
+	repo := &MockTransRepository{}
+	repo.On("Execute", command).Return(domain.TransResponse{}, err).Once()
+	interactor := TransInteractor{
+		Logger:     logger,
+		Repository: repo,
+	}
+	logger.On("LogTransResponseLimit", command, err).Once()
+	returnResp, returnErr := interactor.ExecuteCommand(context.Background(), command)
+	assert.Equal(t, err, returnErr)
+	assert.Equal(t, domain.TransResponse{Status: TransError}, returnResp)
+	repo.AssertExpectations(t)
+	logger.AssertExpectations(t)
+}
+
+func TestTransInteractorInvalidParams(t *testing.T) {
+	command := domain.TransCommand{
+		Command: "command_1",
+		Params:  []domain.TransParams{{Key: "ad", Value: map[string]interface{}{}}},
+	}
+	paramErrors := domain.ParamErrors{{Key: "ad", Reason: "unsupported type map[string]interface {}"}}
+	response := domain.TransResponse{
+		Params: map[string]string{"error": paramErrors.Error()},
+	}
+	logger := &MockTransInteractorLogger{}
+	repo := &MockTransRepository{}
+	repo.On("Execute", command).Return(response, paramErrors).Once()
+	interactor := TransInteractor{
+		Logger:     logger,
+		Repository: repo,
+	}
+	logger.On("LogBadInput", command).Once()
+	expectedResponse := domain.TransResponse{
+		Status: TransError,
+		Params: map[string]string{"error": paramErrors.Error()},
+	}
+	returnResp, returnErr := interactor.ExecuteCommand(context.Background(), command)
+	assert.Equal(t, paramErrors, returnErr)
+	assert.Equal(t, expectedResponse, returnResp)
+	repo.AssertExpectations(t)
+	logger.AssertExpectations(t)
+}
+
+func TestTransInteractorProtocolInjection(t *testing.T) {
+	command := domain.TransCommand{
+		Command: "get_ad",
+		Params: []domain.TransParams{
+			{Key: "ad_id", Value: "1\ncommit:1\nend\ncmd:delete_ad"},
+		},
+	}
+	logger := &MockTransInteractorLogger{}
+	repo := &MockTransRepository{}
+	interactor := TransInteractor{
+		Logger:     logger,
+		Repository: repo,
+	}
+	expectedErr := domain.ParamErrors{{Key: "ad_id", Reason: "value has newlines, send it as a blob"}}
+	logger.On("LogProtocolInjection", command, expectedErr).Once()
+	expectedResponse := domain.TransResponse{
+		Status: TransError,
+		Params: map[string]string{"error": expectedErr.Error()},
+		Fields: domain.TransFields{{Key: "error", Value: expectedErr.Error()}},
+	}
+	returnResp, returnErr := interactor.ExecuteCommand(context.Background(), command)
+	assert.Equal(t, expectedErr, returnErr)
+	assert.Equal(t, expectedResponse, returnResp)
+	repo.AssertExpectations(t)
+	logger.AssertExpectations(t)
+}
+
+func TestTransInteractorDryRunNotAllowed(t *testing.T) {
+	policy := DryRunPolicy{
+		Commands: map[string]bool{"newad": true},
+		Clients:  map[string]bool{"backoffice": true},
+	}
+	commands := []domain.TransCommand{
+		{Command: "delete_ad", DryRun: true, Client: "backoffice"},
+		{Command: "newad", DryRun: true, Client: "mobile"},
+		{Command: "newad", DryRun: true},
+	}
+	for _, command := range commands {
+		logger := &MockTransInteractorLogger{}
+		repo := &MockTransRepository{}
+		interactor := TransInteractor{
+			Logger:     logger,
+			Repository: repo,
+			DryRun:     policy,
+		}
+		logger.On("LogDryRunNotAllowed", command).Once()
+		returnResp, returnErr := interactor.ExecuteCommand(context.Background(), command)
+		assert.Equal(t, domain.ErrDryRunNotAllowed, returnErr)
+		assert.Equal(t, TransError, returnResp.Status)
+		assert.Equal(t, domain.ErrDryRunNotAllowed.Error(), returnResp.Params["error"])
+		repo.AssertExpectations(t)
+		logger.AssertExpectations(t)
+	}
+}
+
+func TestTransInteractorDryRun(t *testing.T) {
+	command := domain.TransCommand{
+		Command: "newad",
+		DryRun:  true,
+		Client:  "backoffice",
+	}
+	response := domain.TransResponse{
+		Status: TransOK,
+		Params: map[string]string{},
+	}
+	logger := &MockTransInteractorLogger{}
+	repo := &MockTransRepository{}
+	repo.On("Execute", command).Return(response, nil).Once()
+	interactor := TransInteractor{
+		Logger:     logger,
+		Repository: repo,
+		DryRun: DryRunPolicy{
+			Commands: map[string]bool{"newad": true},
+			Clients:  map[string]bool{"*": true},
+		},
+	}
+	returnResp, returnErr := interactor.ExecuteCommand(context.Background(), command)
+	assert.NoError(t, returnErr)
+	assert.Equal(t, response, returnResp)
+	repo.AssertExpectations(t)
+	logger.AssertExpectations(t)
+}
+
+func TestTransInteractorParamSchema(t *testing.T) {
+	schema, err := domain.NewCommandSchema([]domain.ParamSchema{
+		{Name: "ad_id", Type: domain.ParamInt, Required: true},
+		{Name: "lang", Enum: []string{"es", "en"}},
+		{Name: "email", Pattern: `[^@]+@[^@]+`, MaxLength: 10},
+		{Name: "price", Type: domain.ParamFloat},
+		{Name: "active", Type: domain.ParamBool},
+		{Name: "image", Blob: true, MaxLength: 3},
+	})
+	assert.NoError(t, err)
+	cases := []struct {
+		name   string
+		params []domain.TransParams
+		// sent the params sent to trans, the given ones if nil
+		sent []domain.TransParams
+		errs domain.SchemaErrors
+	}{
+		{"valid", []domain.TransParams{
+			{Key: "ad_id", Value: json.Number("12")},
+			{Key: "lang", Value: "es"},
+			{Key: "email", Value: "a@b.cl"},
+			{Key: "price", Value: "9.5"},
+			{Key: "active", Value: true},
+			{Key: "image", Value: "YWJj", Blob: true},
+		}, nil, nil},
+		// booleans sent as strings are written like the JSON ones
+		{"numbers as strings", []domain.TransParams{
+			{Key: "ad_id", Value: "12"},
+			{Key: "price", Value: 9.5},
+			{Key: "active", Value: "0"},
+		}, []domain.TransParams{
+			{Key: "ad_id", Value: "12"},
+			{Key: "price", Value: 9.5},
+			{Key: "active", Value: false},
+		}, nil},
+		{"booleans as words", []domain.TransParams{
+			{Key: "ad_id", Value: "12"},
+			{Key: "active", Value: "true"},
+		}, []domain.TransParams{
+			{Key: "ad_id", Value: "12"},
+			{Key: "active", Value: true},
+		}, nil},
+		{"missing", []domain.TransParams{{Key: "lang", Value: "en"}, {Key: "ad_id", Value: nil}},
+			nil, domain.SchemaErrors{{Key: "ad_id", Reason: "required"}}},
+		{"every violation", []domain.TransParams{
+			{Key: "subject", Value: "car"},
+			{Key: "lang", Value: "fr"},
+			{Key: "email", Value: "nobody"},
+			{Key: "price", Value: "cheap"},
+			{Key: "active", Value: "yes"},
+			{Key: "image", Value: "YWJjZA==", Blob: true},
+			{Key: "ad_id", Value: 1.5},
+		}, nil, domain.SchemaErrors{
+			{Key: "active", Reason: "must be a boolean"},
+			{Key: "ad_id", Reason: "must be an integer"},
+			{Key: "email", Reason: "doesn't match [^@]+@[^@]+"},
+			{Key: "image", Reason: "longer than 3 bytes"},
+			{Key: "lang", Reason: "must be one of es, en"},
+			{Key: "price", Reason: "must be a number"},
+			{Key: "subject", Reason: "unknown param"},
+		}},
+		{"length and blobs", []domain.TransParams{
+			{Key: "ad_id", Value: "1"},
+			{Key: "email", Value: "ñandú@mail.cl"},
+			{Key: "lang", Value: "ZXM=", Blob: true},
+			{Key: "image", Value: "abc"},
+		}, nil, domain.SchemaErrors{
+			{Key: "email", Reason: "longer than 10 characters"},
+			{Key: "image", Reason: "must be a blob"},
+			{Key: "lang", Reason: "can't be a blob"},
+		}},
+		{"invalid blobs", []domain.TransParams{
+			{Key: "ad_id", Value: "x"},
+			{Key: "image", Value: "!!", Blob: true},
+		}, nil, domain.SchemaErrors{
+			{Key: "ad_id", Reason: "must be an integer"},
+			{Key: "image", Reason: "must be base64"},
+		}},
+		{"blob not a string", []domain.TransParams{
+			{Key: "ad_id", Value: "1"},
+			{Key: "image", Value: 12, Blob: true},
+		}, nil, domain.SchemaErrors{{Key: "image", Reason: "must be a base64 string"}}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			command := domain.TransCommand{Command: "get_ad", Params: c.params}
+			logger := &MockTransInteractorLogger{}
+			repo := &MockTransRepository{}
+			interactor := TransInteractor{
+				Logger:     logger,
+				Repository: repo,
+				Schemas:    map[string]domain.CommandSchema{"get_ad": schema},
+			}
+			if c.errs == nil {
+				sent := command
+				if c.sent != nil {
+					sent.Params = c.sent
+				}
+				repo.On("Execute", sent).Return(domain.TransResponse{Status: TransOK}, nil).Once()
+			} else {
+				logger.On("LogBadInput", command).Once()
+			}
+			returnResp, returnErr := interactor.ExecuteCommand(context.Background(), command)
+			if c.errs == nil {
+				assert.NoError(t, returnErr)
+			} else {
+				assert.Equal(t, c.errs, returnErr)
+				assert.Equal(t, TransError, returnResp.Status)
+				assert.Equal(t, c.errs.Error(), returnResp.Params["error"])
+			}
+			repo.AssertExpectations(t)
+			logger.AssertExpectations(t)
+		})
+	}
+}
+
+func TestTransInteractorNoParamSchema(t *testing.T) {
+	schema, err := domain.NewCommandSchema([]domain.ParamSchema{})
+	assert.NoError(t, err)
+	command := domain.TransCommand{Command: "transinfo", Params: []domain.TransParams{{Key: "verbose", Value: "1"}}}
+	logger := &MockTransInteractorLogger{}
+	repo := &MockTransRepository{}
+	repo.On("Execute", command).Return(domain.TransResponse{Status: TransOK}, nil).Once()
+	interactor := TransInteractor{
+		Logger:     logger,
+		Repository: repo,
+		// commands without schema accept any param, an empty one none
+		Schemas: map[string]domain.CommandSchema{"newad": schema},
+	}
+	_, returnErr := interactor.ExecuteCommand(context.Background(), command)
+	assert.NoError(t, returnErr)
+
+	command.Command = "newad"
+	logger.On("LogBadInput", command).Once()
+	_, returnErr = interactor.ExecuteCommand(context.Background(), command)
+	assert.Equal(t, domain.SchemaErrors{{Key: "verbose", Reason: "unknown param"}}, returnErr)
+	repo.AssertExpectations(t)
+	logger.AssertExpectations(t)
+}
+
+func TestTransInteractorAlias(t *testing.T) {
+	aliases := map[string]domain.CommandAlias{
+		"ad@v2": {Name: "ad@v2", Command: "get_ad", Params: map[string]string{"id": "ad_id"}},
+		"ad@v1": {Name: "ad@v1", Command: "get_ad", Deprecated: true},
+	}
+	sent := domain.TransCommand{
+		Command: "get_ad",
+		Params:  []domain.TransParams{{Key: "ad_id", Value: "1"}, {Key: "lang", Value: "es"}},
+		Client:  "mobile",
+	}
+	response := domain.TransResponse{
+		Status: TransOK,
+		Params: map[string]string{},
+	}
+	logger := &MockTransInteractorLogger{}
+	repo := &MockTransRepository{}
+	repo.On("Execute", sent).Return(response, nil).Twice()
+	interactor := TransInteractor{
+		Logger:     logger,
+		Repository: repo,
+		Aliases:    aliases,
+	}
+
+	command := domain.TransCommand{
+		Command: "ad@v2",
+		Params:  []domain.TransParams{{Key: "id", Value: "1"}, {Key: "lang", Value: "es"}},
+		Client:  "mobile",
+	}
+	returnResp, returnErr := interactor.ExecuteCommand(context.Background(), command)
+	assert.NoError(t, returnErr)
+	assert.Equal(t, response, returnResp)
+	// the params of the caller are not renamed in place
+	assert.Equal(t, "id", command.Params[0].Key)
+
+	command = domain.TransCommand{Command: "ad@v1", Params: sent.Params, Client: "mobile"}
+	logger.On("LogDeprecatedAlias", aliases["ad@v1"], command).Once()
+	returnResp, returnErr = interactor.ExecuteCommand(context.Background(), command)
+	assert.NoError(t, returnErr)
+	assert.True(t, returnResp.Deprecated)
+	assert.Equal(t, []string{"command ad@v1 is deprecated"}, returnResp.Warnings)
+	repo.AssertExpectations(t)
+	logger.AssertExpectations(t)
+}
+
+func TestTransInteractorAliasSchema(t *testing.T) {
+	schema, err := domain.NewCommandSchema([]domain.ParamSchema{
+		{Name: "ad_id", Type: domain.ParamInt, Required: true},
+		{Name: "list_id", Type: domain.ParamInt, Required: true},
+		{Name: "lang", Enum: []string{"es", "en"}},
+	})
+	assert.NoError(t, err)
+	alias := domain.CommandAlias{
+		Name:    "ad@v2",
+		Command: "get_ad",
+		Params:  map[string]string{"id": "ad_id", "list": "list_id", "language": "lang"},
+	}
+	command := domain.TransCommand{
+		Command: "ad@v2",
+		Params: []domain.TransParams{
+			{Key: "id", Value: "x"},
+			{Key: "lang", Value: "fr"},
+			{Key: "extra", Value: "1"},
+		},
+	}
+	logger := &MockTransInteractorLogger{}
+	repo := &MockTransRepository{}
+	interactor := TransInteractor{
+		Logger:     logger,
+		Repository: repo,
+		Schemas:    map[string]domain.CommandSchema{"get_ad": schema},
+		Aliases:    map[string]domain.CommandAlias{"ad@v2": alias},
+	}
+	logger.On("LogBadInput", alias.Resolve(command)).Once()
+	returnResp, returnErr := interactor.ExecuteCommand(context.Background(), command)
+	// the errors name the params as the client sent them, or as the alias
+	// names them when they are missing
+	errs := domain.SchemaErrors{
+		{Key: "extra", Reason: "unknown param"},
+		{Key: "id", Reason: "must be an integer"},
+		{Key: "lang", Reason: "must be one of es, en"},
+		{Key: "list", Reason: "required"},
+	}
+	assert.Equal(t, errs, returnErr)
+	assert.Equal(t, errs.Error(), returnResp.Params["error"])
+	assert.Equal(t, domain.TransFields{{Key: "error", Value: errs.Error()}}, returnResp.Fields)
+	repo.AssertExpectations(t)
+	logger.AssertExpectations(t)
+}
+
+func TestTransInteractorAliasError(t *testing.T) {
+	alias := domain.CommandAlias{Name: "ad@v1", Command: "get_ad", Deprecated: true}
+	command := domain.TransCommand{Command: "ad@v1"}
+	logger := &MockTransInteractorLogger{}
+	repo := &MockTransRepository{}
+	repo.On("Execute", domain.TransCommand{Command: "get_ad"}).Return(domain.TransResponse{}, domain.ErrTransBusy).Once()
+	interactor := TransInteractor{
+		Logger:     logger,
+		Repository: repo,
+		Aliases:    map[string]domain.CommandAlias{"ad@v1": alias},
+	}
+	logger.On("LogDeprecatedAlias", alias, command).Once()
+	logger.On("LogTransBusy", domain.TransCommand{Command: "get_ad"}).Once()
+	returnResp, returnErr := interactor.ExecuteCommand(context.Background(), command)
+	// failed commands are told they are deprecated too
+	assert.Equal(t, domain.ErrTransBusy, returnErr)
+	assert.Equal(t, TransBusy, returnResp.Status)
+	assert.True(t, returnResp.Deprecated)
+	repo.AssertExpectations(t)
+	logger.AssertExpectations(t)
+}
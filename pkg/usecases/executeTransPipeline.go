@@ -0,0 +1,158 @@
+package usecases
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/domain"
+)
+
+const (
+	// PipelineStop stops the pipeline at the failing step
+	PipelineStop = "stop"
+	// PipelineContinue goes on with the next step. The steps that reference
+	// the failing one fail too
+	PipelineContinue = "continue"
+)
+
+// pipelineReference matches the references to the params of earlier steps,
+// like ${create.ad_id} or ${0.ad_id}, and the escaped ones like $${price},
+// that are the literal text ${price}
+var pipelineReference = regexp.MustCompile(`\$?\$\{([^}]*)\}`) // nolint: gochecknoglobals
+
+// ExecuteTransPipelineUsecase states:
+// As a User, I would like to execute several TransCommands one after the
+// other, using the response of a command in the params of the next ones
+type ExecuteTransPipelineUsecase interface {
+	ExecutePipeline(ctx context.Context, pipeline TransPipeline) TransPipelineResult
+}
+
+// TransPipeline is a list of commands run in order. The string params of a
+// step may reference the params of the response of an earlier step, as
+// ${step.key}, where step is the name or the index of the step
+type TransPipeline struct {
+	Steps []TransPipelineStep
+	// OnError what to do when a step fails: PipelineStop or
+	// PipelineContinue. PipelineStop if empty
+	OnError string
+}
+
+// TransPipelineStep a command of the pipeline
+type TransPipelineStep struct {
+	// Name the name other steps reference this one with, optional
+	Name    string
+	Command domain.TransCommand
+	// OnError overrides the OnError policy of the pipeline for this step
+	OnError string
+}
+
+// TransPipelineResult tells how far the pipeline got
+type TransPipelineResult struct {
+	// Steps the result of each step that was run, in order
+	Steps []TransBatchResult
+	// Completed number of steps that succeeded
+	Completed int
+	// StoppedAt index of the step that stopped the pipeline, -1 if it
+	// was not stopped
+	StoppedAt int
+}
+
+// TransPipelineInteractor implements ExecuteTransPipelineUsecase by running
+// the steps through Interactor
+type TransPipelineInteractor struct {
+	Interactor ExecuteTransUsecase
+}
+
+// ExecutePipeline runs the steps of the pipeline in order, until one fails
+// with the PipelineStop policy
+func (interactor TransPipelineInteractor) ExecutePipeline(ctx context.Context, pipeline TransPipeline) TransPipelineResult {
+	result := TransPipelineResult{StoppedAt: -1}
+	// the params of the steps that succeeded, by name and index
+	params := make(map[string]map[string]string)
+	for i, step := range pipeline.Steps {
+		var stepResult TransBatchResult
+		command, err := resolvePipelineReferences(step.Command, params)
+		if err != nil {
+			stepResult.Response = domain.TransResponse{
+				Status: TransError,
+				Params: map[string]string{"error": err.Error()},
+				Fields: domain.TransFields{{Key: "error", Value: err.Error()}},
+			}
+			stepResult.Err = err
+		} else {
+			stepResult.Response, stepResult.Err = interactor.Interactor.ExecuteCommand(ctx, command)
+		}
+		result.Steps = append(result.Steps, stepResult)
+		if !transFailed(stepResult.Response, stepResult.Err) {
+			result.Completed++
+			params[strconv.Itoa(i)] = stepResult.Response.Params
+			if step.Name != "" {
+				params[step.Name] = stepResult.Response.Params
+			}
+			continue
+		}
+		onError := step.OnError
+		if onError == "" {
+			onError = pipeline.OnError
+		}
+		if onError != PipelineContinue {
+			result.StoppedAt = i
+			break
+		}
+	}
+	return result
+}
+
+// transFailed tells if the execution of a command failed, either because
+// it couldn't be executed or because trans answered with an error
+func transFailed(response domain.TransResponse, err error) bool {
+	_, hasError := response.Params["error"]
+	return err != nil || hasError ||
+		response.Status == TransError ||
+		response.Status == TransDatabaseError
+}
+
+// resolvePipelineReferences returns a copy of the command with the
+// references in its string params replaced by the values they point to,
+// and the escaped ones by their literal text. The references that can't be
+// resolved are returned as domain.ParamErrors
+func resolvePipelineReferences(
+	command domain.TransCommand,
+	params map[string]map[string]string,
+) (domain.TransCommand, error) {
+	if len(command.Params) == 0 {
+		return command, nil
+	}
+	var errs domain.ParamErrors
+	resolved := make([]domain.TransParams, 0, len(command.Params))
+	for _, param := range command.Params {
+		if value, ok := param.Value.(string); ok {
+			param.Value = pipelineReference.ReplaceAllStringFunc(value, func(reference string) string {
+				if strings.HasPrefix(reference, "$$") {
+					return reference[1:]
+				}
+				path := pipelineReference.FindStringSubmatch(reference)[1]
+				parts := strings.SplitN(path, ".", 2)
+				if len(parts) == 2 {
+					if value, ok := params[parts[0]][parts[1]]; ok {
+						return value
+					}
+				}
+				errs = append(errs, domain.ParamError{
+					Key:    param.Key,
+					Reason: fmt.Sprintf("reference %s not found in the earlier steps", reference),
+				})
+				return reference
+			})
+		}
+		resolved = append(resolved, param)
+	}
+	if len(errs) > 0 {
+		return command, errs
+	}
+	command.Params = resolved
+	return command, nil
+}
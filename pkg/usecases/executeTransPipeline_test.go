@@ -0,0 +1,114 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/yapo_team/legacy/commons/trans-proxy/pkg/domain"
+)
+
+func TestTransPipelineInteractorReferences(t *testing.T) {
+	m := &MockTransUsecase{}
+	created := domain.TransResponse{Status: TransOK, Params: map[string]string{"ad_id": "10"}}
+	attached := domain.TransResponse{Status: TransOK, Params: map[string]string{}}
+	m.On("ExecuteCommand", domain.TransCommand{
+		Command: "newad",
+		Params:  []domain.TransParams{{Key: "subject", Value: "car"}},
+	}).Return(created, nil).Once()
+	m.On("ExecuteCommand", domain.TransCommand{
+		Command: "add_image",
+		Params: []domain.TransParams{
+			{Key: "ad_id", Value: "10"},
+			{Key: "name", Value: "ad-10.jpg"},
+			{Key: "main", Value: true},
+			{Key: "body", Value: "only ${price}, ad 10"},
+		},
+	}).Return(attached, nil).Once()
+	interactor := TransPipelineInteractor{Interactor: m}
+
+	result := interactor.ExecutePipeline(context.Background(), TransPipeline{
+		Steps: []TransPipelineStep{
+			{
+				Name: "create",
+				Command: domain.TransCommand{
+					Command: "newad",
+					Params:  []domain.TransParams{{Key: "subject", Value: "car"}},
+				},
+			},
+			{
+				Command: domain.TransCommand{
+					Command: "add_image",
+					Params: []domain.TransParams{
+						{Key: "ad_id", Value: "${create.ad_id}"},
+						{Key: "name", Value: "ad-${0.ad_id}.jpg"},
+						{Key: "main", Value: true},
+						// escaped references are sent as they are written
+						{Key: "body", Value: "only $${price}, ad ${create.ad_id}"},
+					},
+				},
+			},
+		},
+	})
+	expected := TransPipelineResult{
+		Steps:     []TransBatchResult{{Response: created}, {Response: attached}},
+		Completed: 2,
+		StoppedAt: -1,
+	}
+	assert.Equal(t, expected, result)
+	m.AssertExpectations(t)
+}
+
+func TestTransPipelineInteractorStop(t *testing.T) {
+	m := &MockTransUsecase{}
+	err := errors.New("error during execution")
+	failed := domain.TransResponse{Status: TransError, Params: map[string]string{"error": "no such ad"}}
+	m.On("ExecuteCommand", domain.TransCommand{Command: "get_ad"}).Return(failed, err).Once()
+	interactor := TransPipelineInteractor{Interactor: m}
+
+	result := interactor.ExecutePipeline(context.Background(), TransPipeline{
+		Steps: []TransPipelineStep{
+			{Command: domain.TransCommand{Command: "get_ad"}},
+			{Command: domain.TransCommand{Command: "delete_ad"}},
+		},
+	})
+	expected := TransPipelineResult{
+		Steps:     []TransBatchResult{{Response: failed, Err: err}},
+		StoppedAt: 0,
+	}
+	assert.Equal(t, expected, result)
+	m.AssertExpectations(t)
+}
+
+func TestTransPipelineInteractorContinue(t *testing.T) {
+	m := &MockTransUsecase{}
+	failed := domain.TransResponse{Status: TransError, Params: map[string]string{"error": "no such ad"}}
+	ok := domain.TransResponse{Status: TransOK, Params: map[string]string{}}
+	m.On("ExecuteCommand", domain.TransCommand{Command: "get_ad"}).Return(failed, nil).Once()
+	m.On("ExecuteCommand", domain.TransCommand{Command: "transinfo"}).Return(ok, nil).Once()
+	interactor := TransPipelineInteractor{Interactor: m}
+
+	result := interactor.ExecutePipeline(context.Background(), TransPipeline{
+		OnError: PipelineContinue,
+		Steps: []TransPipelineStep{
+			{Name: "ad", Command: domain.TransCommand{Command: "get_ad"}},
+			{Command: domain.TransCommand{Command: "transinfo"}},
+			{
+				Command: domain.TransCommand{
+					Command: "delete_ad",
+					Params:  []domain.TransParams{{Key: "ad_id", Value: "${ad.ad_id}"}},
+				},
+				OnError: PipelineStop,
+			},
+			{Command: domain.TransCommand{Command: "transinfo"}},
+		},
+	})
+	assert.Len(t, result.Steps, 3)
+	assert.Equal(t, 1, result.Completed)
+	assert.Equal(t, 2, result.StoppedAt)
+	expectedErr := domain.ParamErrors{{Key: "ad_id", Reason: "reference ${ad.ad_id} not found in the earlier steps"}}
+	assert.Equal(t, expectedErr, result.Steps[2].Err)
+	assert.Equal(t, TransError, result.Steps[2].Response.Status)
+	m.AssertExpectations(t)
+}